@@ -3,12 +3,106 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+
+	"loan-service-engine/jwtkeys"
+	"loan-service-engine/mailer"
+	"loan-service-engine/money"
+	"loan-service-engine/scanner"
+	"loan-service-engine/storage"
 )
 
+// LocalFilesURLPrefix is where the server serves signed links to files kept
+// on local disk.
+const LocalFilesURLPrefix = "/files/"
+
 var (
 	JwtSecret string
+	// JWTKeys signs access tokens with the key named by JWT_ACTIVE_KID and
+	// verifies tokens signed by any key in JWT_SIGNING_KEYS. With no keys
+	// configured tokens are signed with JWT_SECRET (HS256); with keys,
+	// HS256 tokens are only accepted until JWT_ACCEPT_LEGACY_HS256_UNTIL.
+	JWTKeys *jwtkeys.KeySet
+
+	// DBDriver is the database backend: sqlite (default) or postgres.
+	DBDriver string
+	// DatabaseURL is the SQLite file path or the Postgres connection string.
+	DatabaseURL string
+
+	// RepaymentWaterfall is the order in which a repayment is applied to the
+	// components of each outstanding installment.
+	RepaymentWaterfall []string
+	// LateFeeAmount is charged once on every installment still unpaid after its due date.
+	LateFeeAmount money.Amount
+
+	// FundingWindowDays is how long an approved loan stays open for investment
+	// when the requester does not pick a window.
+	FundingWindowDays int
+	// FundingExpiryInterval is how often the scheduler looks for loans past their funding deadline.
+	FundingExpiryInterval time.Duration
+
+	// AccessTokenTTL is the lifetime of the JWTs sent with every request.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a refresh token can be exchanged for new tokens.
+	RefreshTokenTTL time.Duration
+
+	// TwoFactorRequiredRoles must log in with a TOTP code to use their
+	// role's endpoints.
+	TwoFactorRequiredRoles []string
+	// TOTPIssuer is the account label shown in authenticator apps.
+	TOTPIssuer string
+
+	// LoginMaxFailures failed logins in a row lock a username out for
+	// LoginLockoutDuration, and LoginMaxFailuresPerIP lock out a client IP.
+	// Failures are forgotten after LoginLockoutDuration without another.
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginLockoutDuration  time.Duration
+	// LoginDelayBase is the wait imposed after the first failures beyond the
+	// free ones; it doubles with every further failure.
+	LoginDelayBase time.Duration
+	// TrustedProxies are the addresses or CIDR ranges whose X-Forwarded-For
+	// and X-Real-IP headers are believed. With none, the client IP used for
+	// the per-IP login limit is the address of the connection.
+	TrustedProxies []string
+
+	// EmailVerificationTTL is how long a registration's verification link stays valid.
+	EmailVerificationTTL time.Duration
+	// AdminInviteTTL is how long an admin invite can be redeemed.
+	AdminInviteTTL time.Duration
+	// PasswordResetTTL is how long a mailed password reset link stays valid.
+	PasswordResetTTL time.Duration
+
+	// Files keeps uploaded and generated documents, in STORAGE_DIR or in an
+	// S3-compatible bucket when STORAGE_BACKEND=s3.
+	Files storage.Storage
+	// FileURLTTL is how long the signed links handed out for downloads work.
+	FileURLTTL time.Duration
+	// MaxProofSize and MaxAgreementSize cap the visit proof photo and the
+	// signed agreement uploads, in bytes.
+	MaxProofSize     int64
+	MaxAgreementSize int64
+
+	// Scanner checks uploads for malware: a ClamAV daemon when
+	// SCANNER=clamd, otherwise uploads are not scanned.
+	Scanner scanner.Scanner
+	// RescanInterval is how often files quarantined because they could not
+	// be scanned are tried again.
+	RescanInterval time.Duration
+
+	// Mailer delivers emails: through the server at SMTP_ADDRESS when
+	// MAIL_SENDER=smtp, otherwise by writing them to the log, which is only
+	// fit for development.
+	Mailer mailer.Sender
+
+	// AutoMigrate applies pending schema migrations when the server starts.
+	AutoMigrate bool
+	// SeedDevData loads the demo users on startup. Development only.
+	SeedDevData bool
 )
 
 func LoadEnv(envPath ...string) {
@@ -21,6 +115,179 @@ func LoadEnv(envPath ...string) {
 		log.Fatal("Failed to load .env file")
 	}
 	JwtSecret = getEnv("JWT_SECRET", "")
+	var legacyUntil time.Time
+	if until := getEnv("JWT_ACCEPT_LEGACY_HS256_UNTIL", ""); until != "" {
+		legacyUntil, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			log.Fatal("JWT_ACCEPT_LEGACY_HS256_UNTIL must be an RFC 3339 time such as 2025-07-01T00:00:00Z")
+		}
+	}
+	JWTKeys, err = jwtkeys.Load(getEnv("JWT_SIGNING_KEYS", ""), getEnv("JWT_ACTIVE_KID", ""), JwtSecret, legacyUntil)
+	if err != nil {
+		log.Fatal("Invalid JWT key configuration: ", err)
+	}
+
+	DBDriver = getEnv("DB_DRIVER", "sqlite")
+	DatabaseURL = getEnv("DATABASE_URL", "")
+	switch DBDriver {
+	case "sqlite":
+	case "postgres":
+		if DatabaseURL == "" {
+			log.Fatal("DATABASE_URL is required when DB_DRIVER=postgres")
+		}
+	default:
+		log.Fatal("DB_DRIVER must be sqlite or postgres")
+	}
+
+	RepaymentWaterfall = parseWaterfall(getEnv("REPAYMENT_WATERFALL", "fee,interest,principal"))
+	LateFeeAmount, err = money.Parse(getEnv("LATE_FEE_AMOUNT", "0"))
+	if err != nil || LateFeeAmount < 0 {
+		log.Fatal("LATE_FEE_AMOUNT must be a non-negative number")
+	}
+
+	FundingWindowDays, err = strconv.Atoi(getEnv("FUNDING_WINDOW_DAYS", "30"))
+	if err != nil || FundingWindowDays <= 0 {
+		log.Fatal("FUNDING_WINDOW_DAYS must be a positive number of days")
+	}
+	FundingExpiryInterval, err = time.ParseDuration(getEnv("FUNDING_EXPIRY_INTERVAL", "1h"))
+	if err != nil || FundingExpiryInterval <= 0 {
+		log.Fatal("FUNDING_EXPIRY_INTERVAL must be a positive duration such as 1h or 15m")
+	}
+
+	AccessTokenTTL, err = time.ParseDuration(getEnv("ACCESS_TOKEN_TTL", "15m"))
+	if err != nil || AccessTokenTTL <= 0 {
+		log.Fatal("ACCESS_TOKEN_TTL must be a positive duration such as 15m")
+	}
+	RefreshTokenTTL, err = time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "720h"))
+	if err != nil || RefreshTokenTTL <= 0 {
+		log.Fatal("REFRESH_TOKEN_TTL must be a positive duration such as 720h")
+	}
+
+	TwoFactorRequiredRoles = nil
+	for _, role := range strings.Split(getEnv("TWO_FACTOR_REQUIRED_ROLES", "admin"), ",") {
+		switch role = strings.TrimSpace(role); role {
+		case "":
+		case "requester", "investor", "admin":
+			TwoFactorRequiredRoles = append(TwoFactorRequiredRoles, role)
+		default:
+			log.Fatalf("Invalid TWO_FACTOR_REQUIRED_ROLES role %q", role)
+		}
+	}
+	TOTPIssuer = getEnv("TOTP_ISSUER", "Loan Service Engine")
+
+	LoginMaxFailures, err = strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5"))
+	if err != nil || LoginMaxFailures <= 0 {
+		log.Fatal("LOGIN_MAX_FAILURES must be a positive number")
+	}
+	LoginMaxFailuresPerIP, err = strconv.Atoi(getEnv("LOGIN_MAX_FAILURES_PER_IP", "20"))
+	if err != nil || LoginMaxFailuresPerIP <= 0 {
+		log.Fatal("LOGIN_MAX_FAILURES_PER_IP must be a positive number")
+	}
+	LoginLockoutDuration, err = time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m"))
+	if err != nil || LoginLockoutDuration <= 0 {
+		log.Fatal("LOGIN_LOCKOUT_DURATION must be a positive duration such as 15m")
+	}
+	LoginDelayBase, err = time.ParseDuration(getEnv("LOGIN_DELAY_BASE", "1s"))
+	if err != nil || LoginDelayBase < 0 {
+		log.Fatal("LOGIN_DELAY_BASE must be a duration such as 1s, or 0 to disable delays")
+	}
+	TrustedProxies = nil
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			TrustedProxies = append(TrustedProxies, proxy)
+		}
+	}
+
+	EmailVerificationTTL, err = time.ParseDuration(getEnv("EMAIL_VERIFICATION_TTL", "24h"))
+	if err != nil || EmailVerificationTTL <= 0 {
+		log.Fatal("EMAIL_VERIFICATION_TTL must be a positive duration such as 24h")
+	}
+	AdminInviteTTL, err = time.ParseDuration(getEnv("ADMIN_INVITE_TTL", "72h"))
+	if err != nil || AdminInviteTTL <= 0 {
+		log.Fatal("ADMIN_INVITE_TTL must be a positive duration such as 72h")
+	}
+	PasswordResetTTL, err = time.ParseDuration(getEnv("PASSWORD_RESET_TTL", "1h"))
+	if err != nil || PasswordResetTTL <= 0 {
+		log.Fatal("PASSWORD_RESET_TTL must be a positive duration such as 1h")
+	}
+
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		Files = storage.NewLocal(getEnv("STORAGE_DIR", "uploads"), LocalFilesURLPrefix, []byte(getEnv("STORAGE_URL_SECRET", "")))
+	case "s3":
+		Files, err = storage.NewS3(getEnv("S3_ENDPOINT", ""), getEnv("S3_REGION", "us-east-1"), getEnv("S3_BUCKET", ""),
+			getEnv("S3_ACCESS_KEY_ID", ""), getEnv("S3_SECRET_ACCESS_KEY", ""))
+		if err != nil {
+			log.Fatal("Invalid S3 storage configuration: ", err)
+		}
+	default:
+		log.Fatalf("STORAGE_BACKEND must be local or s3, got %q", backend)
+	}
+	FileURLTTL, err = time.ParseDuration(getEnv("FILE_URL_TTL", "5m"))
+	if err != nil || FileURLTTL <= 0 {
+		log.Fatal("FILE_URL_TTL must be a positive duration such as 5m")
+	}
+	MaxProofSize = parseMegabytes("MAX_PROOF_SIZE_MB", "10")
+	MaxAgreementSize = parseMegabytes("MAX_AGREEMENT_SIZE_MB", "20")
+
+	switch kind := getEnv("SCANNER", "none"); kind {
+	case "none":
+		Scanner = scanner.Disabled()
+	case "clamd":
+		timeout, err := time.ParseDuration(getEnv("SCAN_TIMEOUT", "30s"))
+		if err != nil || timeout <= 0 {
+			log.Fatal("SCAN_TIMEOUT must be a positive duration such as 30s")
+		}
+		Scanner, err = scanner.NewClamAV(getEnv("CLAMD_ADDRESS", "127.0.0.1:3310"), timeout)
+		if err != nil {
+			log.Fatal("Invalid CLAMD_ADDRESS: ", err)
+		}
+	default:
+		log.Fatalf("SCANNER must be none or clamd, got %q", kind)
+	}
+	RescanInterval, err = time.ParseDuration(getEnv("RESCAN_INTERVAL", "5m"))
+	if err != nil || RescanInterval <= 0 {
+		log.Fatal("RESCAN_INTERVAL must be a positive duration such as 5m")
+	}
+
+	switch kind := getEnv("MAIL_SENDER", "log"); kind {
+	case "log":
+		Mailer = mailer.Log()
+	case "smtp":
+		timeout, err := time.ParseDuration(getEnv("SMTP_TIMEOUT", "10s"))
+		if err != nil || timeout <= 0 {
+			log.Fatal("SMTP_TIMEOUT must be a positive duration such as 10s")
+		}
+		Mailer, err = mailer.NewSMTP(getEnv("SMTP_ADDRESS", ""), getEnv("MAIL_FROM", ""),
+			getEnv("SMTP_USERNAME", ""), getEnv("SMTP_PASSWORD", ""), timeout)
+		if err != nil {
+			log.Fatal("Invalid SMTP configuration: ", err)
+		}
+	default:
+		log.Fatalf("MAIL_SENDER must be log or smtp, got %q", kind)
+	}
+
+	AutoMigrate, err = strconv.ParseBool(getEnv("AUTO_MIGRATE", "true"))
+	if err != nil {
+		log.Fatal("AUTO_MIGRATE must be true or false")
+	}
+	SeedDevData, err = strconv.ParseBool(getEnv("SEED_DEV_DATA", "false"))
+	if err != nil {
+		log.Fatal("SEED_DEV_DATA must be true or false")
+	}
+}
+
+// TwoFactorRequired reports whether users holding any of the roles must use
+// two-factor authentication.
+func TwoFactorRequired(roles ...string) bool {
+	for _, r := range TwoFactorRequiredRoles {
+		for _, role := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func getEnv(key, defaultValue string) string {
@@ -29,3 +296,35 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// parseMegabytes reads a positive size in MB and returns it in bytes.
+func parseMegabytes(key, defaultValue string) int64 {
+	mb, err := strconv.ParseInt(getEnv(key, defaultValue), 10, 64)
+	if err != nil || mb <= 0 {
+		log.Fatalf("%s must be a positive number of megabytes", key)
+	}
+	return mb << 20
+}
+
+// parseWaterfall expects each of fee, interest and principal exactly once.
+func parseWaterfall(value string) []string {
+	seen := map[string]bool{}
+	var order []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "fee", "interest", "principal":
+		default:
+			log.Fatalf("Invalid REPAYMENT_WATERFALL component %q", part)
+		}
+		if seen[part] {
+			log.Fatalf("Duplicate REPAYMENT_WATERFALL component %q", part)
+		}
+		seen[part] = true
+		order = append(order, part)
+	}
+	if len(order) != 3 {
+		log.Fatal("REPAYMENT_WATERFALL must list fee, interest and principal")
+	}
+	return order
+}
@@ -4,20 +4,40 @@ package db
 import (
 	"database/sql"
 	"log"
+	"strings"
 
+	"loan-service-engine/config"
+
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
-func Connect(dbPath ...string) {
+// Connect opens the database chosen by DB_DRIVER. For SQLite the optional
+// argument is the file path; for Postgres it is a connection string. Without
+// it DATABASE_URL is used, falling back to ./db/loan_service.db for SQLite.
+func Connect(dsn ...string) {
 	var err error
-	path := "./db/loan_service.db" // default
-	if len(dbPath) > 0 {
-		path = dbPath[0]
+	source := config.DatabaseURL
+	if len(dsn) > 0 {
+		source = dsn[0]
 	}
 
-	DB, err = sql.Open("sqlite3", path)
+	switch Dialect(config.DBDriver) {
+	case Postgres:
+		// Postgres handles concurrent writers itself; workflows that read
+		// then write lock the rows they depend on (SELECT ... FOR UPDATE).
+		DB, err = sql.Open("postgres", source)
+	default:
+		if source == "" {
+			source = "./db/loan_service.db"
+		}
+		// Write transactions take the lock up front (BEGIN IMMEDIATE) and wait
+		// for a busy database instead of failing, so concurrent read-then-write
+		// workflows such as investment placement are serialised.
+		DB, err = sql.Open("sqlite3", withSQLiteOptions(source))
+	}
 	if err != nil {
 		log.Fatal("DB open error:", err)
 	}
@@ -26,3 +46,11 @@ func Connect(dbPath ...string) {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 }
+
+func withSQLiteOptions(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_txlock=immediate&_busy_timeout=5000"
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Dialect names a supported database backend. It picks the migration and
+// seed set and decides how queries are written for it.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DialectOf reports which backend conn was opened with.
+func DialectOf(conn *sql.DB) Dialect {
+	if _, ok := conn.Driver().(*pq.Driver); ok {
+		return Postgres
+	}
+	return SQLite
+}
+
+// Rebind rewrites the ? placeholders used throughout the code base into
+// Postgres' $1, $2, ... form. Question marks inside quoted literals are left
+// alone. SQLite queries are returned unchanged.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+		case r == '?' && !inQuote:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,15 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	query := `SELECT id FROM loans WHERE status = ? AND note <> 'why?' AND id > ?`
+
+	if got := SQLite.Rebind(query); got != query {
+		t.Errorf("SQLite queries should be unchanged, got %s", got)
+	}
+	want := `SELECT id FROM loans WHERE status = $1 AND note <> 'why?' AND id > $2`
+	if got := Postgres.Rebind(query); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,44 @@
+-- Converts a database created by the original db/init-db.sql, from before
+-- migrations existed, to the layout of migrations/sqlite/0001. MigrateUp
+-- runs it in the same transaction as 0001 when it recognises such a
+-- database; 0001 then adds the tables the old layout lacks.
+--
+-- Money moves from REAL Rupiah to INTEGER sen (1/100 Rupiah) and loans and
+-- investments get the columns added since. SQLite cannot change a column
+-- type in place, so both tables are rebuilt.
+
+CREATE TABLE loans_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    borrower_id_number TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    rate REAL NOT NULL,
+    roi REAL NOT NULL,
+    tenure_months INTEGER NOT NULL DEFAULT 12,
+    repayment_method TEXT NOT NULL DEFAULT 'flat',
+    funding_window_days INTEGER NOT NULL DEFAULT 30,
+    funding_deadline TEXT,
+    status TEXT NOT NULL DEFAULT 'proposed',
+    requester_id INTEGER NOT NULL,
+    agreement_letter_url TEXT,
+    FOREIGN KEY (requester_id) REFERENCES users(id)
+);
+INSERT INTO loans_new (id, borrower_id_number, amount, rate, roi, status, requester_id, agreement_letter_url)
+    SELECT id, borrower_id_number, CAST(ROUND(amount * 100) AS INTEGER), rate, roi, status, requester_id, agreement_letter_url FROM loans;
+DROP TABLE loans;
+ALTER TABLE loans_new RENAME TO loans;
+
+CREATE TABLE investments_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL,
+    investor_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    investment_date TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    refunded_at TEXT,
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (investor_id) REFERENCES users(id)
+);
+INSERT INTO investments_new (id, loan_id, investor_id, amount, investment_date)
+    SELECT id, loan_id, investor_id, CAST(ROUND(amount * 100) AS INTEGER), investment_date FROM investments;
+DROP TABLE investments;
+ALTER TABLE investments_new RENAME TO investments;
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each dialect keeps its own migration and seed set under migrations/<dialect>
+// and seeds/<dialect>; a schema change has to be added to both.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+//go:embed seeds/*/*.sql
+var seedFiles embed.FS
+
+//go:embed legacy/*.sql
+var legacyFiles embed.FS
+
+// legacyTables are the tables, and their columns where the layout changed
+// since, of a database created by the original db/init-db.sql.
+var legacyTables = map[string][]string{
+	"users":         nil,
+	"approvals":     nil,
+	"disbursements": nil,
+	"loans":         {"id", "borrower_id_number", "amount", "rate", "roi", "status", "requester_id", "agreement_letter_url"},
+	"investments":   {"id", "loan_id", "investor_id", "amount", "investment_date"},
+}
+
+// Migration is one numbered schema change, read from
+// migrations/<dialect>/NNNN_name.up.sql and its matching .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	AppliedAt string
+}
+
+// Migrations returns the embedded migrations for d ordered by version.
+func Migrations(d Dialect) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/"+string(d)+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", base)
+		}
+
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(base, "."+direction+".sql"), "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.%s.sql", base, direction)
+		}
+
+		body, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func ensureMigrationsTable(conn *sql.DB) error {
+	_, err := conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`)
+	return err
+}
+
+func appliedMigrations(conn *sql.DB) (map[int]string, error) {
+	if err := ensureMigrationsTable(conn); err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies every migration that has not been applied yet, each in its
+// own transaction, and returns the ones it ran.
+func MigrateUp(conn *sql.DB) ([]Migration, error) {
+	d := DialectOf(conn)
+	migrations, err := Migrations(d)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+
+	var adopt string
+	if len(applied) == 0 {
+		if adopt, err = adoptionScript(conn, d); err != nil {
+			return nil, err
+		}
+	}
+
+	var ran []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		script := m.Up
+		if adopt != "" {
+			script, adopt = adopt+"\n"+script, ""
+		}
+		err := runInTx(conn, script, d.Rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+			m.Version, m.Name, time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return ran, fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// adoptionScript looks at a database with no applied migrations. An empty
+// one needs nothing, and one created by the original db/init-db.sql needs
+// the script converting it to the layout of the first migration. Any other
+// tables are refused: marking them migrated would hide whatever they lack.
+func adoptionScript(conn *sql.DB, d Dialect) (string, error) {
+	query := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations'`
+	if d == Postgres {
+		query = `SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`
+	}
+	tables, err := queryNames(conn, query)
+	if err != nil {
+		return "", fmt.Errorf("failed to list tables: %v", err)
+	}
+	if len(tables) == 0 {
+		return "", nil
+	}
+
+	unknown := fmt.Errorf("database has tables (%s) but no applied migrations, and is not a database created by db/init-db.sql; "+
+		"migrate its data into a new database instead", strings.Join(tables, ", "))
+	if d != SQLite || len(tables) != len(legacyTables) {
+		return "", unknown
+	}
+	for _, table := range tables {
+		want, ok := legacyTables[table]
+		if !ok {
+			return "", unknown
+		}
+		if want == nil {
+			continue
+		}
+		columns, err := queryNames(conn, `SELECT name FROM pragma_table_info('`+table+`')`)
+		if err != nil {
+			return "", fmt.Errorf("failed to read columns of %s: %v", table, err)
+		}
+		want = append([]string(nil), want...)
+		sort.Strings(want)
+		if strings.Join(columns, ",") != strings.Join(want, ",") {
+			return "", unknown
+		}
+	}
+
+	body, err := legacyFiles.ReadFile("legacy/sqlite_init_db.sql")
+	if err != nil {
+		return "", err
+	}
+	log.Println("Converting a database created by db/init-db.sql")
+	return string(body), nil
+}
+
+// queryNames runs a query returning one text column and returns its values sorted.
+func queryNames(conn *sql.DB, query string) ([]string, error) {
+	rows, err := conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, rows.Err()
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first.
+func MigrateDown(conn *sql.DB, steps int) ([]Migration, error) {
+	d := DialectOf(conn)
+	migrations, err := Migrations(d)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return reverted, fmt.Errorf("migration %04d_%s cannot be reverted: no down script", m.Version, m.Name)
+		}
+		if err := runInTx(conn, m.Down, d.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), m.Version); err != nil {
+			return reverted, fmt.Errorf("reverting migration %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Reverted migration %04d_%s", m.Version, m.Name)
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// MigrationStatuses lists every embedded migration and when it was applied;
+// AppliedAt is empty for pending ones.
+func MigrationStatuses(conn *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(DialectOf(conn))
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		statuses = append(statuses, MigrationStatus{Migration: m, AppliedAt: applied[m.Version]})
+	}
+	return statuses, nil
+}
+
+// SeedDev loads the development seed data (the demo users). The seeds only
+// insert missing rows, so running them twice is harmless. Never run this
+// against a production database.
+func SeedDev(conn *sql.DB) error {
+	files, err := fs.Glob(seedFiles, "seeds/"+string(DialectOf(conn))+"/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		body, err := seedFiles.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Exec(string(body)); err != nil {
+			return fmt.Errorf("seed %s failed: %v", path.Base(file), err)
+		}
+		log.Printf("Applied seed %s", path.Base(file))
+	}
+	return nil
+}
+
+// runInTx runs a migration script and its schema_migrations bookkeeping
+// statement atomically.
+func runInTx(conn *sql.DB, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTempDB(t *testing.T) *sql.DB {
+	conn, err := sql.Open("sqlite3", withSQLiteOptions(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// openPostgres connects to TEST_POSTGRES_URL and empties its public schema.
+// Point it at a throwaway database; the test is skipped when it is not set.
+func openPostgres(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL not set")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := conn.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatalf("Failed to reset schema: %v", err)
+	}
+	return conn
+}
+
+func tableExists(conn *sql.DB, name string) bool {
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if DialectOf(conn) == Postgres {
+		query = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = 'public' AND table_name = $1`
+	}
+	var n int
+	conn.QueryRow(query, name).Scan(&n)
+	return n == 1
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	testMigrateUpAndDown(t, openTempDB(t))
+}
+
+func TestPostgresMigrateUpAndDown(t *testing.T) {
+	testMigrateUpAndDown(t, openPostgres(t))
+}
+
+// Every schema change has to be written for both backends.
+func TestDialectsHaveSameMigrations(t *testing.T) {
+	sqlite, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatalf("Failed to load SQLite migrations: %v", err)
+	}
+	postgres, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatalf("Failed to load Postgres migrations: %v", err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("SQLite has %d migrations, Postgres %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("Migration mismatch: %04d_%s vs %04d_%s", sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+		if (sqlite[i].Down == "") != (postgres[i].Down == "") {
+			t.Errorf("Migration %04d_%s has a down script for only one dialect", sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func testMigrateUpAndDown(t *testing.T, conn *sql.DB) {
+	migrations, err := Migrations(DialectOf(conn))
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ran, err := MigrateUp(conn)
+	if err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	if len(ran) != len(migrations) {
+		t.Errorf("Expected %d migrations to run, got %d", len(migrations), len(ran))
+	}
+	for _, table := range []string{"users", "loans", "investments", "loan_status_history", "schema_migrations"} {
+		if !tableExists(conn, table) {
+			t.Errorf("Expected table %s to exist after migrating up", table)
+		}
+	}
+
+	// Running again is a no-op
+	ran, err = MigrateUp(conn)
+	if err != nil || len(ran) != 0 {
+		t.Errorf("Expected second MigrateUp to do nothing, ran %d (err %v)", len(ran), err)
+	}
+
+	// Schema only, no users until the dev seed runs
+	var users int
+	conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users)
+	if users != 0 {
+		t.Errorf("Migrations should not seed users, found %d", users)
+	}
+	if err := SeedDev(conn); err != nil {
+		t.Fatalf("SeedDev failed: %v", err)
+	}
+	if err := SeedDev(conn); err != nil {
+		t.Fatalf("SeedDev should be repeatable: %v", err)
+	}
+	conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users)
+	if users != 7 {
+		t.Errorf("Expected 7 seeded users, got %d", users)
+	}
+
+	reverted, err := MigrateDown(conn, len(migrations))
+	if err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Errorf("Expected %d migrations to be reverted, got %d", len(migrations), len(reverted))
+	}
+	if tableExists(conn, "loans") {
+		t.Error("Expected loans table to be dropped after migrating down")
+	}
+
+	statuses, err := MigrationStatuses(conn)
+	if err != nil {
+		t.Fatalf("MigrationStatuses failed: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt != "" {
+			t.Errorf("Migration %d should be pending after migrating down", s.Version)
+		}
+	}
+}
+
+// initDB is the schema of the original db/init-db.sql, from before
+// migrations existed.
+const initDB = `
+CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT NOT NULL UNIQUE, email TEXT NOT NULL UNIQUE, password TEXT NOT NULL, role TEXT NOT NULL);
+CREATE TABLE loans (id INTEGER PRIMARY KEY AUTOINCREMENT, borrower_id_number TEXT NOT NULL, amount REAL NOT NULL, rate REAL NOT NULL, roi REAL NOT NULL,
+	status TEXT NOT NULL DEFAULT 'proposed', requester_id INTEGER NOT NULL, agreement_letter_url TEXT, FOREIGN KEY (requester_id) REFERENCES users(id));
+CREATE TABLE approvals (id INTEGER PRIMARY KEY AUTOINCREMENT, loan_id INTEGER NOT NULL UNIQUE, validator_id TEXT NOT NULL, proof_url TEXT NOT NULL,
+	approved_at DATETIME NOT NULL, FOREIGN KEY (loan_id) REFERENCES loans(id));
+CREATE TABLE investments (id INTEGER PRIMARY KEY AUTOINCREMENT, loan_id INTEGER NOT NULL, investor_id INTEGER NOT NULL, amount REAL NOT NULL,
+	investment_date TEXT NOT NULL, FOREIGN KEY (loan_id) REFERENCES loans(id), FOREIGN KEY (investor_id) REFERENCES users(id));
+CREATE TABLE disbursements (id INTEGER PRIMARY KEY AUTOINCREMENT, loan_id INTEGER NOT NULL UNIQUE, disbursed_at TEXT NOT NULL, field_officer_id TEXT NOT NULL,
+	agreement_url TEXT NOT NULL, admin_id INTEGER NOT NULL, FOREIGN KEY (loan_id) REFERENCES loans(id), FOREIGN KEY (admin_id) REFERENCES users(id));
+`
+
+func TestMigrateUpConvertsInitDB(t *testing.T) {
+	conn := openTempDB(t)
+	if _, err := conn.Exec(initDB); err != nil {
+		t.Fatalf("Failed to create the old schema: %v", err)
+	}
+	conn.Exec(`INSERT INTO users (username, email, password, role) VALUES ('investor1', 'investor1@email.com', 'x', 'investor')`)
+	conn.Exec(`INSERT INTO loans (borrower_id_number, amount, rate, roi, status, requester_id) VALUES ('1234567890123456', 1000000.5, 12, 10, 'invested', 1)`)
+	conn.Exec(`INSERT INTO investments (loan_id, investor_id, amount, investment_date) VALUES (1, 1, 1000000.5, '2025-06-25')`)
+
+	migrations, _ := Migrations(SQLite)
+	if ran, err := MigrateUp(conn); err != nil || len(ran) != len(migrations) {
+		t.Fatalf("Expected every migration to run, ran %d (err %v)", len(ran), err)
+	}
+
+	// Money is converted to sen and the new columns get their defaults
+	var amount, tenure int64
+	var invested int64
+	var status string
+	conn.QueryRow(`SELECT amount, tenure_months FROM loans WHERE id = 1`).Scan(&amount, &tenure)
+	conn.QueryRow(`SELECT amount, status FROM investments WHERE id = 1`).Scan(&invested, &status)
+	if amount != 100000050 || tenure != 12 || invested != 100000050 || status != "active" {
+		t.Errorf("Unexpected converted rows: loan %d sen over %d months, investment %d sen %s", amount, tenure, invested, status)
+	}
+	var users int
+	conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users)
+	if users != 1 || !tableExists(conn, "repayment_schedules") {
+		t.Errorf("Expected existing users to be kept and missing tables created, found %d users", users)
+	}
+}
+
+// A database that is neither empty nor the original layout is not adopted.
+func TestMigrateUpRefusesUnknownSchema(t *testing.T) {
+	for name, schema := range map[string]string{
+		"some of the tables":        `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT NOT NULL UNIQUE)`,
+		"changed after init-db.sql": initDB + `ALTER TABLE loans ADD COLUMN tenure_months INTEGER;`,
+	} {
+		conn := openTempDB(t)
+		if _, err := conn.Exec(schema); err != nil {
+			t.Fatalf("%s: failed to create the schema: %v", name, err)
+		}
+		if _, err := MigrateUp(conn); err == nil {
+			t.Errorf("%s: expected MigrateUp to refuse the database", name)
+		}
+		var applied int
+		conn.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied)
+		if applied != 0 || tableExists(conn, "repayment_schedules") {
+			t.Errorf("%s: expected the database to be left alone, %d migrations recorded", name, applied)
+		}
+	}
+}
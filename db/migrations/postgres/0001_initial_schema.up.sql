@@ -0,0 +1,114 @@
+-- Initial schema, PostgreSQL edition of migrations/sqlite/0001. Keep the two
+-- in step: same tables, columns and constraints. Timestamps stay TEXT
+-- (RFC 3339) like in SQLite so both backends compare them the same way.
+
+-- USERS TABLE
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    role TEXT NOT NULL
+);
+
+-- LOANS TABLE
+-- Money columns hold whole sen (1/100 Rupiah), see the money package.
+CREATE TABLE IF NOT EXISTS loans (
+    id BIGSERIAL PRIMARY KEY,
+    borrower_id_number TEXT NOT NULL,
+    amount BIGINT NOT NULL,
+    rate DOUBLE PRECISION NOT NULL,
+    roi DOUBLE PRECISION NOT NULL,
+    tenure_months INTEGER NOT NULL DEFAULT 12,
+    repayment_method TEXT NOT NULL DEFAULT 'flat',
+    funding_window_days INTEGER NOT NULL DEFAULT 30,
+    funding_deadline TEXT,
+    status TEXT NOT NULL DEFAULT 'proposed',
+    requester_id BIGINT NOT NULL REFERENCES users(id),
+    agreement_letter_url TEXT
+);
+
+-- APPROVALS TABLE
+CREATE TABLE IF NOT EXISTS approvals (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL UNIQUE REFERENCES loans(id),
+    validator_id TEXT NOT NULL,
+    proof_url TEXT NOT NULL,
+    approved_at TEXT NOT NULL
+);
+
+-- INVESTMENTS TABLE
+CREATE TABLE IF NOT EXISTS investments (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL REFERENCES loans(id),
+    investor_id BIGINT NOT NULL REFERENCES users(id),
+    amount BIGINT NOT NULL,
+    investment_date TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    refunded_at TEXT
+);
+CREATE INDEX IF NOT EXISTS investments_loan_id_idx ON investments (loan_id);
+
+-- DISBURSEMENTS TABLE
+CREATE TABLE IF NOT EXISTS disbursements (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL UNIQUE REFERENCES loans(id),
+    disbursed_at TEXT NOT NULL,
+    field_officer_id TEXT NOT NULL,
+    agreement_url TEXT NOT NULL,
+    admin_id BIGINT NOT NULL REFERENCES users(id)
+);
+
+-- REPAYMENT SCHEDULES TABLE
+CREATE TABLE IF NOT EXISTS repayment_schedules (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL REFERENCES loans(id),
+    installment_number INTEGER NOT NULL,
+    due_date TEXT NOT NULL,
+    principal BIGINT NOT NULL,
+    interest BIGINT NOT NULL,
+    total_due BIGINT NOT NULL,
+    outstanding_balance BIGINT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    fee BIGINT NOT NULL DEFAULT 0,
+    principal_paid BIGINT NOT NULL DEFAULT 0,
+    interest_paid BIGINT NOT NULL DEFAULT 0,
+    fee_paid BIGINT NOT NULL DEFAULT 0,
+    UNIQUE (loan_id, installment_number)
+);
+
+-- REPAYMENTS TABLE
+CREATE TABLE IF NOT EXISTS repayments (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL REFERENCES loans(id),
+    amount BIGINT NOT NULL,
+    fee_portion BIGINT NOT NULL,
+    interest_portion BIGINT NOT NULL,
+    principal_portion BIGINT NOT NULL,
+    paid_at TEXT NOT NULL,
+    recorded_by BIGINT NOT NULL REFERENCES users(id),
+    platform_revenue BIGINT NOT NULL DEFAULT 0
+);
+
+-- PAYOUTS TABLE
+CREATE TABLE IF NOT EXISTS payouts (
+    id BIGSERIAL PRIMARY KEY,
+    repayment_id BIGINT NOT NULL REFERENCES repayments(id),
+    loan_id BIGINT NOT NULL REFERENCES loans(id),
+    investor_id BIGINT NOT NULL REFERENCES users(id),
+    principal BIGINT NOT NULL,
+    interest BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    paid_at TEXT NOT NULL
+);
+
+-- LOAN STATUS HISTORY TABLE
+CREATE TABLE IF NOT EXISTS loan_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    loan_id BIGINT NOT NULL REFERENCES loans(id),
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    actor_id BIGINT REFERENCES users(id),
+    reason TEXT,
+    changed_at TEXT NOT NULL
+);
@@ -0,0 +1,29 @@
+-- Self-service registration: email verification and admin invites.
+
+-- Accounts created before registration existed were set up by an operator
+-- and count as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TEXT;
+UPDATE users SET email_verified_at = to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
+
+-- Single-use tokens mailed to a user (email verification). Only the SHA-256
+-- of the token is stored.
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    purpose TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TEXT NOT NULL,
+    used_at TEXT,
+    created_at TEXT NOT NULL
+);
+
+-- Invites are the only way to register an admin account.
+CREATE TABLE IF NOT EXISTS admin_invites (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by BIGINT NOT NULL REFERENCES users(id),
+    expires_at TEXT NOT NULL,
+    used_at TEXT,
+    created_at TEXT NOT NULL
+);
@@ -0,0 +1,26 @@
+-- TOTP two-factor authentication with recovery codes.
+
+-- Set once a session's login passed the second factor. Access tokens of
+-- the session carry it through refreshes.
+ALTER TABLE sessions ADD COLUMN two_factor_verified_at TEXT;
+
+-- One authenticator app per user. The secret only protects logins once
+-- confirmed_at is set; last_used_step stops a code from being replayed.
+CREATE TABLE IF NOT EXISTS totp_credentials (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id),
+    secret TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    confirmed_at TEXT,
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+-- Single-use codes for when the authenticator is lost. Only the SHA-256 of
+-- each code is stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    code_hash TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    used_at TEXT
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
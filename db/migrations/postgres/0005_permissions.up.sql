@@ -0,0 +1,73 @@
+-- Permission-based authorization. Permissions are granted to roles and
+-- users can hold several roles. users.role stays as the role an account
+-- registered with; user_roles decides what the user may do.
+
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL REFERENCES roles(name),
+    permission TEXT NOT NULL REFERENCES permissions(name),
+    PRIMARY KEY (role, permission)
+);
+
+-- granted_by is empty for roles taken at registration.
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    role TEXT NOT NULL REFERENCES roles(name),
+    granted_by BIGINT REFERENCES users(id),
+    granted_at TEXT NOT NULL,
+    PRIMARY KEY (user_id, role)
+);
+
+INSERT INTO roles (name, description) VALUES
+('requester', 'Proposes loans and repays them'),
+('investor', 'Invests in approved loans'),
+('admin', 'Runs the lending operation');
+
+INSERT INTO permissions (name, description) VALUES
+('loan:create', 'Propose a loan'),
+('loan:read', 'View any loan''s schedule and status history, list all loans'),
+('loan:read:own', 'View the schedule of own loans'),
+('loan:approve', 'Approve a proposed loan'),
+('loan:reject', 'Reject a proposed loan'),
+('loan:cancel', 'Cancel a proposed or under-funded loan'),
+('loan:cancel:own', 'Withdraw own proposed loan'),
+('loan:disburse', 'Disburse a fully funded loan'),
+('agreement:download', 'Download a borrower agreement'),
+('repayment:record', 'Record a repayment on any loan'),
+('repayment:record:own', 'Record a repayment on own loan'),
+('investment:create', 'Invest in an approved loan'),
+('payout:read:own', 'View own payouts'),
+('user:invite', 'Invite admins'),
+('user:manage', 'Log users out and reset their two-factor authentication'),
+('role:manage', 'Grant and revoke roles');
+
+INSERT INTO role_permissions (role, permission) VALUES
+('requester', 'loan:create'),
+('requester', 'loan:read:own'),
+('requester', 'loan:cancel:own'),
+('requester', 'repayment:record:own'),
+('investor', 'investment:create'),
+('investor', 'payout:read:own'),
+('admin', 'loan:read'),
+('admin', 'loan:approve'),
+('admin', 'loan:reject'),
+('admin', 'loan:cancel'),
+('admin', 'loan:disburse'),
+('admin', 'agreement:download'),
+('admin', 'repayment:record'),
+('admin', 'user:invite'),
+('admin', 'user:manage'),
+('admin', 'role:manage');
+
+-- Existing users keep the role they registered with
+INSERT INTO user_roles (user_id, role, granted_at)
+SELECT id, role, to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') FROM users;
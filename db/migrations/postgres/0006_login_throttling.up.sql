@@ -0,0 +1,11 @@
+-- Failed login attempts, counted per username and per client IP. Usernames
+-- are tracked whether or not the account exists, so lockouts do not reveal
+-- which usernames are registered.
+CREATE TABLE IF NOT EXISTS login_failures (
+    scope TEXT NOT NULL, -- 'username' or 'ip'
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failed_at TEXT NOT NULL,
+    locked_until TEXT,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission = 'api_key:manage';
+DELETE FROM permissions WHERE name = 'api_key:manage';
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,21 @@
+-- API keys for machine-to-machine integrations. A key acts for the admin
+-- who issued it, limited to its scopes (space-separated permissions). Only
+-- the SHA-256 of the key is stored; prefix identifies it in listings.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_by BIGINT NOT NULL REFERENCES users(id),
+    created_at TEXT NOT NULL,
+    expires_at TEXT,
+    last_used_at TEXT,
+    revoked_at TEXT
+);
+
+INSERT INTO permissions (name, description) VALUES
+('api_key:manage', 'Issue and revoke API keys');
+
+INSERT INTO role_permissions (role, permission) VALUES
+('admin', 'api_key:manage');
@@ -0,0 +1,4 @@
+ALTER TABLE approvals DROP COLUMN IF EXISTS proof_thumbnail_url;
+ALTER TABLE approvals DROP COLUMN IF EXISTS longitude;
+ALTER TABLE approvals DROP COLUMN IF EXISTS latitude;
+ALTER TABLE approvals DROP COLUMN IF EXISTS photo_taken_at;
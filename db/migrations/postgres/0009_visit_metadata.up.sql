@@ -0,0 +1,8 @@
+-- What the field validator's proof photo recorded about the visit. The
+-- photo itself is stored without its EXIF data.
+ALTER TABLE approvals ADD COLUMN photo_taken_at TEXT;
+ALTER TABLE approvals ADD COLUMN latitude DOUBLE PRECISION;
+ALTER TABLE approvals ADD COLUMN longitude DOUBLE PRECISION;
+
+-- Small JPEG of the proof for admin review. Empty when none could be made.
+ALTER TABLE approvals ADD COLUMN proof_thumbnail_url TEXT;
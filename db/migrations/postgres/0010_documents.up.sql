@@ -0,0 +1,11 @@
+-- Uploaded documents, stored under a key derived from their SHA-256 so the
+-- same content is kept once and a stored file can be checked against the
+-- hash recorded when it was uploaded. Approvals and disbursements refer to
+-- them by storage_key.
+CREATE TABLE IF NOT EXISTS documents (
+    sha256 TEXT PRIMARY KEY,
+    storage_key TEXT NOT NULL UNIQUE,
+    size BIGINT NOT NULL,
+    content_type TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS documents_scan_status_idx;
+ALTER TABLE documents DROP COLUMN IF EXISTS scanned_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS scan_status;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS loan_status_history;
+DROP TABLE IF EXISTS payouts;
+DROP TABLE IF EXISTS repayments;
+DROP TABLE IF EXISTS repayment_schedules;
+DROP TABLE IF EXISTS disbursements;
+DROP TABLE IF EXISTS investments;
+DROP TABLE IF EXISTS approvals;
+DROP TABLE IF EXISTS loans;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,128 @@
+-- Initial schema. Tables are created with IF NOT EXISTS because a database
+-- set up by the original db/init-db.sql is first converted by
+-- db/legacy/sqlite_init_db.sql, which keeps its users, approvals and
+-- disbursements tables. MigrateUp refuses any other unversioned database.
+
+-- USERS TABLE
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    role TEXT NOT NULL
+);
+
+-- LOANS TABLE
+-- Money columns hold whole sen (1/100 Rupiah), see the money package.
+CREATE TABLE IF NOT EXISTS loans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    borrower_id_number TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    rate REAL NOT NULL,
+    roi REAL NOT NULL,
+    tenure_months INTEGER NOT NULL DEFAULT 12,
+    repayment_method TEXT NOT NULL DEFAULT 'flat',
+    funding_window_days INTEGER NOT NULL DEFAULT 30,
+    funding_deadline TEXT,
+    status TEXT NOT NULL DEFAULT 'proposed',
+    requester_id INTEGER NOT NULL,
+    agreement_letter_url TEXT,
+    FOREIGN KEY (requester_id) REFERENCES users(id)
+);
+
+-- APPROVALS TABLE
+CREATE TABLE IF NOT EXISTS approvals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL UNIQUE,
+    validator_id TEXT NOT NULL,
+    proof_url TEXT NOT NULL,
+    approved_at DATETIME NOT NULL,
+    FOREIGN KEY (loan_id) REFERENCES loans(id)
+);
+
+-- INVESTMENTS TABLE
+CREATE TABLE IF NOT EXISTS investments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL,
+    investor_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    investment_date TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    refunded_at TEXT,
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (investor_id) REFERENCES users(id)
+);
+
+-- DISBURSEMENTS TABLE
+CREATE TABLE IF NOT EXISTS disbursements (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL UNIQUE,
+    disbursed_at TEXT NOT NULL,
+    field_officer_id TEXT NOT NULL,
+    agreement_url TEXT NOT NULL,
+    admin_id INTEGER NOT NULL,
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (admin_id) REFERENCES users(id)
+);
+
+-- REPAYMENT SCHEDULES TABLE
+CREATE TABLE IF NOT EXISTS repayment_schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL,
+    installment_number INTEGER NOT NULL,
+    due_date TEXT NOT NULL,
+    principal INTEGER NOT NULL,
+    interest INTEGER NOT NULL,
+    total_due INTEGER NOT NULL,
+    outstanding_balance INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    fee INTEGER NOT NULL DEFAULT 0,
+    principal_paid INTEGER NOT NULL DEFAULT 0,
+    interest_paid INTEGER NOT NULL DEFAULT 0,
+    fee_paid INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (loan_id, installment_number),
+    FOREIGN KEY (loan_id) REFERENCES loans(id)
+);
+
+-- REPAYMENTS TABLE
+CREATE TABLE IF NOT EXISTS repayments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    fee_portion INTEGER NOT NULL,
+    interest_portion INTEGER NOT NULL,
+    principal_portion INTEGER NOT NULL,
+    paid_at TEXT NOT NULL,
+    recorded_by INTEGER NOT NULL,
+    platform_revenue INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (recorded_by) REFERENCES users(id)
+);
+
+-- PAYOUTS TABLE
+CREATE TABLE IF NOT EXISTS payouts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    repayment_id INTEGER NOT NULL,
+    loan_id INTEGER NOT NULL,
+    investor_id INTEGER NOT NULL,
+    principal INTEGER NOT NULL,
+    interest INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    paid_at TEXT NOT NULL,
+    FOREIGN KEY (repayment_id) REFERENCES repayments(id),
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (investor_id) REFERENCES users(id)
+);
+
+-- LOAN STATUS HISTORY TABLE
+CREATE TABLE IF NOT EXISTS loan_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    loan_id INTEGER NOT NULL,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    actor_id INTEGER,
+    reason TEXT,
+    changed_at TEXT NOT NULL,
+    FOREIGN KEY (loan_id) REFERENCES loans(id),
+    FOREIGN KEY (actor_id) REFERENCES users(id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS admin_invites;
+DROP TABLE IF EXISTS user_tokens;
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,31 @@
+-- Self-service registration: email verification and admin invites.
+
+-- Accounts created before registration existed were set up by an operator
+-- and count as verified.
+ALTER TABLE users ADD COLUMN email_verified_at TEXT;
+UPDATE users SET email_verified_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now');
+
+-- Single-use tokens mailed to a user (email verification). Only the SHA-256
+-- of the token is stored.
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    purpose TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TEXT NOT NULL,
+    used_at TEXT,
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Invites are the only way to register an admin account.
+CREATE TABLE IF NOT EXISTS admin_invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL,
+    expires_at TEXT NOT NULL,
+    used_at TEXT,
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (invited_by) REFERENCES users(id)
+);
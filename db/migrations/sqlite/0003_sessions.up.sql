@@ -0,0 +1,33 @@
+-- Login sessions with rotating refresh tokens, and the access token
+-- revocation list checked on every authenticated request.
+
+-- A session starts at login and lives on through refresh token rotation
+-- until it is logged out or revoked.
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL,
+    revoked_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Only the SHA-256 of a refresh token is stored. used_at is set when it is
+-- exchanged; presenting it again revokes the session.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    used_at TEXT,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+
+-- Access tokens revoked before they expire, by their jti claim. Rows can be
+-- dropped once expires_at has passed.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at TEXT NOT NULL,
+    revoked_at TEXT NOT NULL
+);
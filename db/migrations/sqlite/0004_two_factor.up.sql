@@ -0,0 +1,28 @@
+-- TOTP two-factor authentication with recovery codes.
+
+-- Set once a session's login passed the second factor. Access tokens of
+-- the session carry it through refreshes.
+ALTER TABLE sessions ADD COLUMN two_factor_verified_at TEXT;
+
+-- One authenticator app per user. The secret only protects logins once
+-- confirmed_at is set; last_used_step stops a code from being replayed.
+CREATE TABLE IF NOT EXISTS totp_credentials (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    confirmed_at TEXT,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Single-use codes for when the authenticator is lost. Only the SHA-256 of
+-- each code is stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    used_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
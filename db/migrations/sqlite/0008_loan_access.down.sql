@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission = 'loan:read:investable';
+DELETE FROM permissions WHERE name = 'loan:read:investable';
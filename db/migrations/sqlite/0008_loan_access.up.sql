@@ -0,0 +1,7 @@
+-- Investors may view loans that are open for investment and loans they
+-- have invested in, without the borrower's identity or other investors'.
+INSERT INTO permissions (name, description) VALUES
+('loan:read:investable', 'View loans open for investment and loans invested in');
+
+INSERT INTO role_permissions (role, permission) VALUES
+('investor', 'loan:read:investable');
@@ -0,0 +1,4 @@
+ALTER TABLE approvals DROP COLUMN proof_thumbnail_url;
+ALTER TABLE approvals DROP COLUMN longitude;
+ALTER TABLE approvals DROP COLUMN latitude;
+ALTER TABLE approvals DROP COLUMN photo_taken_at;
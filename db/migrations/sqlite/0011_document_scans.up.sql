@@ -0,0 +1,8 @@
+-- Virus scan state of uploaded documents. A document that could not be
+-- scanned when it was uploaded is quarantined: it cannot be downloaded and
+-- the approval or disbursement it belongs to waits until a rescan passes.
+-- Infected documents are never kept.
+ALTER TABLE documents ADD COLUMN scan_status TEXT NOT NULL DEFAULT 'clean';
+ALTER TABLE documents ADD COLUMN scanned_at TEXT;
+
+CREATE INDEX IF NOT EXISTS documents_scan_status_idx ON documents (scan_status);
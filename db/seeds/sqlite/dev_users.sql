@@ -0,0 +1,20 @@
+-- Development users, applied with `go run main.go seed` or SEED_DEV_DATA=true.
+INSERT OR IGNORE INTO users (username, email, password, role, email_verified_at) VALUES
+('admin', 'admin@email.com','$2a$12$j.rFEx1xe/Bu8E6K9n5qce.CvmB6CWFncUHPAFwRpZLPp2KefKas6', 'admin', '2025-01-01T00:00:00Z'),
+('loan_requester1', 'loan1@email.com', '$2a$12$NFOq.C20AIixRD9v8Sms4.8kFUT.cHZg0p2Vt8NBGdqGInx7V2E7K', 'requester', '2025-01-01T00:00:00Z'),
+('loan_requester2', 'loan2@email.com', '$2a$12$NFOq.C20AIixRD9v8Sms4.8kFUT.cHZg0p2Vt8NBGdqGInx7V2E7K', 'requester', '2025-01-01T00:00:00Z'),
+('investor1', 'investor1@email.com', '$2a$12$NYTvY3idcI42xAOGzZllA.8iSDxjhTifhJ0QVRJCsGYQKwURpPpM.', 'investor', '2025-01-01T00:00:00Z'),
+('investor2', 'investor2@email.com', '$2a$12$NYTvY3idcI42xAOGzZllA.8iSDxjhTifhJ0QVRJCsGYQKwURpPpM.', 'investor', '2025-01-01T00:00:00Z'),
+('investor3', 'investor3@email.com', '$2a$12$NYTvY3idcI42xAOGzZllA.8iSDxjhTifhJ0QVRJCsGYQKwURpPpM.', 'investor', '2025-01-01T00:00:00Z'),
+('investor4', 'investor4@email.com', '$2a$12$NYTvY3idcI42xAOGzZllA.8iSDxjhTifhJ0QVRJCsGYQKwURpPpM.', 'investor', '2025-01-01T00:00:00Z');
+
+-- Each user holds the role they are seeded with
+INSERT OR IGNORE INTO user_roles (user_id, role, granted_at)
+SELECT id, role, '2025-01-01T00:00:00Z' FROM users
+WHERE username IN ('admin', 'loan_requester1', 'loan_requester2', 'investor1', 'investor2', 'investor3', 'investor4');
+
+-- Explanation:
+-- Passwords are pre-hashed using bcrypt:
+-- admin:         admin123
+-- loan_requesters: loan123
+-- investors:     investor123
@@ -0,0 +1,79 @@
+// Package document checks uploaded files before they are stored. Their type
+// is sniffed from the content rather than trusted from the client, and
+// photos are stripped of their metadata, keeping only the capture time and
+// location.
+package document
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrTooLarge          = errors.New("file is too large")
+	ErrUnsupportedType   = errors.New("file type is not allowed")
+	ErrExtensionMismatch = errors.New("file extension does not match its content")
+	ErrInvalidImage      = errors.New("image could not be read")
+)
+
+// The types Sniff recognises.
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	HEIC = "image/heic"
+	PDF  = "application/pdf"
+)
+
+// extensions lists the file name extensions accepted for each type. The
+// first one is used when the file is stored.
+var extensions = map[string][]string{
+	JPEG: {".jpg", ".jpeg"},
+	PNG:  {".png"},
+	HEIC: {".heic", ".heif"},
+	PDF:  {".pdf"},
+}
+
+// Upload is an uploaded file whose type has been checked.
+type Upload struct {
+	Content []byte
+	Type    string
+}
+
+// Ext is the extension to store the file under.
+func (u Upload) Ext() string {
+	return extensions[u.Type][0]
+}
+
+// Read reads an uploaded file of at most maxSize bytes and checks that its
+// content is one of the allowed types and that its name has a matching
+// extension.
+func Read(file *multipart.FileHeader, maxSize int64, allowed ...string) (Upload, error) {
+	if file.Size > maxSize {
+		return Upload{}, ErrTooLarge
+	}
+	f, err := file.Open()
+	if err != nil {
+		return Upload{}, err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return Upload{}, err
+	}
+	if int64(len(content)) > maxSize {
+		return Upload{}, ErrTooLarge
+	}
+
+	u := Upload{Content: content, Type: Sniff(content)}
+	if !slices.Contains(allowed, u.Type) {
+		return Upload{}, ErrUnsupportedType
+	}
+	if !slices.Contains(extensions[u.Type], strings.ToLower(filepath.Ext(file.Filename))) {
+		return Upload{}, ErrExtensionMismatch
+	}
+	return u, nil
+}
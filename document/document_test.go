@@ -0,0 +1,267 @@
+package document
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"testing"
+)
+
+var le = binary.LittleEndian
+
+type field struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func ascii(tag uint16, s string) field {
+	return field{tag, 2, uint32(len(s) + 1), []byte(s + "\x00")}
+}
+
+func short(tag, v uint16) field {
+	return field{tag, 3, 1, le.AppendUint16(nil, v)}
+}
+
+func long(tag uint16, v uint32) field {
+	return field{tag, 4, 1, le.AppendUint32(nil, v)}
+}
+
+func rationals(tag uint16, pairs ...uint32) field {
+	var value []byte
+	for _, v := range pairs {
+		value = le.AppendUint32(value, v)
+	}
+	return field{tag, 5, uint32(len(pairs) / 2), value}
+}
+
+// appendIFD writes a directory at the end of buf, with the values that do
+// not fit in their entries right after it, and returns where it starts.
+func appendIFD(buf []byte, fields []field) ([]byte, uint32) {
+	start := uint32(len(buf))
+	dataAt := start + 2 + 12*uint32(len(fields)) + 4
+	var data []byte
+	buf = le.AppendUint16(buf, uint16(len(fields)))
+	for _, f := range fields {
+		buf = le.AppendUint16(buf, f.tag)
+		buf = le.AppendUint16(buf, f.typ)
+		buf = le.AppendUint32(buf, f.count)
+		if len(f.value) <= 4 {
+			buf = append(buf, append(f.value, make([]byte, 4-len(f.value))...)...)
+		} else {
+			buf = le.AppendUint32(buf, dataAt+uint32(len(data)))
+			data = append(data, f.value...)
+		}
+	}
+	buf = le.AppendUint32(buf, 0)
+	return append(buf, data...), start
+}
+
+// exifBlock records a photo taken in Jakarta on 25 June 2025 by a camera
+// held sideways (orientation 6).
+func exifBlock() []byte {
+	buf := []byte("II*\x00\x00\x00\x00\x00")
+	buf, exifIFD := appendIFD(buf, []field{
+		ascii(tagDateTimeOriginal, "2025:06:25 10:15:30"),
+		ascii(tagOffsetTimeOriginal, "+07:00"),
+	})
+	buf, gpsIFD := appendIFD(buf, []field{
+		ascii(tagGPSLatitudeRef, "S"),
+		rationals(tagGPSLatitude, 6, 1, 12, 1, 2700, 100),
+		ascii(tagGPSLongitudeRef, "E"),
+		rationals(tagGPSLongitude, 106, 1, 50, 1, 4200, 100),
+	})
+	buf, ifd0 := appendIFD(buf, []field{
+		ascii(0x010F, "PhoneMaker"),
+		short(tagOrientation, 6),
+		long(tagExifIFD, exifIFD),
+		long(tagGPSIFD, gpsIFD),
+	})
+	le.PutUint32(buf[4:], ifd0)
+	return buf
+}
+
+// halves is a 40x20 image, red on the left and blue on the right.
+func halves() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 20 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+func jpegWithExif() []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, halves(), &jpeg.Options{Quality: 95})
+	exif := append([]byte("Exif\x00\x00"), exifBlock()...)
+	app1 := append([]byte{0xFF, 0xE1}, binary.BigEndian.AppendUint16(nil, uint16(2+len(exif)))...)
+	return append(append(buf.Bytes()[:2:2], append(app1, exif...)...), buf.Bytes()[2:]...)
+}
+
+func pngWithExif() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, halves())
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(exifBlock())))
+	chunk = append(chunk, append([]byte("eXIf"), exifBlock()...)...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	content := buf.Bytes()
+	// After the signature and the IHDR chunk
+	return append(append(content[:33:33], chunk...), content[33:]...)
+}
+
+func mkbox(typ string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(content))), append([]byte(typ), content...)...)
+}
+
+// heicWithExif is the skeleton of a HEIC file: an ftyp box, a meta box
+// describing one Exif item and the mdat box holding it.
+func heicWithExif() []byte {
+	ftyp := mkbox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	item := append([]byte("\x00\x00\x00\x06Exif\x00\x00"), exifBlock()...)
+	meta := func(offset uint32) []byte {
+		infe := mkbox("infe", []byte("\x02\x00\x00\x00\x00\x01\x00\x00Exif\x00"))
+		iinf := mkbox("iinf", []byte("\x00\x00\x00\x00\x00\x01"), infe)
+		iloc := []byte("\x00\x00\x00\x00\x44\x00\x00\x01\x00\x01\x00\x00\x00\x01")
+		iloc = binary.BigEndian.AppendUint32(iloc, offset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(item)))
+		return mkbox("meta", []byte("\x00\x00\x00\x00"), iinf, mkbox("iloc", iloc))
+	}
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(offset), mkbox("mdat", item)}, nil)
+}
+
+func checkMetadata(t *testing.T, meta Metadata) {
+	t.Helper()
+	if meta.TakenAt != "2025-06-25T10:15:30+07:00" {
+		t.Errorf("Expected the capture time, got %q", meta.TakenAt)
+	}
+	if meta.Latitude == nil || meta.Longitude == nil || *meta.Latitude != -6.2075 || *meta.Longitude != 106.845 {
+		t.Errorf("Expected the location -6.2075, 106.845, got %v, %v", meta.Latitude, meta.Longitude)
+	}
+}
+
+func TestNormalizeJPEG(t *testing.T) {
+	photo, err := NormalizePhoto(Upload{Content: jpegWithExif(), Type: JPEG})
+	if err != nil {
+		t.Fatalf("NormalizePhoto failed: %v", err)
+	}
+	checkMetadata(t, photo.Metadata)
+	if bytes.Contains(photo.Content, []byte("Exif")) || bytes.Contains(photo.Content, []byte("PhoneMaker")) {
+		t.Error("Expected the EXIF data to be stripped")
+	}
+
+	// Turned upright: the red left half is now on top
+	img, err := jpeg.Decode(bytes.NewReader(photo.Content))
+	if err != nil {
+		t.Fatalf("Expected a JPEG, got %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("Expected a 20x40 image, got %v", b)
+	}
+	if r, _, bl, _ := img.At(10, 5).RGBA(); r < bl {
+		t.Error("Expected red at the top")
+	}
+	if r, _, bl, _ := img.At(10, 35).RGBA(); r > bl {
+		t.Error("Expected blue at the bottom")
+	}
+
+	thumb, err := jpeg.Decode(bytes.NewReader(photo.Thumbnail))
+	if err != nil || thumb.Bounds().Dx() != 20 {
+		t.Errorf("Expected a thumbnail no bigger than the photo, got %v (err %v)", thumb, err)
+	}
+}
+
+func TestNormalizePNG(t *testing.T) {
+	photo, err := NormalizePhoto(Upload{Content: pngWithExif(), Type: PNG})
+	if err != nil {
+		t.Fatalf("NormalizePhoto failed: %v", err)
+	}
+	checkMetadata(t, photo.Metadata)
+	if bytes.Contains(photo.Content, []byte("eXIf")) || Sniff(photo.Content) != PNG {
+		t.Error("Expected a PNG without its eXIf chunk")
+	}
+}
+
+func TestNormalizeHEIC(t *testing.T) {
+	content := heicWithExif()
+	if Sniff(content) != HEIC {
+		t.Fatal("Expected the file to be recognised as HEIC")
+	}
+	photo, err := NormalizePhoto(Upload{Content: content, Type: HEIC})
+	if err != nil {
+		t.Fatalf("NormalizePhoto failed: %v", err)
+	}
+	checkMetadata(t, photo.Metadata)
+	if len(photo.Content) != len(content) || bytes.Contains(photo.Content, []byte("PhoneMaker")) {
+		t.Error("Expected the EXIF item to be blanked in place")
+	}
+	if again, _ := NormalizePhoto(Upload{Content: photo.Content, Type: HEIC}); again.Metadata.TakenAt != "" || again.Metadata.Latitude != nil {
+		t.Errorf("Expected no metadata left, got %+v", again.Metadata)
+	}
+	if photo.Thumbnail != nil {
+		t.Error("Expected no thumbnail for HEIC")
+	}
+}
+
+func TestThumbnailSize(t *testing.T) {
+	thumb := thumbnail(image.NewRGBA(image.Rect(0, 0, 4000, 3000)))
+	if b := thumb.Bounds(); b.Dx() != ThumbnailSize || b.Dy() != 240 {
+		t.Errorf("Expected a 320x240 thumbnail, got %v", b)
+	}
+}
+
+func fileHeader(t *testing.T, name string, content []byte) *multipart.FileHeader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", name)
+	part.Write(content)
+	w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("Failed to build the upload: %v", err)
+	}
+	return form.File["file"][0]
+}
+
+func TestRead(t *testing.T) {
+	photo, pdf := jpegWithExif(), []byte("%PDF-1.4\n%%EOF\n")
+	cases := []struct {
+		name    string
+		content []byte
+		allowed []string
+		err     error
+	}{
+		{"visit.jpg", photo, []string{JPEG, PNG}, nil},
+		{"VISIT.JPEG", photo, []string{JPEG, PNG}, nil},
+		{"visit.png", photo, []string{JPEG, PNG}, ErrExtensionMismatch},
+		{"visit.jpg", []byte("just some text"), []string{JPEG, PNG}, ErrUnsupportedType},
+		{"agreement.pdf", pdf, []string{JPEG, PNG}, ErrUnsupportedType},
+		{"agreement.pdf", pdf, []string{PDF}, nil},
+		{"agreement.jpg", pdf, []string{PDF}, ErrExtensionMismatch},
+	}
+	for _, tc := range cases {
+		u, err := Read(fileHeader(t, tc.name, tc.content), 1<<20, tc.allowed...)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+		if err == nil && !bytes.Equal(u.Content, tc.content) {
+			t.Errorf("%s: expected the content to be read", tc.name)
+		}
+	}
+	if _, err := Read(fileHeader(t, "visit.jpg", photo), 100, JPEG); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package document
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+// Metadata is what is kept of a photo's EXIF data.
+type Metadata struct {
+	// TakenAt is when the photo was taken, as the camera recorded it:
+	// 2006-01-02T15:04:05, followed by the UTC offset when the camera
+	// stored one.
+	TakenAt string
+	// Latitude and Longitude are in decimal degrees, negative to the
+	// south and west.
+	Latitude  *float64
+	Longitude *float64
+
+	// orientation is how the stored pixels must be turned to show the
+	// photo upright (1 to 8), 0 when unknown.
+	orientation int
+}
+
+// EXIF tags read by parseExif.
+const (
+	tagOrientation        = 0x0112
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+)
+
+// parseExif reads the TIFF structure an EXIF block consists of. Whatever is
+// missing or malformed is left out.
+func parseExif(data []byte) Metadata {
+	var meta Metadata
+	t, ok := newTIFF(data)
+	if !ok {
+		return meta
+	}
+	ifd0 := t.ifd(int(t.u32(4)))
+	if o, ok := t.uint(ifd0[tagOrientation]); ok && o >= 1 && o <= 8 {
+		meta.orientation = o
+	}
+
+	if off, ok := t.uint(ifd0[tagExifIFD]); ok {
+		exif := t.ifd(off)
+		if taken, err := time.Parse("2006:01:02 15:04:05", t.ascii(exif[tagDateTimeOriginal])); err == nil {
+			meta.TakenAt = taken.Format("2006-01-02T15:04:05")
+			if offset := t.ascii(exif[tagOffsetTimeOriginal]); len(offset) == 6 && (offset[0] == '+' || offset[0] == '-') {
+				meta.TakenAt += offset
+			}
+		}
+	}
+
+	if off, ok := t.uint(ifd0[tagGPSIFD]); ok {
+		gps := t.ifd(off)
+		lat, latOK := degrees(t.rationals(gps[tagGPSLatitude]), t.ascii(gps[tagGPSLatitudeRef]), "N", "S", 90)
+		lon, lonOK := degrees(t.rationals(gps[tagGPSLongitude]), t.ascii(gps[tagGPSLongitudeRef]), "E", "W", 180)
+		if latOK && lonOK {
+			meta.Latitude, meta.Longitude = &lat, &lon
+		}
+	}
+	return meta
+}
+
+// degrees turns degrees, minutes and seconds into decimal degrees.
+func degrees(dms []float64, ref, positive, negative string, limit float64) (float64, bool) {
+	if len(dms) != 3 || (ref != positive && ref != negative) {
+		return 0, false
+	}
+	d := dms[0] + dms[1]/60 + dms[2]/3600
+	if math.IsNaN(d) || d > limit {
+		return 0, false
+	}
+	if ref == negative {
+		d = -d
+	}
+	return math.Round(d*1e7) / 1e7, true
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffEntry is one field of a directory. Looking up a missing tag gives
+// the zero entry, whose type no reader accepts.
+type tiffEntry struct {
+	typ   uint16
+	count int
+	value int // offset of the value in the data
+}
+
+func newTIFF(data []byte) (tiff, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")):
+		return tiff{data, binary.LittleEndian}, true
+	case bytes.HasPrefix(data, []byte("MM\x00*")):
+		return tiff{data, binary.BigEndian}, true
+	}
+	return tiff{}, false
+}
+
+func (t tiff) u16(off int) uint16 {
+	if off < 0 || off+2 > len(t.data) {
+		return 0
+	}
+	return t.order.Uint16(t.data[off:])
+}
+
+func (t tiff) u32(off int) uint32 {
+	if off < 0 || off+4 > len(t.data) {
+		return 0
+	}
+	return t.order.Uint32(t.data[off:])
+}
+
+// typeSizes are the byte sizes of the TIFF field types.
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// ifd reads the directory at off. Values of up to four bytes are stored in
+// the entry itself, longer ones elsewhere at the offset the entry holds.
+func (t tiff) ifd(off int) map[uint16]tiffEntry {
+	entries := map[uint16]tiffEntry{}
+	if off <= 0 || off+2 > len(t.data) {
+		return entries
+	}
+	n := int(t.u16(off))
+	for i := 0; i < n; i++ {
+		at := off + 2 + 12*i
+		if at+12 > len(t.data) {
+			break
+		}
+		e := tiffEntry{typ: t.u16(at + 2), count: int(t.u32(at + 4)), value: at + 8}
+		size, known := typeSizes[e.typ]
+		if !known || e.count < 0 || e.count > len(t.data) {
+			continue
+		}
+		if size*e.count > 4 {
+			e.value = int(t.u32(at + 8))
+		}
+		if e.value+size*e.count > len(t.data) {
+			continue
+		}
+		entries[t.u16(at)] = e
+	}
+	return entries
+}
+
+// uint reads a SHORT or LONG value.
+func (t tiff) uint(e tiffEntry) (int, bool) {
+	switch {
+	case e.typ == 3 && e.count >= 1:
+		return int(t.u16(e.value)), true
+	case e.typ == 4 && e.count >= 1:
+		return int(t.u32(e.value)), true
+	}
+	return 0, false
+}
+
+func (t tiff) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(t.data[e.value:e.value+e.count]), "\x00 ")
+}
+
+func (t tiff) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	values := make([]float64, e.count)
+	for i := range values {
+		num, den := t.u32(e.value+8*i), t.u32(e.value+8*i+4)
+		if den == 0 {
+			return nil
+		}
+		values[i] = float64(num) / float64(den)
+	}
+	return values
+}
@@ -0,0 +1,198 @@
+package document
+
+import (
+	"encoding/binary"
+)
+
+// HEIF files are ISO base media files: a tree of boxes, each starting with
+// its size and type. The EXIF block is an item of type "Exif"; the meta
+// box's iinf lists the items and its iloc says where their data lies.
+
+type box struct {
+	typ  string
+	body []byte
+	// start is where the body begins in the file.
+	start int
+}
+
+// boxes splits data, found at offset start in the file, into boxes.
+func boxes(data []byte, start int) []box {
+	var found []box
+	for len(data) >= 8 {
+		size, header := int(binary.BigEndian.Uint32(data)), 8
+		switch size {
+		case 0:
+			size = len(data)
+		case 1:
+			if len(data) < 16 {
+				return found
+			}
+			large := binary.BigEndian.Uint64(data[8:])
+			if large > uint64(len(data)) {
+				return found
+			}
+			size, header = int(large), 16
+		}
+		if size < header || size > len(data) {
+			return found
+		}
+		found = append(found, box{typ: string(data[4:8]), body: data[header:size], start: start + header})
+		data, start = data[size:], start+size
+	}
+	return found
+}
+
+func child(parent []box, typ string) (box, bool) {
+	for _, b := range parent {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// heicExif returns where the data of the Exif item lies in the file.
+func heicExif(content []byte) (start, end int, ok bool) {
+	meta, ok := child(boxes(content, 0), "meta")
+	if !ok || len(meta.body) < 4 {
+		return 0, 0, false
+	}
+	// meta is a full box: version and flags come before its children
+	children := boxes(meta.body[4:], meta.start+4)
+	iinf, ok1 := child(children, "iinf")
+	iloc, ok2 := child(children, "iloc")
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	id, ok := exifItemID(iinf.body)
+	if !ok {
+		return 0, 0, false
+	}
+	start, length, ok := itemLocation(iloc.body, id)
+	if !ok || start+length > len(content) {
+		return 0, 0, false
+	}
+	return start, start + length, true
+}
+
+func exifItemID(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return 0, false
+		}
+		entries = iinf[8:]
+	}
+	for _, infe := range boxes(entries, 0) {
+		b := infe.body
+		if infe.typ != "infe" || len(b) < 4 || b[0] < 2 {
+			continue
+		}
+		// version 2 has 16-bit item IDs, version 3 32-bit ones
+		var id uint32
+		var typeAt int
+		if b[0] == 2 && len(b) >= 12 {
+			id, typeAt = uint32(binary.BigEndian.Uint16(b[4:])), 8
+		} else if b[0] == 3 && len(b) >= 14 {
+			id, typeAt = binary.BigEndian.Uint32(b[4:]), 10
+		} else {
+			continue
+		}
+		if string(b[typeAt:typeAt+4]) == "Exif" {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// itemLocation reads the item's single extent from iloc. Items made of
+// several extents or stored outside the file are not supported.
+func itemLocation(iloc []byte, id uint32) (start, length int, ok bool) {
+	r := reader{data: iloc}
+	version := r.uint(1)
+	r.uint(3) // flags
+	sizes := r.uint(1)
+	offsetSize, lengthSize := sizes>>4, sizes&0xF
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := sizes>>4, uint64(0)
+	if version == 1 || version == 2 {
+		indexSize = sizes & 0xF
+	}
+	count := r.uint(2)
+	if version == 2 {
+		count = r.uint(4)
+	}
+	for i := uint64(0); i < count && !r.failed; i++ {
+		itemID := r.uint(2)
+		if version == 2 {
+			itemID = r.uint(4)
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0xF
+		}
+		r.uint(2) // data reference index
+		base := r.uint(int(baseOffsetSize))
+		extents := r.uint(2)
+		var offset, size uint64
+		for j := uint64(0); j < extents && !r.failed; j++ {
+			r.uint(int(indexSize))
+			offset, size = r.uint(int(offsetSize)), r.uint(int(lengthSize))
+		}
+		if itemID == uint64(id) {
+			if r.failed || method != 0 || extents != 1 || base+offset > 1<<40 || size > 1<<40 {
+				return 0, 0, false
+			}
+			return int(base + offset), int(size), true
+		}
+	}
+	return 0, 0, false
+}
+
+// reader reads big-endian integers of 0 to 8 bytes, remembering when it
+// ran out of data.
+type reader struct {
+	data   []byte
+	failed bool
+}
+
+func (r *reader) uint(n int) uint64 {
+	if n > 8 || n > len(r.data) {
+		r.failed = true
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// stripHEIC blanks the Exif item in place, leaving an empty EXIF block of
+// the same size so the rest of the file is untouched.
+func stripHEIC(content []byte) ([]byte, Metadata) {
+	start, end, ok := heicExif(content)
+	if !ok || end-start < 4 {
+		return content, Metadata{}
+	}
+	item := content[start:end]
+	// The item starts with the offset of the TIFF header past its first 4 bytes
+	tiffStart := 4 + int(binary.BigEndian.Uint32(item))
+	var meta Metadata
+	if tiffStart >= 4 && tiffStart < len(item) {
+		meta = parseExif(item[tiffStart:])
+	}
+
+	stripped := append([]byte(nil), content...)
+	blank := stripped[start:end]
+	clear(blank)
+	// An empty little-endian TIFF: header, then an IFD with no entries
+	if empty := []byte("\x00\x00\x00\x00II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00"); len(blank) >= len(empty) {
+		copy(blank, empty)
+	}
+	return stripped, meta
+}
@@ -0,0 +1,176 @@
+package document
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// ThumbnailSize is the longest side of a thumbnail, in pixels.
+	ThumbnailSize = 320
+	// maxPixels keeps small files that decode to huge images out.
+	maxPixels = 50_000_000
+)
+
+// Photo is a photo ready to be stored.
+type Photo struct {
+	Content  []byte
+	Metadata Metadata
+	// Thumbnail is a small JPEG for review, nil for HEIC photos, which the
+	// standard library cannot decode.
+	Thumbnail []byte
+}
+
+// NormalizePhoto strips a checked JPEG, PNG or HEIC photo of its metadata
+// after reading the capture time and location. JPEG and PNG photos are
+// decoded, turned upright and encoded again, which leaves every other
+// piece of metadata behind. HEIC photos keep their pixels and have their
+// EXIF block blanked in place.
+func NormalizePhoto(u Upload) (Photo, error) {
+	var meta Metadata
+	switch u.Type {
+	case JPEG:
+		meta = parseExif(jpegExif(u.Content))
+	case PNG:
+		meta = parseExif(pngExif(u.Content))
+	case HEIC:
+		stripped, exif := stripHEIC(u.Content)
+		return Photo{Content: stripped, Metadata: exif}, nil
+	default:
+		return Photo{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(u.Content))
+	if err != nil || config.Width*config.Height > maxPixels {
+		return Photo{}, ErrInvalidImage
+	}
+	img, _, err := image.Decode(bytes.NewReader(u.Content))
+	if err != nil {
+		return Photo{}, ErrInvalidImage
+	}
+	img = orient(img, meta.orientation)
+
+	var content, thumb bytes.Buffer
+	if u.Type == JPEG {
+		err = jpeg.Encode(&content, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&content, img)
+	}
+	if err != nil {
+		return Photo{}, err
+	}
+	if err := jpeg.Encode(&thumb, thumbnail(img), &jpeg.Options{Quality: 80}); err != nil {
+		return Photo{}, err
+	}
+	return Photo{Content: content.Bytes(), Metadata: meta, Thumbnail: thumb.Bytes()}, nil
+}
+
+// jpegExif finds the EXIF block in the APP1 segments before the image data.
+func jpegExif(content []byte) []byte {
+	data := content[2:]
+	for len(data) >= 4 && data[0] == 0xFF {
+		marker := data[1]
+		if marker == 0xFF {
+			data = data[1:] // fill byte
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[2:]))
+		if size < 2 || 2+size > len(data) {
+			break
+		}
+		segment := data[4 : 2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		data = data[2+size:]
+	}
+	return nil
+}
+
+// pngExif returns the content of the eXIf chunk.
+func pngExif(content []byte) []byte {
+	data := content[8:]
+	for len(data) >= 12 {
+		size := int(binary.BigEndian.Uint32(data))
+		if 12+size > len(data) {
+			break
+		}
+		if string(data[4:8]) == "eXIf" {
+			return data[8 : 8+size]
+		}
+		data = data[12+size:]
+	}
+	return nil
+}
+
+// orient turns img as the EXIF orientation says, so it shows upright
+// without the tag.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// src maps a pixel of the upright image to the stored one
+	src := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return w - 1 - x, y },
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		4: func(x, y int) (int, int) { return x, h - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return y, h - 1 - x },
+		7: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x },
+		8: func(x, y int) (int, int) { return w - 1 - y, x },
+	}[orientation]
+
+	upright := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			upright.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return upright
+}
+
+// thumbnail scales img down to fit ThumbnailSize, averaging up to 4x4
+// samples of the area each thumbnail pixel covers.
+func thumbnail(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > ThumbnailSize || h > ThumbnailSize {
+		if w >= h {
+			tw, th = ThumbnailSize, max(1, h*ThumbnailSize/w)
+		} else {
+			tw, th = max(1, w*ThumbnailSize/h), ThumbnailSize
+		}
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy += max(1, (y1-y0)/4) {
+				for sx := x0; sx < x1; sx += max(1, (x1-x0)/4) {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			thumb.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return thumb
+}
@@ -0,0 +1,50 @@
+package document
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Sniff identifies a file by its first bytes. Types other than the ones
+// this package handles give "".
+func Sniff(content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG
+	case bytes.HasPrefix(content, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(content, []byte("%PDF-")):
+		return PDF
+	case isHEIC(content):
+		return HEIC
+	}
+	return ""
+}
+
+// isHEIC looks for a HEVC-coded HEIF brand in the leading ftyp box. The
+// generic mif1 brand alone is not enough, AVIF images carry it too.
+func isHEIC(content []byte) bool {
+	if len(content) < 16 || string(content[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(content))
+	if size < 16 || size > len(content) {
+		return false
+	}
+	isHEVC := func(brand string) bool {
+		switch brand {
+		case "heic", "heix", "hevc", "hevx":
+			return true
+		}
+		return false
+	}
+	if isHEVC(string(content[8:12])) {
+		return true
+	}
+	for i := 16; i+4 <= size; i += 4 {
+		if isHEVC(string(content[i : i+4])) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,26 +1,25 @@
 package handlers
 
 import (
-	"database/sql"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
-	"time"
 
-	"loan-service-engine/db"
+	"loan-service-engine/config"
+	"loan-service-engine/document"
+	"loan-service-engine/loanstate"
 	"loan-service-engine/models"
+	"loan-service-engine/repository"
 
 	"github.com/gin-gonic/gin"
 )
 
-func ApproveLoan(c *gin.Context) {
-	role := c.GetString("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can approve loans"})
-		return
-	}
+// proofTypes are the photo formats accepted as visit proof.
+var proofTypes = []string{document.JPEG, document.PNG, document.HEIC}
+
+func (s *Service) ApproveLoan(c *gin.Context) {
+	limitBody(c, config.MaxProofSize)
 
 	// Parse multipart form fields
 	loanIDStr := c.PostForm("loan_id")
@@ -28,16 +27,15 @@ func ApproveLoan(c *gin.Context) {
 	approvedAt := c.PostForm("approval_date") // expected in YYYY-MM-DD
 	file, err := c.FormFile("visit_proof")
 
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondUploadError(c, "Proof image", config.MaxProofSize, proofTypes, err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid proof image"})
 		return
 	}
-	log.Println("Proof image: ", file.Filename)
-
-	log.Println("loan id ", loanIDStr)
-	log.Println("validator id ", validatorID)
-	log.Println("approved at ", approvedAt)
-	//log.Println("file ", file.Filename)
 
 	// Validate fields
 	if loanIDStr == "" || validatorID == "" || approvedAt == "" || file == nil {
@@ -51,89 +49,150 @@ func ApproveLoan(c *gin.Context) {
 		return
 	}
 
+	// The photo is checked by its content and stored without its metadata,
+	// apart from the capture time and location recorded on the approval
+	proof, err := document.Read(file, config.MaxProofSize, proofTypes...)
+	if err != nil {
+		respondUploadError(c, "Proof image", config.MaxProofSize, proofTypes, err)
+		return
+	}
+	photo, err := document.NormalizePhoto(proof)
+	if err != nil {
+		respondUploadError(c, "Proof image", config.MaxProofSize, proofTypes, err)
+		return
+	}
+	scanStatus, ok := s.scanUpload(c, "Proof image", proof.Content, photo.Content, photo.Thumbnail)
+	if !ok {
+		return
+	}
+	approval := models.ApprovalInfo{
+		ValidatorID:  validatorID,
+		ApprovedAt:   approvedAt,
+		PhotoTakenAt: photo.Metadata.TakenAt,
+		Latitude:     photo.Metadata.Latitude,
+		Longitude:    photo.Metadata.Longitude,
+	}
+
+	// The proof image is stored with the approval and removed again if the approval fails
+	upload := stageUpload(s.Files, s.Store)
+	defer upload.Cleanup()
+	proofDoc := upload.Add(photo.Content, proof.Type, proof.Ext(), scanStatus)
+	approval.ProofURL = proofDoc.Key
+	if photo.Thumbnail != nil {
+		approval.ThumbnailURL = upload.Add(photo.Thumbnail, document.JPEG, ".jpg", scanStatus).Key
+	}
+
+	// Approval record and status change are written in one transaction
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
 	// Check if loan exists and in proposed state
-	var currentStatus string
-	err = db.DB.QueryRow(`SELECT status FROM loans WHERE id = ?`, loanID).Scan(&currentStatus)
-	if err == sql.ErrNoRows {
+	loan, err := tx.Loans().GetForUpdate(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
 		return
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	if currentStatus != "proposed" {
+	if !loanstate.Default.CanTransition(loanstate.State(loan.Status), loanstate.Approved) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Loan must be in 'proposed' state to approve"})
 		return
 	}
-
-	// Save proof image
-	timestamp := time.Now().Unix()
-	filename := fmt.Sprintf("proof_%d_%s", timestamp, filepath.Base(file.Filename))
-	savePath := filepath.Join("uploads", filename)
-
-	if err := c.SaveUploadedFile(file, savePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+	// A record already waiting for its file's virus scan must not be replaced
+	waiting := false
+	existing, err := tx.Approvals().Get(c, loanID)
+	if err == nil {
+		waiting, err = quarantined(c, tx, existing.ProofURL)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Scan status lookup failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if waiting {
+		c.JSON(http.StatusConflict, gin.H{"error": "Loan approval is waiting for its visit proof to pass the virus scan"})
 		return
 	}
-	proofURL := "/uploads/" + filename
 
 	// Insert into approvals table
-	_, err = db.DB.Exec(`
-		INSERT INTO approvals (loan_id, validator_id, proof_url, approved_at)
-		VALUES (?, ?, ?, ?)
-	`, loanID, validatorID, proofURL, approvedAt)
-
+	err = tx.Approvals().Create(c, loanID, approval)
 	if err != nil {
 		log.Println("Error inserting approval:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record approval"})
 		return
 	}
 
-	// Update loan status to 'approved'
-	_, err = db.DB.Exec(`
-		UPDATE loans SET status = 'approved' WHERE id = ?
-	`, loanID)
+	if err := upload.Promote(c, tx); err != nil {
+		log.Println("Error saving proof image:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
 
-	if err != nil {
-		log.Println("Error updating loan status:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan status"})
+	// Update loan status to 'approved', unless the proof is quarantined: the
+	// approval then waits for the proof to pass a rescan
+	if !upload.Quarantined() {
+		_, err = loanstate.Default.Transition(c, tx, loanID, loanstate.Approved, c.GetInt("userID"), "")
+		if err != nil {
+			log.Println("Error updating loan status:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan status"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing approval:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record approval"})
 		return
 	}
+	upload.Keep()
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Loan approved",
-		"proof_url":   proofURL,
-		"approved_at": approvedAt,
-	})
+	response := gin.H{
+		"message":      "Loan approved",
+		"proof_url":    loanFileURL(loanID, "proof"),
+		"proof_sha256": proofDoc.SHA256,
+		"approved_at":  approvedAt,
+	}
+	status := http.StatusOK
+	if upload.Quarantined() {
+		status = http.StatusAccepted
+		response["message"] = "Approval recorded; the loan is approved once the visit proof passes the virus scan"
+		response["scan_status"] = models.ScanQuarantined
+	}
+	if approval.ThumbnailURL != "" {
+		response["thumbnail_url"] = loanFileURL(loanID, "proof-thumbnail")
+	}
+	if approval.PhotoTakenAt != "" {
+		response["photo_taken_at"] = approval.PhotoTakenAt
+	}
+	if approval.Latitude != nil {
+		response["latitude"], response["longitude"] = *approval.Latitude, *approval.Longitude
+	}
+	c.JSON(status, response)
 }
 
-func ListLoans(c *gin.Context) {
-	rows, err := db.DB.Query(`
-		SELECT 
-			id, borrower_id_number, amount, rate, roi, status
-		FROM loans
-	`)
+func (s *Service) ListLoans(c *gin.Context) {
+	stored, err := s.Store.Loans().List(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loans"})
 		return
 	}
-	defer rows.Close()
 
 	var loans []models.LoanResponse
-	for rows.Next() {
-		var loan models.LoanResponse
-		if err := rows.Scan(
-			&loan.ID,
-			&loan.BorrowerIDNumber,
-			&loan.Amount,
-			&loan.Rate,
-			&loan.ROI,
-			&loan.Status,
-		); err != nil {
-			log.Println("Scan error:", err)
-			continue
-		}
-		loans = append(loans, loan)
+	for _, loan := range stored {
+		loans = append(loans, models.LoanResponse{
+			ID:               loan.ID,
+			BorrowerIDNumber: loan.BorrowerIDNumber,
+			Amount:           loan.Amount,
+			Rate:             loan.Rate,
+			ROI:              loan.ROI,
+			TenureMonths:     loan.TenureMonths,
+			RepaymentMethod:  loan.RepaymentMethod,
+			Status:           loan.Status,
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{"loans": loans})
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyPrefix marks API keys so they are easy to recognise, for example by
+// secret scanners.
+const apiKeyPrefix = "lse_"
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// ExpiresInDays is optional; without it the key works until revoked.
+	ExpiresInDays int `json:"expires_in_days" binding:"min=0"`
+}
+
+// CreateAPIKey issues a key that acts for the calling admin, limited to the
+// requested scopes. The key is only returned here.
+func (s *Service) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	for _, scope := range req.Scopes {
+		if !slices.Contains(middleware.APIKeyScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API keys cannot carry this permission: " + scope})
+			return
+		}
+		if !middleware.HasPermission(c, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You can only grant permissions you hold: " + scope})
+			return
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		log.Println("Failed to generate API key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create API key"})
+		return
+	}
+	key := apiKeyPrefix + token
+	now := time.Now().UTC()
+	apiKey := models.APIKey{
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   middleware.HashAPIKey(key),
+		Scopes:    req.Scopes,
+		CreatedBy: c.GetInt("userID"),
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	if req.ExpiresInDays > 0 {
+		apiKey.ExpiresAt = now.AddDate(0, 0, req.ExpiresInDays).Format(time.RFC3339)
+	}
+	apiKey.ID, err = s.Store.APIKeys().Create(c, apiKey)
+	if err != nil {
+		log.Println("Failed to insert API key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create API key"})
+		return
+	}
+
+	log.Printf("Admin %d created API key %d (%s) with scopes %v", apiKey.CreatedBy, apiKey.ID, apiKey.Name, apiKey.Scopes)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Store the key now, it cannot be shown again",
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// ListAPIKeys shows every key, including revoked ones, without the keys themselves.
+func (s *Service) ListAPIKeys(c *gin.Context) {
+	keys, err := s.Store.APIKeys().List(c)
+	if err != nil {
+		log.Println("Failed to list API keys:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// RevokeAPIKey stops a key from working immediately.
+func (s *Service) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("key_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+	err = s.Store.APIKeys().Revoke(c, id, time.Now().UTC().Format(time.RFC3339))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found or already revoked"})
+		return
+	} else if err != nil {
+		log.Println("Failed to revoke API key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke API key"})
+		return
+	}
+
+	log.Printf("Admin %d revoked API key %d", c.GetInt("userID"), id)
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
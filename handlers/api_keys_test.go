@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPIKeys(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	adminID, _ := store.Users().Create(ctx, models.User{
+		Username: "admin", Email: "admin@email.com", PasswordHash: string(hash), Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z",
+	})
+	keySvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.POST("/login", keySvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.POST("/logout", middleware.RequireUser(), keySvc.Logout)
+	api.GET("/admin/loans", middleware.RequirePermission("loan:read"), keySvc.ListLoans)
+	api.POST("/admin/loan/:loan_id/reject", middleware.RequirePermission("loan:reject"), keySvc.RejectLoan)
+	api.GET("/admin/users/:user_id/roles", middleware.RequirePermission("role:manage"), keySvc.GetUserRoles)
+	api.POST("/admin/api-keys", middleware.RequireUser(), middleware.RequirePermission("api_key:manage"), keySvc.CreateAPIKey)
+	api.GET("/admin/api-keys", middleware.RequirePermission("api_key:manage"), keySvc.ListAPIKeys)
+	api.DELETE("/admin/api-keys/:key_id", middleware.RequirePermission("api_key:manage"), keySvc.RevokeAPIKey)
+
+	resp := doJSON(router, "POST", "/login", "", map[string]string{"username": "admin", "password": "secret"})
+	var login map[string]string
+	json.Unmarshal(resp.Body.Bytes(), &login)
+	admin := login["token"]
+
+	withKey := func(method, path, key string) int {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", key)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if resp := doJSON(router, "POST", "/api/admin/api-keys", admin, map[string]interface{}{"name": "field app", "scopes": []string{"investment:create"}}); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected a scope the admin lacks to be refused, got %d", resp.Code)
+	}
+	// Keys skip the second factor, so they cannot manage accounts
+	for _, scope := range []string{"role:manage", "user:manage", "user:invite", "api_key:manage"} {
+		if resp := doJSON(router, "POST", "/api/admin/api-keys", admin, map[string]interface{}{"name": "admin tool", "scopes": []string{"loan:read", scope}}); resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected scope %s to be refused, got %d", scope, resp.Code)
+		}
+	}
+	resp = doJSON(router, "POST", "/api/admin/api-keys", admin, map[string]interface{}{"name": "field app", "scopes": []string{"loan:read", "loan:read"}})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Create failed: %s", resp.Body.String())
+	}
+	var created struct {
+		Key    string        `json:"key"`
+		APIKey models.APIKey `json:"api_key"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if len(created.APIKey.Scopes) != 1 || created.Key[:len(created.APIKey.Prefix)] != created.APIKey.Prefix {
+		t.Errorf("Unexpected key %+v", created)
+	}
+
+	// The key works within its scopes only, and not for user endpoints
+	if code := withKey("GET", "/api/admin/loans", created.Key); code != http.StatusOK {
+		t.Errorf("Expected the key to list loans, got %d", code)
+	}
+	if code := withKey("POST", "/api/admin/loan/1/reject", created.Key); code != http.StatusForbidden {
+		t.Errorf("Expected a permission outside the scopes to be refused, got %d", code)
+	}
+	for _, path := range []string{"/api/logout", "/api/admin/api-keys"} {
+		if code := withKey("POST", path, created.Key); code != http.StatusForbidden {
+			t.Errorf("Expected %s to refuse API keys, got %d", path, code)
+		}
+	}
+	if code := withKey("GET", "/api/admin/loans", created.Key+"0"); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown key to be refused, got %d", code)
+	}
+
+	resp = doJSON(router, "GET", "/api/admin/api-keys", admin, nil)
+	var listed struct {
+		APIKeys []models.APIKey `json:"api_keys"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &listed)
+	if len(listed.APIKeys) != 1 || listed.APIKeys[0].LastUsedAt == "" {
+		t.Errorf("Expected one key with a last-used time, got %s", resp.Body.String())
+	}
+
+	// The key is bound by the issuing admin's current permissions
+	store.Roles().Revoke(ctx, adminID, "admin")
+	if code := withKey("GET", "/api/admin/loans", created.Key); code != http.StatusForbidden {
+		t.Errorf("Expected the key to lose permissions its issuer lost, got %d", code)
+	}
+	store.Roles().Grant(ctx, adminID, "admin", adminID, "2025-01-01T00:00:00Z")
+
+	keyPath := "/api/admin/api-keys/" + strconv.Itoa(created.APIKey.ID)
+	if resp := doJSON(router, "DELETE", keyPath, admin, nil); resp.Code != http.StatusOK {
+		t.Fatalf("Revoke failed: %s", resp.Body.String())
+	}
+	if code := withKey("GET", "/api/admin/loans", created.Key); code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be refused, got %d", code)
+	}
+	if resp := doJSON(router, "DELETE", keyPath, admin, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking twice to be 404, got %d", resp.Code)
+	}
+
+	// A key given an account management scope before cannot use it
+	store.APIKeys().Create(ctx, models.APIKey{Name: "old admin tool", Prefix: "lse_old", KeyHash: middleware.HashAPIKey("lse_oldkey"),
+		Scopes: []string{"loan:read", "role:manage"}, CreatedBy: adminID, CreatedAt: "2025-01-01T00:00:00Z"})
+	if code := withKey("GET", "/api/admin/users/"+strconv.Itoa(adminID)+"/roles", "lse_oldkey"); code != http.StatusForbidden {
+		t.Errorf("Expected an old key to lose role:manage, got %d", code)
+	}
+	if code := withKey("GET", "/api/admin/loans", "lse_oldkey"); code != http.StatusOK {
+		t.Errorf("Expected the old key to keep its loan scope, got %d", code)
+	}
+}
@@ -2,15 +2,13 @@
 package handlers
 
 import (
-	"database/sql"
-	"loan-service-engine/config"
-	"loan-service-engine/db"
+	"errors"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,48 +17,88 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-func Login(c *gin.Context) {
-	var jwtSecret = config.JwtSecret
+func (s *Service) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	var id int
-	var hashedPassword, role string
-
-	err := db.DB.QueryRow(`SELECT id, password, role FROM users WHERE username = ?`, req.Username).
-		Scan(&id, &hashedPassword, &role)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials1"})
+	if wait, err := s.loginRetryAfter(c, req.Username); err != nil {
+		log.Println("Failed to check login failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
 		return
-	} else if err != nil {
+	} else if wait > 0 {
+		tooManyLoginAttempts(c, wait)
+		return
+	}
+
+	// Unknown usernames and wrong passwords get the same answer, after the
+	// same bcrypt work, so logins do not reveal which usernames exist
+	user, err := s.Store.Users().GetByUsername(c, req.Username)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
 		log.Println("Query error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
 		return
 	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password))
+	hash := user.PasswordHash
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials2"})
+		hash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil || err != nil {
+		s.recordLoginFailure(c, req.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	if user.EmailVerifiedAt == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified, check your inbox for the verification link"})
+		return
+	}
+
+	credential, err := s.Store.TwoFactor().GetTOTP(c, user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to load two-factor settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+		return
+	}
+	if err == nil && credential.ConfirmedAt != "" {
+		s.startTwoFactorLogin(c, user)
 		return
 	}
 
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":  id,
-		"role": role,
-		"exp":  time.Now().Add(24 * time.Hour).Unix(),
-	})
+	s.logIn(c, user, "")
+}
+
+// logIn starts a session for an authenticated user and responds with its tokens.
+func (s *Service) logIn(c *gin.Context, user models.User, twoFactorVerifiedAt string) {
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	defer tx.Rollback()
 
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	session, err := startSession(c, tx, user.ID, twoFactorVerifiedAt)
 	if err != nil {
+		log.Println("Failed to create session:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
 		return
 	}
+	tokens, err := issueTokens(c, tx, user, session)
+	if err != nil {
+		log.Println("Failed to issue tokens:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	if _, err := s.Store.LoginFailures().Clear(c, scopeUsername, user.Username); err != nil {
+		log.Println("Failed to clear login failures:", err)
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-	})
+	c.JSON(http.StatusOK, tokens)
 }
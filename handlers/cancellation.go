@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"loan-service-engine/loanstate"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Service) RejectLoan(c *gin.Context) {
+	loanID, err := strconv.Atoi(c.Param("loan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+		return
+	}
+
+	var req models.RejectLoanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A rejection reason is required"})
+		return
+	}
+
+	err = s.transitionLoan(c, loanID, loanstate.Rejected, c.GetInt("userID"), req.Reason)
+	if err != nil {
+		respondTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Loan rejected",
+		"loan_id": loanID,
+		"reason":  req.Reason,
+	})
+}
+
+// CancelLoan lets a requester withdraw their own proposed loan, and an admin
+// cancel a proposed loan or an approved loan that failed to fund. Cancelling
+// an approved loan releases its investments and notifies the investors.
+func (s *Service) CancelLoan(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	loanID, err := strconv.Atoi(c.Param("loan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+		return
+	}
+
+	var req models.CancelLoanRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	loan, err := tx.Loans().GetForUpdate(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Without loan:cancel, users may only withdraw their own proposals
+	if !middleware.HasPermission(c, "loan:cancel") {
+		if loan.RequesterID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only cancel your own loans"})
+			return
+		}
+		if loan.Status != string(loanstate.Proposed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only loans in 'proposed' state can be withdrawn"})
+			return
+		}
+	}
+
+	t, err := loanstate.Default.Transition(c, tx, loanID, loanstate.Cancelled, userID, req.Reason)
+	if err != nil {
+		respondTransitionError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit cancellation:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel loan"})
+		return
+	}
+
+	if t.From == loanstate.Approved {
+		s.notifyInvestorsOfCancellation(c, loanID, req.Reason)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Loan cancelled",
+		"loan_id": loanID,
+	})
+}
+
+// notifyInvestorsOfCancellation mails a refund notice for every investment
+// released when a loan was cancelled. The notices name each investor's
+// amount, so they go to the investors only and never into the response.
+func (s *Service) notifyInvestorsOfCancellation(ctx context.Context, loanID int, reason string) {
+	investments, err := s.Store.Investments().ListByLoan(ctx, loanID)
+	if err != nil {
+		log.Println("Error fetching investors for cancellation notification:", err)
+		return
+	}
+
+	for _, r := range loanstate.Refunds(investments) {
+		s.Mail(utils.ComposeCancellationEmail(r.Email, loanID, r.Amount, reason))
+	}
+}
@@ -0,0 +1,115 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"loan-service-engine/db"
+	"loan-service-engine/middleware"
+	"loan-service-engine/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func doJSON(router *gin.Engine, method, path, token string, payload interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := http.NewRequest(method, path, &body)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestRejectAndCancelLoan(t *testing.T) {
+	setupTestEnv(t)
+	gin.SetMode(gin.TestMode)
+
+	router := gin.Default()
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(svc.Store))
+	api.POST("/admin/loan/:loan_id/reject", middleware.RequirePermission("loan:reject"), svc.RejectLoan)
+	api.POST("/admin/loan/:loan_id/cancel", middleware.RequirePermission("loan:cancel"), svc.CancelLoan)
+	api.POST("/requester/loans/:loan_id/cancel", middleware.RequirePermission("loan:cancel:own"), svc.CancelLoan)
+
+	db.DB.Exec(`INSERT INTO loans (id, borrower_id_number, amount, rate, roi, status, requester_id) VALUES
+		(1, '1111111111111111', 100000000, 12, 10, 'proposed', 2),
+		(2, '2222222222222222', 100000000, 12, 10, 'proposed', 2),
+		(3, '3333333333333333', 100000000, 12, 10, 'approved', 2)`)
+	db.DB.Exec(`INSERT INTO investments (loan_id, investor_id, amount, investment_date) VALUES
+		(3, 4, 30000000, '2025-06-25'),
+		(3, 5, 20000000, '2025-06-25')`)
+
+	tokenAdmin := login(t, "admin", "admin123")
+	tokenRequester := login(t, "loan_requester1", "loan123")
+	tokenOtherRequester := login(t, "loan_requester2", "loan123")
+
+	// Rejecting needs a reason
+	resp := doJSON(router, "POST", "/api/admin/loan/1/reject", tokenAdmin, map[string]string{"reason": " "})
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected rejection without reason to fail, got %d", resp.Code)
+	}
+	resp = doJSON(router, "POST", "/api/admin/loan/1/reject", tokenAdmin, map[string]string{"reason": "Incomplete documents"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("RejectLoan failed: %s", resp.Body.String())
+	}
+
+	// A rejected loan is final
+	resp = doJSON(router, "POST", "/api/requester/loans/1/cancel", tokenRequester, nil)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected cancel of rejected loan to fail, got %d", resp.Code)
+	}
+
+	// Requesters can only withdraw their own loans
+	resp = doJSON(router, "POST", "/api/requester/loans/2/cancel", tokenOtherRequester, nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 when cancelling someone else's loan, got %d", resp.Code)
+	}
+	resp = doJSON(router, "POST", "/api/requester/loans/2/cancel", tokenRequester, map[string]string{"reason": "No longer needed"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Requester CancelLoan failed: %s", resp.Body.String())
+	}
+
+	// Requesters cannot cancel an approved loan, admins can
+	resp = doJSON(router, "POST", "/api/requester/loans/3/cancel", tokenRequester, nil)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected requester cancel of approved loan to fail, got %d", resp.Code)
+	}
+	var mailed []utils.EmailPreview
+	svc.Mail = func(e utils.EmailPreview) { mailed = append(mailed, e) }
+	resp = doJSON(router, "POST", "/api/admin/loan/3/cancel", tokenAdmin, map[string]string{"reason": "Funding window closed"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Admin CancelLoan failed: %s", resp.Body.String())
+	}
+	if len(mailed) != 2 {
+		t.Errorf("Expected 2 investor notifications to be mailed, got %d", len(mailed))
+	}
+	// The admin does not see the investors' emails or amounts
+	if strings.Contains(resp.Body.String(), "@") {
+		t.Errorf("Expected no investor details in the response, got %s", resp.Body.String())
+	}
+
+	var active int
+	db.DB.QueryRow(`SELECT COUNT(*) FROM investments WHERE loan_id = 3 AND status = 'active'`).Scan(&active)
+	if active != 0 {
+		t.Errorf("Expected all investments to be released, %d still active", active)
+	}
+
+	for id, expected := range map[int]string{1: "rejected", 2: "cancelled", 3: "cancelled"} {
+		var status string
+		db.DB.QueryRow(`SELECT status FROM loans WHERE id = ?`, id).Scan(&status)
+		if status != expected {
+			t.Errorf("Loan %d: expected %s, got %s", id, expected, status)
+		}
+	}
+}
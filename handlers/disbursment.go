@@ -1,25 +1,26 @@
 package handlers
 
 import (
-	"database/sql"
-	"fmt"
-	"loan-service-engine/db"
+	"errors"
+	"loan-service-engine/config"
+	"loan-service-engine/document"
+	"loan-service-engine/loanstate"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func DisburseLoan(c *gin.Context) {
-	role := c.GetString("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can disburse loans"})
-		return
-	}
+// agreementTypes are the formats accepted for signed agreements.
+var agreementTypes = []string{document.PDF}
+
+func (s *Service) DisburseLoan(c *gin.Context) {
 	adminID := c.GetInt("userID")
+	limitBody(c, config.MaxAgreementSize)
 
 	// Parse form fields
 	loanIDStr := c.PostForm("loan_id")
@@ -27,6 +28,11 @@ func DisburseLoan(c *gin.Context) {
 	disbursementDate := c.PostForm("disbursement_date")
 	file, err := c.FormFile("signed_agreement")
 
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondUploadError(c, "Signed agreement", config.MaxAgreementSize, agreementTypes, err)
+		return
+	}
 	if loanIDStr == "" || fieldOfficerID == "" || disbursementDate == "" || file == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
 		return
@@ -38,54 +44,112 @@ func DisburseLoan(c *gin.Context) {
 		return
 	}
 
+	if _, err := time.Parse("2006-01-02", disbursementDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "disbursement_date must be in YYYY-MM-DD format"})
+		return
+	}
+
+	agreement, err := document.Read(file, config.MaxAgreementSize, agreementTypes...)
+	if err != nil {
+		respondUploadError(c, "Signed agreement", config.MaxAgreementSize, agreementTypes, err)
+		return
+	}
+	scanStatus, ok := s.scanUpload(c, "Signed agreement", agreement.Content)
+	if !ok {
+		return
+	}
+
+	// The signed agreement is stored with the disbursement and removed again if the disbursement fails
+	upload := stageUpload(s.Files, s.Store)
+	defer upload.Cleanup()
+	agreementDoc := upload.Add(agreement.Content, agreement.Type, agreement.Ext(), scanStatus)
+
+	// Disbursement record, status change and repayment schedule are written in one transaction
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+		return
+	}
+	defer tx.Rollback()
+
 	// Check loan exists and status
-	var currentStatus string
-	err = db.DB.QueryRow(`SELECT status FROM loans WHERE id = ?`, loanID).Scan(&currentStatus)
-	if err == sql.ErrNoRows {
+	loan, err := tx.Loans().GetForUpdate(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
 		return
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
 		return
 	}
-	if currentStatus != "invested" {
+	if !loanstate.Default.CanTransition(loanstate.State(loan.Status), loanstate.Disbursed) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Only 'invested' loans can be disbursed"})
 		return
 	}
-
-	// Save uploaded file
-	filename := fmt.Sprintf("signed_agreement_loan%d_%d%s", loanID, time.Now().Unix(), filepath.Ext(file.Filename))
-	savePath := filepath.Join("uploads", filename)
-	if err := c.SaveUploadedFile(file, savePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	// A record already waiting for its file's virus scan must not be replaced
+	waiting := false
+	existing, err := tx.Disbursements().Get(c, loanID)
+	if err == nil {
+		waiting, err = quarantined(c, tx, existing.SignedAgreementURL)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Scan status lookup failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if waiting {
+		c.JSON(http.StatusConflict, gin.H{"error": "Disbursement is waiting for its signed agreement to pass the virus scan"})
 		return
 	}
-	fileURL := "/uploads/" + filename
 
 	// Insert disbursement record
-	_, err = db.DB.Exec(`
-		INSERT INTO disbursements (loan_id, disbursed_at, field_officer_id, agreement_url, admin_id)
-		VALUES (?, ?, ?, ?, ?)
-	`, loanID, disbursementDate, fieldOfficerID, fileURL, adminID)
+	err = tx.Disbursements().Create(c, loanID, adminID, models.DisbursementInfo{
+		OfficerID:          fieldOfficerID,
+		DisbursedAt:        disbursementDate,
+		SignedAgreementURL: agreementDoc.Key,
+	})
 	if err != nil {
 		log.Println("Disbursement insert failed:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record disbursement"})
 		return
 	}
 
-	// Update loan status
-	_, err = db.DB.Exec(`UPDATE loans SET status = 'disbursed' WHERE id = ?`, loanID)
-	if err != nil {
-		log.Println("Loan status update failed:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan status"})
+	if err := upload.Promote(c, tx); err != nil {
+		log.Println("Saving signed agreement failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	// Update loan status; the repayment schedule is generated as part of the
+	// transition. A quarantined agreement holds the disbursement until it
+	// passes a rescan.
+	if !upload.Quarantined() {
+		_, err = loanstate.Default.Transition(c, tx, loanID, loanstate.Disbursed, adminID, "")
+		if err != nil {
+			log.Println("Loan status update failed:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan status"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Disbursement commit failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record disbursement"})
+		return
+	}
+	upload.Keep()
+
+	response := gin.H{
 		"message":          "Loan disbursed",
-		"agreement_url":    fileURL,
+		"agreement_url":    loanFileURL(loanID, "signed-agreement"),
+		"agreement_sha256": agreementDoc.SHA256,
 		"disbursed_by":     adminID,
 		"field_officer_id": fieldOfficerID,
 		"disbursed_at":     disbursementDate,
-	})
+	}
+	status := http.StatusOK
+	if upload.Quarantined() {
+		status = http.StatusAccepted
+		response["message"] = "Disbursement recorded; the loan is disbursed once the signed agreement passes the virus scan"
+		response["scan_status"] = models.ScanQuarantined
+	}
+	c.JSON(status, response)
 }
@@ -0,0 +1,81 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"loan-service-engine/db"
+	"loan-service-engine/middleware"
+	"loan-service-engine/scheduler"
+	"loan-service-engine/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFundingDeadlineExpiry(t *testing.T) {
+	setupTestEnv(t)
+	gin.SetMode(gin.TestMode)
+
+	router := gin.Default()
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(svc.Store))
+	api.POST("/investor/invest", middleware.RequirePermission("investment:create"), svc.InvestInLoan)
+
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	future := time.Now().UTC().Add(24 * time.Hour).Format(time.RFC3339)
+	db.DB.Exec(`INSERT INTO loans (id, borrower_id_number, amount, rate, roi, status, requester_id, funding_deadline) VALUES
+		(1, '1111111111111111', 100000000, 12, 10, 'approved', 2, ?),
+		(2, '2222222222222222', 100000000, 12, 10, 'approved', 2, ?)`, past, future)
+	db.DB.Exec(`INSERT INTO investments (loan_id, investor_id, amount, investment_date) VALUES
+		(1, 4, 30000000, '2025-06-25'),
+		(2, 5, 30000000, '2025-06-25')`)
+
+	// Investing after the deadline is refused even before the scheduler runs
+	tokenInvestor := login(t, "investor3", "investor123")
+	resp := doJSON(router, "POST", "/api/investor/invest", tokenInvestor, map[string]interface{}{"loan_id": 1, "amount": 200000})
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected investment past the deadline to fail, got %d", resp.Code)
+	}
+
+	var mailed []utils.EmailPreview
+	mail := func(e utils.EmailPreview) { mailed = append(mailed, e) }
+	expired, err := scheduler.ExpireOverdueLoans(context.Background(), svc.Store, mail, time.Now())
+	if err != nil {
+		t.Fatalf("ExpireOverdueLoans failed: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != 1 {
+		t.Fatalf("Expected only loan 1 to expire, got %v", expired)
+	}
+
+	var status, investmentStatus string
+	db.DB.QueryRow(`SELECT status FROM loans WHERE id = 1`).Scan(&status)
+	db.DB.QueryRow(`SELECT status FROM investments WHERE loan_id = 1`).Scan(&investmentStatus)
+	if status != "expired" || investmentStatus != "refunded" {
+		t.Errorf("Expected expired loan with refunded investment, got %s / %s", status, investmentStatus)
+	}
+	var investorEmail string
+	db.DB.QueryRow(`SELECT email FROM users WHERE id = 4`).Scan(&investorEmail)
+	if len(mailed) != 1 || mailed[0].To != investorEmail || mailed[0].Subject != "Loan #1 Expired" {
+		t.Errorf("Expected the investor of loan 1 to be mailed, got %+v", mailed)
+	}
+
+	db.DB.QueryRow(`SELECT status FROM loans WHERE id = 2`).Scan(&status)
+	db.DB.QueryRow(`SELECT status FROM investments WHERE loan_id = 2`).Scan(&investmentStatus)
+	if status != "approved" || investmentStatus != "active" {
+		t.Errorf("Loan within its window should be untouched, got %s / %s", status, investmentStatus)
+	}
+
+	// A run with its own clock expires, and records, by that clock
+	later := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Second)
+	expired, err = scheduler.ExpireOverdueLoans(context.Background(), svc.Store, mail, later)
+	if err != nil || len(expired) != 1 || expired[0] != 2 {
+		t.Fatalf("Expected loan 2 to expire by the run's clock, got %v (err %v)", expired, err)
+	}
+	var changedAt string
+	db.DB.QueryRow(`SELECT changed_at FROM loan_status_history WHERE loan_id = 2 AND to_status = 'expired'`).Scan(&changedAt)
+	if changedAt != later.Format(time.RFC3339) {
+		t.Errorf("Expected the expiry to be recorded at %s, got %s", later.Format(time.RFC3339), changedAt)
+	}
+}
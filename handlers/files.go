@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"loan-service-engine/config"
+	"loan-service-engine/middleware"
+	"loan-service-engine/pdf"
+	"loan-service-engine/repository"
+	"loan-service-engine/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loanFileURL is where DownloadLoanFile serves one of a loan's files.
+func loanFileURL(loanID int, file string) string {
+	return fmt.Sprintf("/api/loans/%d/files/%s", loanID, file)
+}
+
+// DownloadLoanFile hands a loan's documents to the users allowed to see
+// them: the visit proof, its thumbnail and the signed agreement to admins
+// and the borrower, and an investor's agreement to that investor. Admins
+// pick the investor with ?investor=<username>. The response redirects to a
+// short-lived signed link to the file. Files quarantined for a virus scan
+// are refused.
+func (s *Service) DownloadLoanFile(c *gin.Context) {
+	key, ok := s.loanFileKey(c)
+	if !ok {
+		return
+	}
+	waiting, err := quarantined(c, s.Store, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if waiting {
+		c.JSON(http.StatusConflict, gin.H{"error": "File is quarantined until it passes the virus scan"})
+		return
+	}
+	s.redirectToFile(c, key)
+}
+
+// VerifyLoanFile re-hashes one of a loan's uploaded files and compares it
+// with the SHA-256 recorded when it was uploaded, so auditors can show the
+// document was not altered since. The same users as DownloadLoanFile may
+// verify a file.
+func (s *Service) VerifyLoanFile(c *gin.Context) {
+	key, ok := s.loanFileKey(c)
+	if !ok {
+		return
+	}
+	doc, err := s.Store.Documents().GetByKey(c, key)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No integrity record for this file"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	result := gin.H{
+		"file":         c.Param("file"),
+		"sha256":       doc.SHA256,
+		"size":         doc.Size,
+		"content_type": doc.ContentType,
+		"uploaded_at":  doc.CreatedAt,
+	}
+	f, err := s.Files.Get(c, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		result["intact"] = false
+		result["error"] = "Stored file is missing"
+		c.JSON(http.StatusOK, result)
+		return
+	} else if err != nil {
+		log.Printf("Reading %s for verification failed: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load file"})
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		log.Printf("Reading %s for verification failed: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load file"})
+		return
+	}
+	computed := hex.EncodeToString(h.Sum(nil))
+	result["computed_sha256"] = computed
+	result["computed_size"] = size
+	result["intact"] = computed == doc.SHA256 && size == doc.Size
+	c.JSON(http.StatusOK, result)
+}
+
+// loanFileKey finds the storage key of the file named in the request, after
+// checking the caller may see it. It responds with the error itself and
+// returns false when there is no file to hand out.
+func (s *Service) loanFileKey(c *gin.Context) (string, bool) {
+	loanID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return "", false
+	}
+	loan, err := s.Store.Loans().Get(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return "", false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return "", false
+	}
+
+	var key string
+	switch c.Param("file") {
+	case "proof", "proof-thumbnail", "signed-agreement":
+		if !canAccessLoan(c, loan.RequesterID, "loan:read", "loan:read:own") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to download this file"})
+			return "", false
+		}
+		var storedURL string
+		if c.Param("file") != "signed-agreement" {
+			approval, err := s.Store.Approvals().Get(c, loanID)
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return "", false
+			}
+			storedURL = approval.ProofURL
+			if c.Param("file") == "proof-thumbnail" {
+				storedURL = approval.ThumbnailURL
+			}
+		} else {
+			disbursement, err := s.Store.Disbursements().Get(c, loanID)
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return "", false
+			}
+			storedURL = disbursement.SignedAgreementURL
+		}
+		if storedURL != "" {
+			key = storageKey(storedURL)
+		}
+	case "agreement":
+		investor := c.Query("investor")
+		if investor != "" && !middleware.HasPermission(c, "loan:read") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to download this file"})
+			return "", false
+		}
+		investments, err := s.Store.Investments().ListByLoan(c, loanID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return "", false
+		}
+		for _, inv := range investments {
+			if inv.Investor == investor || (investor == "" && inv.InvestorID == c.GetInt("userID")) {
+				key = pdf.AgreementKey(loanID, inv.InvestorID)
+				break
+			}
+		}
+		if key == "" && investor == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to download this file"})
+			return "", false
+		}
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return "", false
+	}
+
+	if key == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return "", false
+	}
+	return key, true
+}
+
+// redirectToFile sends the client to a signed link to the stored file, valid
+// for config.FileURLTTL.
+func (s *Service) redirectToFile(c *gin.Context, key string) {
+	url, err := s.Files.SignedURL(c, key, config.FileURLTTL)
+	if err != nil {
+		log.Printf("Signing link to %s failed: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load file"})
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
@@ -1,31 +1,29 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
-	"loan-service-engine/db"
+	"loan-service-engine/loanstate"
+	"loan-service-engine/models"
+	"loan-service-engine/money"
 	"loan-service-engine/pdf"
+	"loan-service-engine/repository"
 	"loan-service-engine/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 type InvestRequest struct {
-	LoanID int     `json:"loan_id" binding:"required"`
-	Amount float64 `json:"amount" binding:"required"`
+	LoanID int          `json:"loan_id" binding:"required"`
+	Amount money.Amount `json:"amount" binding:"required"`
 }
 
-func InvestInLoan(c *gin.Context) {
-	role := c.GetString("role")
-	if role != "investor" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only investors can invest in loans"})
-		return
-	}
-
+func (s *Service) InvestInLoan(c *gin.Context) {
 	userID := c.GetInt("userID")
 
 	var req InvestRequest
@@ -34,35 +32,55 @@ func InvestInLoan(c *gin.Context) {
 		return
 	}
 
+	// Placement, the total check and the status change run in one transaction.
+	// The loan is read with GetForUpdate (SQLite's BEGIN IMMEDIATE, a row lock
+	// on Postgres), so concurrent investors are serialised and cannot
+	// overfund the loan.
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		log.Println("Failed to start investment transaction:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
 	// 1. Check loan status and amount
-	var status string
-	var loanAmount float64
-	err := db.DB.QueryRow(`SELECT status, amount FROM loans WHERE id = ?`, req.LoanID).Scan(&status, &loanAmount)
-	if err == sql.ErrNoRows {
+	loan, err := tx.Loans().GetForUpdate(c, req.LoanID)
+	if errors.Is(err, repository.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
 		return
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	loanAmount := loan.Amount
+
+	if loan.RequesterID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot invest in your own loan"})
+		return
+	}
 
-	if status != "approved" {
+	if loan.Status != string(loanstate.Approved) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Can only invest in loans that are approved"})
 		return
 	}
 
+	if loan.FundingDeadline != "" && loan.FundingDeadline < time.Now().UTC().Format(time.RFC3339) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The funding window for this loan has closed"})
+		return
+	}
+
 	// Calculate 10% minimum
-	minInvestment := loanAmount * 0.10
+	minInvestment := loanAmount.Percent(10)
 	if req.Amount < minInvestment {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Minimum investment is 10%% of loan amount (Rp %.0f)", minInvestment),
+			"error": fmt.Sprintf("Minimum investment is 10%% of loan amount (Rp %s)", minInvestment),
 		})
 		return
 	}
 
 	// 2. Check current total investment
-	var totalInvested float64
-	err = db.DB.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM investments WHERE loan_id = ?`, req.LoanID).Scan(&totalInvested)
+	totalInvested, err := tx.Investments().TotalActive(c, req.LoanID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check total investment"})
 		return
@@ -83,7 +101,7 @@ func InvestInLoan(c *gin.Context) {
 	if futureRemaining < minInvestment && futureRemaining > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf(
-				"This investment would leave only Rp %.0f remaining, which is below the minimum allowed (Rp %.0f). Please adjust your investment to fully fund the loan.",
+				"This investment would leave only Rp %s remaining, which is below the minimum allowed (Rp %s). Please adjust your investment to fully fund the loan.",
 				futureRemaining, minInvestment,
 			),
 		})
@@ -91,11 +109,12 @@ func InvestInLoan(c *gin.Context) {
 	}
 
 	// 3. Insert investment
-	_, err = db.DB.Exec(`
-		INSERT INTO investments (loan_id, investor_id, amount, investment_date)
-		VALUES (?, ?, ?, ?)
-	`, req.LoanID, userID, req.Amount, time.Now().Format("2006-01-02"))
-
+	_, err = tx.Investments().Create(c, models.Investment{
+		LoanID:         req.LoanID,
+		InvestorID:     userID,
+		Amount:         req.Amount,
+		InvestmentDate: time.Now().Format("2006-01-02"),
+	})
 	if err != nil {
 		log.Println("Insert investment failed:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record investment"})
@@ -104,62 +123,49 @@ func InvestInLoan(c *gin.Context) {
 
 	// 4. Recalculate total — did we fully fund the loan?
 	totalInvested += req.Amount
-	if totalInvested == loanAmount {
+	fullyFunded := totalInvested == loanAmount
+	if fullyFunded {
 		// Update loan status to 'invested'
-		_, err = db.DB.Exec(`UPDATE loans SET status = 'invested' WHERE id = ?`, req.LoanID)
+		_, err = loanstate.Default.Transition(c, tx, req.LoanID, loanstate.Invested, userID, "")
 		if err != nil {
 			log.Println("Failed to update loan status to 'invested':", err)
-		} else {
-			// Simulate sending email to all investors
-			go NotifyInvestorsOfAgreement(req.LoanID, c)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record investment"})
+			return
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit investment:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record investment"})
+		return
+	}
+
+	if fullyFunded {
+		// Mail every investor their agreement
+		go s.NotifyInvestorsOfAgreement(context.Background(), req.LoanID)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":           "Investment recorded",
 		"total_invested":    totalInvested,
-		"loan_fully_funded": totalInvested == loanAmount,
+		"loan_fully_funded": fullyFunded,
 	})
 }
 
-func NotifyInvestorsOfAgreement(loanID int, c *gin.Context) {
-	rows, err := db.DB.Query(`
-		SELECT u.username, u.id, i.amount, u.email
-		FROM investments i
-		JOIN users u ON i.investor_id = u.id
-		WHERE i.loan_id = ?
-	`, loanID)
+// NotifyInvestorsOfAgreement generates each investor's agreement PDF and
+// mails them the link to its download endpoint.
+func (s *Service) NotifyInvestorsOfAgreement(ctx context.Context, loanID int) {
+	investments, err := s.Store.Investments().ListByLoan(ctx, loanID)
 	if err != nil {
 		log.Println("Error fetching investors for agreement notification:", err)
 		return
 	}
-	defer rows.Close()
-
-	var previews []utils.EmailPreview
-	for rows.Next() {
-		var username string
-		var userID int
-		var amount float64
-		var email string
-		if err := rows.Scan(&username, &userID, &amount, &email); err == nil {
-			pdfURL, err := pdf.GenerateAgreementPDF(loanID, username, amount)
-			if err != nil {
-				log.Printf("Failed to generate PDF for %s: %v", username, err)
-				continue
-			}
-			emailPreview := utils.ComposeAgreementEmail(email, loanID, pdfURL)
-			previews = append(previews, emailPreview)
+
+	for _, inv := range investments {
+		if _, err := pdf.GenerateAgreementPDF(ctx, s.Files, loanID, inv.InvestorID, inv.Investor, inv.Amount); err != nil {
+			log.Printf("Failed to generate PDF for %s: %v", inv.Investor, err)
+			continue
 		}
+		s.Mail(utils.ComposeAgreementEmail(inv.Email, loanID, loanFileURL(loanID, "agreement")))
 	}
-
-	// At this point we successfully composed emails for each of the investors.
-	// In the actual system, we could add another function here to send the composed emails
-	// using SMTP or service like SendGrim/Mailgun.
-	// for example:
-	// utils.SendAgreementEmails(previews)
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":        "Investment successful and loan fully funded",
-		"email_previews": previews,
-	})
 }
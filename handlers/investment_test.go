@@ -0,0 +1,85 @@
+package handlers_test
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+
+	"loan-service-engine/db"
+	"loan-service-engine/middleware"
+	"loan-service-engine/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Many investors racing for the same loan must never push it past its
+// principal, and the loan must move to 'invested' exactly once.
+func TestConcurrentInvestmentsDoNotOverfund(t *testing.T) {
+	setupTestEnv(t)
+	gin.SetMode(gin.TestMode)
+	defer os.RemoveAll("uploads")
+
+	router := gin.New()
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(svc.Store))
+	api.POST("/investor/invest", middleware.RequirePermission("investment:create"), svc.InvestInLoan)
+
+	db.DB.Exec(`INSERT INTO loans (id, borrower_id_number, amount, rate, roi, status, requester_id)
+		VALUES (1, '1111111111111111', 100000000, 12, 10, 'approved', 2)`)
+
+	tokens := []string{
+		login(t, "investor1", "investor123"),
+		login(t, "investor2", "investor123"),
+		login(t, "investor3", "investor123"),
+		login(t, "investor4", "investor123"),
+	}
+
+	const attempts = 40
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		failed    []int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := doJSON(router, "POST", "/api/investor/invest", tokens[i%len(tokens)],
+				map[string]interface{}{"loan_id": 1, "amount": 100000})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch resp.Code {
+			case http.StatusOK:
+				succeeded++
+			case http.StatusBadRequest:
+			default:
+				failed = append(failed, resp.Code)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		t.Errorf("Unexpected response codes: %v", failed)
+	}
+	if succeeded != 10 {
+		t.Errorf("Expected exactly 10 investments of 100,000 to succeed, got %d", succeeded)
+	}
+
+	var total money.Amount
+	db.DB.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM investments WHERE loan_id = 1`).Scan(&total)
+	if total != money.FromRupiah(1000000) {
+		t.Errorf("Loan should be funded exactly to its principal, got %s", total)
+	}
+
+	var status string
+	var transitions int
+	db.DB.QueryRow(`SELECT status FROM loans WHERE id = 1`).Scan(&status)
+	db.DB.QueryRow(`SELECT COUNT(*) FROM loan_status_history WHERE loan_id = 1 AND to_status = 'invested'`).Scan(&transitions)
+	if status != "invested" || transitions != 1 {
+		t.Errorf("Expected a single move to 'invested', got status %s with %d transitions", status, transitions)
+	}
+}
@@ -1,25 +1,25 @@
 package handlers
 
 import (
-	"fmt"
-	"loan-service-engine/db"
+	"context"
+	"errors"
+	"loan-service-engine/config"
+	"loan-service-engine/loanstate"
+	"loan-service-engine/middleware"
 	"loan-service-engine/models"
+	"loan-service-engine/money"
 	"loan-service-engine/pdf"
+	"loan-service-engine/repayment"
+	"loan-service-engine/repository"
+	"loan-service-engine/storage"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
-func CreateLoan(c *gin.Context) {
-	role := c.GetString("role")
-	if role != "requester" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only requesters can create loans"})
-		return
-	}
+func (s *Service) CreateLoan(c *gin.Context) {
 	userID := c.GetInt("userID")
 
 	var req models.CreateLoanRequest
@@ -29,11 +29,11 @@ func CreateLoan(c *gin.Context) {
 	}
 
 	// Validate loan amount range
-	if req.Amount < 1000000 {
+	if req.Amount < money.FromRupiah(1000000) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Minimum loan amount is Rp 1,000,000"})
 		return
 	}
-	if req.Amount > 100000000 {
+	if req.Amount > money.FromRupiah(100000000) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum loan amount is Rp 100,000,000"})
 		return
 	}
@@ -46,103 +46,212 @@ func CreateLoan(c *gin.Context) {
 		return
 	}
 
-	_, err := db.DB.Exec(`
-		INSERT INTO loans (borrower_id_number, amount, rate, roi, status, requester_id)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, req.BorrowerIDNumber, req.Amount, req.Rate, req.ROI, "proposed", userID)
+	if req.RepaymentMethod == "" {
+		req.RepaymentMethod = repayment.MethodFlat
+	}
+	if req.FundingWindowDays == 0 {
+		req.FundingWindowDays = config.FundingWindowDays
+	}
 
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create loan"})
+		return
+	}
+	defer tx.Rollback()
+
+	loanID, err := tx.Loans().Create(c, models.Loan{
+		BorrowerIDNumber:  req.BorrowerIDNumber,
+		Amount:            req.Amount,
+		Rate:              req.Rate,
+		ROI:               req.ROI,
+		TenureMonths:      req.TenureMonths,
+		RepaymentMethod:   req.RepaymentMethod,
+		FundingWindowDays: req.FundingWindowDays,
+		Status:            string(loanstate.Proposed),
+		RequesterID:       userID,
+	})
 	if err != nil {
 		log.Println("Failed to insert loan:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create loan"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Loan created and in proposed state"})
-}
+	if err := loanstate.RecordCreation(c, tx, loanID, userID); err != nil {
+		log.Println("Failed to record loan history:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create loan"})
+		return
+	}
 
-func DownloadLoanAgreement(c *gin.Context) {
-	role := c.GetString("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can download agreements"})
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit loan:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create loan"})
 		return
 	}
 
+	c.JSON(http.StatusCreated, gin.H{"message": "Loan created and in proposed state", "loan_id": loanID})
+}
+
+func (s *Service) DownloadLoanAgreement(c *gin.Context) {
 	loanID, err := strconv.Atoi(c.Param("loan_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
 		return
 	}
 
-	// Simulate generating agreement on-the-fly
-	filename := fmt.Sprintf("agreement_loan%d_borrower.pdf", loanID)
-	path := filepath.Join("uploads", filename)
-
-	// If file doesn't exist, generate it (optional logic)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	// Generate the agreement the first time it is asked for
+	key := pdf.BorrowerAgreementKey(loanID)
+	existing, err := s.Files.Get(c, key)
+	if errors.Is(err, storage.ErrNotFound) {
 		log.Println("Agreement PDF not found. Generating...")
 
-		err := pdf.GenerateBorrowerAgreementPDF(loanID, path)
+		agreement, err := s.borrowerAgreement(c, loanID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		} else if err != nil {
+			log.Printf("Loading agreement data failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate agreement"})
+			return
+		}
+
+		err = pdf.GenerateBorrowerAgreementPDF(c, s.Files, agreement)
 		if err != nil {
 			log.Printf("PDF generation failed: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate agreement"})
 			return
 		}
+	} else if err != nil {
+		log.Printf("Loading agreement failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load agreement"})
+		return
+	} else {
+		existing.Close()
 	}
 
-	c.FileAttachment(path, filename)
+	s.redirectToFile(c, key)
 }
 
-func GetLoanDetails(c *gin.Context) {
-	loanID := c.Param("id")
+// borrowerAgreement gathers what goes on the borrower's copy of the agreement.
+func (s *Service) borrowerAgreement(ctx context.Context, loanID int) (pdf.BorrowerAgreement, error) {
+	loan, err := s.Store.Loans().Get(ctx, loanID)
+	if err != nil {
+		return pdf.BorrowerAgreement{}, err
+	}
+	borrower, err := s.Store.Users().GetByID(ctx, loan.RequesterID)
+	if err != nil {
+		return pdf.BorrowerAgreement{}, err
+	}
+	return pdf.BorrowerAgreement{
+		LoanID:        loan.ID,
+		BorrowerName:  borrower.Username,
+		BorrowerEmail: borrower.Email,
+		NIK:           loan.BorrowerIDNumber,
+		Amount:        loan.Amount,
+		Rate:          loan.Rate,
+		ROI:           loan.ROI,
+	}, nil
+}
 
-	// Base loan info
-	var loan models.LoanDetails
-	err := db.DB.QueryRow(`
-		SELECT l.id, l.borrower_id_number, l.amount, l.rate, l.roi, l.status, u.username
-		FROM loans l
-		JOIN users u ON u.id = l.requester_id
-		WHERE l.id = ?
-	`, loanID).Scan(
-		&loan.ID,
-		&loan.BorrowerIDNumber,
-		&loan.Amount,
-		&loan.Rate,
-		&loan.ROI,
-		&loan.Status,
-		&loan.Requester,
-	)
+// GetLoanDetails shows a loan according to who is asking. Admins see
+// everything and borrowers see their own loans without the investors'
+// names. Investors see loans open for investment and loans they invested
+// in, without the borrower's identity, documents or other investors' names.
+func (s *Service) GetLoanDetails(c *gin.Context) {
+	loanID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
 		return
 	}
 
-	// Approval info (optional)
-	_ = db.DB.QueryRow(`
-		SELECT validator_id, approved_at, proof_url
-		FROM approvals
-		WHERE loan_id = ?
-	`, loanID).Scan(&loan.Approval.ValidatorID, &loan.Approval.ApprovedAt, &loan.Approval.ProofURL)
+	// Base loan info
+	loan, err := s.Store.Loans().Get(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	investments, err := s.Store.Investments().ListByLoan(c, loanID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 
-	// Disbursement info (optional)
-	_ = db.DB.QueryRow(`
-		SELECT field_officer_id, disbursed_at, agreement_url
-		FROM disbursements
-		WHERE loan_id = ?
-	`, loanID).Scan(&loan.Disbursement.OfficerID, &loan.Disbursement.DisbursedAt, &loan.Disbursement.SignedAgreementURL)
+	userID := c.GetInt("userID")
+	staff := middleware.HasPermission(c, "loan:read")
+	full := canAccessLoan(c, loan.RequesterID, "loan:read", "loan:read:own")
+	if !full && !canViewAsInvestor(c, loan, investments) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to view this loan"})
+		return
+	}
 
-	// Investment info
-	rows, _ := db.DB.Query(`
-		SELECT u.username, i.amount
-		FROM investments i
-		JOIN users u ON u.id = i.investor_id
-		WHERE i.loan_id = ?
-	`, loanID)
+	details := models.LoanDetails{
+		ID:              loan.ID,
+		Amount:          loan.Amount,
+		Rate:            loan.Rate,
+		ROI:             loan.ROI,
+		TenureMonths:    loan.TenureMonths,
+		RepaymentMethod: loan.RepaymentMethod,
+		FundingDeadline: loan.FundingDeadline,
+		Status:          loan.Status,
+	}
 
-	for rows.Next() {
-		var inv models.InvestmentInfo
-		_ = rows.Scan(&inv.Investor, &inv.Amount)
-		loan.Investments = append(loan.Investments, inv)
+	// Approval and disbursement info (optional)
+	approval, _ := s.Store.Approvals().Get(c, loanID)
+	disbursement, _ := s.Store.Disbursements().Get(c, loanID)
+	details.Approval.ApprovedAt = approval.ApprovedAt
+	details.Disbursement.DisbursedAt = disbursement.DisbursedAt
+
+	if full {
+		requester, err := s.Store.Users().GetByID(c, loan.RequesterID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+			return
+		}
+		details.BorrowerIDNumber = loan.BorrowerIDNumber
+		details.Requester = requester.Username
+		details.Approval.ValidatorID = approval.ValidatorID
+		details.Disbursement.OfficerID = disbursement.OfficerID
+		if approval.ProofURL != "" {
+			details.Approval.ProofURL = loanFileURL(loanID, "proof")
+		}
+		if approval.ThumbnailURL != "" {
+			details.Approval.ThumbnailURL = loanFileURL(loanID, "proof-thumbnail")
+		}
+		details.Approval.PhotoTakenAt = approval.PhotoTakenAt
+		details.Approval.Latitude, details.Approval.Longitude = approval.Latitude, approval.Longitude
+		if disbursement.SignedAgreementURL != "" {
+			details.Disbursement.SignedAgreementURL = loanFileURL(loanID, "signed-agreement")
+		}
+	}
+
+	// Investment info
+	for _, inv := range investments {
+		info := models.InvestmentInfo{Amount: inv.Amount, Status: inv.Status}
+		if staff || inv.InvestorID == userID {
+			info.Investor = inv.Investor
+		}
+		details.Investments = append(details.Investments, info)
 	}
 
-	c.JSON(http.StatusOK, loan)
+	c.JSON(http.StatusOK, details)
+}
+
+// canViewAsInvestor allows investors to see loans open for investment and
+// loans they have invested in.
+func canViewAsInvestor(c *gin.Context, loan models.Loan, investments []models.Investment) bool {
+	if !middleware.HasPermission(c, "loan:read:investable") {
+		return false
+	}
+	if loan.Status == string(loanstate.Approved) {
+		return true
+	}
+	for _, inv := range investments {
+		if inv.InvestorID == c.GetInt("userID") {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,161 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/pdf"
+	"loan-service-engine/repository"
+	"loan-service-engine/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoanAccessByRole(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	ids := map[string]int{}
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@email.com", Role: "admin"},
+		{Username: "requester1", Email: "requester1@email.com", Role: "requester"},
+		{Username: "requester2", Email: "requester2@email.com", Role: "requester"},
+		{Username: "investor1", Email: "investor1@email.com", Role: "investor"},
+		{Username: "investor2", Email: "investor2@email.com", Role: "investor"},
+		// A username that once named the borrower's copy of the agreement
+		{Username: "borrower", Email: "borrower@email.com", Role: "investor"},
+	} {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		u.PasswordHash, u.EmailVerifiedAt = string(hash), "2025-01-01T00:00:00Z"
+		ids[u.Username], _ = store.Users().Create(ctx, u)
+	}
+
+	// Loan 1 is open for investment, loan 2 is still proposed and loan 3 is
+	// funded by investor2 alone
+	for _, l := range []models.Loan{
+		{BorrowerIDNumber: "1111111111111111", Amount: 1000000, Status: "approved", RequesterID: ids["requester1"]},
+		{BorrowerIDNumber: "1111111111111111", Amount: 1000000, Status: "proposed", RequesterID: ids["requester1"]},
+		{BorrowerIDNumber: "2222222222222222", Amount: 1000000, Status: "invested", RequesterID: ids["requester2"]},
+	} {
+		store.Loans().Create(ctx, l)
+	}
+	store.Approvals().Create(ctx, 1, models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-01-01", ProofURL: "/uploads/proof_access.jpg"})
+	store.Investments().Create(ctx, models.Investment{LoanID: 1, InvestorID: ids["investor1"], Amount: 400000, Status: "active"})
+	store.Investments().Create(ctx, models.Investment{LoanID: 3, InvestorID: ids["investor2"], Amount: 1000000, Status: "active"})
+	store.Investments().Create(ctx, models.Investment{LoanID: 1, InvestorID: ids["borrower"], Amount: 100000, Status: "active"})
+	// The proof is recorded by its old /uploads/ URL
+	files := storage.NewLocal(t.TempDir(), "/files/", nil)
+	files.Put(ctx, "proof_access.jpg", strings.NewReader("proof photo"), "image/jpeg")
+	files.Put(ctx, pdf.AgreementKey(1, ids["investor1"]), strings.NewReader("investor1 agreement"), "application/pdf")
+	files.Put(ctx, pdf.BorrowerAgreementKey(1), strings.NewReader("borrower's copy"), "application/pdf")
+	files.Put(ctx, pdf.AgreementKey(1, ids["borrower"]), strings.NewReader("investor borrower's agreement"), "application/pdf")
+
+	accessSvc := handlers.NewService(store)
+	accessSvc.Files = files
+	router := gin.New()
+	router.GET("/files/*key", gin.WrapH(files))
+	router.POST("/login", accessSvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.GET("/loans/:id", accessSvc.GetLoanDetails)
+	api.GET("/loans/:id/files/:file", accessSvc.DownloadLoanFile)
+
+	tokens := map[string]string{}
+	for username := range ids {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": username, "password": "secret"})
+		var result map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		tokens[username] = result["token"]
+	}
+	details := func(username, path string) (models.LoanDetails, int) {
+		resp := doJSON(router, "GET", path, tokens[username], nil)
+		var d models.LoanDetails
+		json.Unmarshal(resp.Body.Bytes(), &d)
+		return d, resp.Code
+	}
+
+	d, code := details("admin", "/api/loans/1")
+	if code != http.StatusOK || d.BorrowerIDNumber == "" || d.Investments[0].Investor != "investor1" || d.Approval.ProofURL != "/api/loans/1/files/proof" {
+		t.Errorf("Expected admins to see everything, got %d %+v", code, d)
+	}
+	d, code = details("requester1", "/api/loans/1")
+	if code != http.StatusOK || d.BorrowerIDNumber == "" || d.Approval.ProofURL == "" || d.Investments[0].Investor != "" {
+		t.Errorf("Expected the borrower to see their loan without investor names, got %d %+v", code, d)
+	}
+	if _, code := details("requester2", "/api/loans/1"); code != http.StatusForbidden {
+		t.Errorf("Expected another requester to be refused, got %d", code)
+	}
+	d, code = details("investor2", "/api/loans/1")
+	if code != http.StatusOK || d.BorrowerIDNumber != "" || d.Requester != "" || d.Approval.ProofURL != "" || d.Approval.ApprovedAt == "" || d.Investments[0].Investor != "" {
+		t.Errorf("Expected investors to see an open loan without identities, got %d %+v", code, d)
+	}
+	if _, code := details("investor2", "/api/loans/2"); code != http.StatusForbidden {
+		t.Errorf("Expected a proposed loan to be hidden from investors, got %d", code)
+	}
+	if _, code := details("investor1", "/api/loans/3"); code != http.StatusForbidden {
+		t.Errorf("Expected a funded loan to be hidden from other investors, got %d", code)
+	}
+	if d, code := details("investor2", "/api/loans/3"); code != http.StatusOK || d.Investments[0].Investor != "investor2" {
+		t.Errorf("Expected investors to see loans they hold, got %d %+v", code, d)
+	}
+
+	// download follows the redirect to the signed link
+	download := func(username, path string) (string, int) {
+		resp := doJSON(router, "GET", path, tokens[username], nil)
+		if resp.Code != http.StatusFound {
+			return resp.Body.String(), resp.Code
+		}
+		resp = doJSON(router, "GET", resp.Header().Get("Location"), "", nil)
+		if resp.Code == http.StatusOK && !strings.HasPrefix(resp.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("Expected %s to be served as an attachment", path)
+		}
+		return resp.Body.String(), resp.Code
+	}
+	for _, tc := range []struct {
+		username, path string
+		code           int
+	}{
+		{"admin", "/api/loans/1/files/proof", http.StatusOK},
+		{"requester1", "/api/loans/1/files/proof", http.StatusOK},
+		{"requester2", "/api/loans/1/files/proof", http.StatusForbidden},
+		{"investor1", "/api/loans/1/files/proof", http.StatusForbidden},
+		{"", "/api/loans/1/files/proof", http.StatusUnauthorized},
+		{"requester1", "/api/loans/1/files/signed-agreement", http.StatusNotFound},
+		{"investor1", "/api/loans/1/files/agreement", http.StatusOK},
+		{"investor2", "/api/loans/1/files/agreement", http.StatusForbidden},
+		{"investor1", "/api/loans/1/files/agreement?investor=investor1", http.StatusForbidden},
+		{"admin", "/api/loans/1/files/agreement?investor=investor1", http.StatusOK},
+		{"admin", "/api/loans/1/files/agreement?investor=investor2", http.StatusNotFound},
+		{"admin", "/api/loans/1/files/passport", http.StatusNotFound},
+	} {
+		if _, code := download(tc.username, tc.path); code != tc.code {
+			t.Errorf("%s GET %s: expected %d, got %d", tc.username, tc.path, tc.code, code)
+		}
+	}
+	if body, _ := download("requester1", "/api/loans/1/files/proof"); body != "proof photo" {
+		t.Errorf("Unexpected proof contents %q", body)
+	}
+
+	// An investor's username cannot name the borrower's copy
+	if body, _ := download("borrower", "/api/loans/1/files/agreement"); body != "investor borrower's agreement" {
+		t.Errorf("Expected the investor's own agreement, got %q", body)
+	}
+	if f, err := files.Get(ctx, pdf.BorrowerAgreementKey(1)); err == nil {
+		body, _ := io.ReadAll(f)
+		f.Close()
+		if string(body) != "borrower's copy" {
+			t.Errorf("Expected the borrower's copy to be kept, got %q", body)
+		}
+	}
+}
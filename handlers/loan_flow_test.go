@@ -7,6 +7,9 @@ import (
 	"loan-service-engine/db"
 	"loan-service-engine/handlers"
 	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+	"loan-service-engine/repository"
 	"log"
 	"mime/multipart"
 	"net/http"
@@ -19,12 +22,29 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func setupTestEnv() {
+// svc runs the handlers against a fresh test database; setupTestEnv sets it
+// up.
+var svc *handlers.Service
+
+// setupTestEnv builds the test database from the embedded migrations and the
+// development seeds, so every test starts from a clean slate.
+func setupTestEnv(t *testing.T) {
 	config.LoadEnv("../.env")
-	db.Connect("../test_db/loan_service.db")
+	db.Connect(filepath.Join(t.TempDir(), "loan_service.db"))
+	t.Cleanup(func() { db.DB.Close() })
+	if _, err := db.MigrateUp(db.DB); err != nil {
+		t.Fatal("Failed to migrate test database: ", err)
+	}
+	if err := db.SeedDev(db.DB); err != nil {
+		t.Fatal("Failed to seed test database: ", err)
+	}
+	svc = handlers.NewService(repository.NewSQLStore(db.DB))
+}
 
-	// Clean slate
-	tables := []string{"disbursements", "investments", "approvals", "loans"}
+// clearLoans removes every loan and what hangs off it, keeping the users and
+// their sessions.
+func clearLoans() {
+	tables := []string{"loan_status_history", "payouts", "repayments", "repayment_schedules", "disbursements", "investments", "approvals", "documents", "loans"}
 	for _, table := range tables {
 		db.DB.Exec("DELETE FROM " + table)
 		db.DB.Exec("UPDATE sqlite_sequence SET seq = 0 WHERE name = '" + table + "'")
@@ -35,7 +55,7 @@ func setupTestEnv() {
 
 func login(t *testing.T, username, password string) string {
 	router := gin.Default()
-	router.POST("/login", handlers.Login)
+	router.POST("/login", svc.Login)
 
 	payload := map[string]string{"username": username, "password": password}
 	body, _ := json.Marshal(payload)
@@ -54,26 +74,31 @@ func login(t *testing.T, username, password string) string {
 	return result["token"]
 }
 
-func createDummyFile(path string) {
+func createDummyFile(path, content string) {
 	os.MkdirAll(filepath.Dir(path), os.ModePerm)
-	os.WriteFile(path, []byte("dummy content"), 0644)
+	os.WriteFile(path, []byte(content), 0644)
 }
 
 // main test
 func TestLoanLifecycleAndEdgeCases(t *testing.T) {
-	setupTestEnv()
+	setupTestEnv(t)
 	gin.SetMode(gin.TestMode)
 
 	router := gin.Default()
-	router.POST("/login", handlers.Login)
+	router.POST("/login", svc.Login)
 
 	api := router.Group("/api")
-	api.Use(middleware.JWTAuthMiddleware())
-
-	api.POST("/requester/create-loan", middleware.RequireRole("requester"), handlers.CreateLoan)
-	api.POST("/admin/approve-loan", middleware.RequireRole("admin"), handlers.ApproveLoan)
-	api.POST("/investor/invest", middleware.RequireRole("investor"), handlers.InvestInLoan)
-	api.POST("/admin/disburse-loan", middleware.RequireRole("admin"), handlers.DisburseLoan)
+	api.Use(middleware.JWTAuthMiddleware(svc.Store))
+
+	api.POST("/requester/create-loan", middleware.RequirePermission("loan:create"), svc.CreateLoan)
+	api.POST("/admin/approve-loan", middleware.RequirePermission("loan:approve"), svc.ApproveLoan)
+	api.POST("/investor/invest", middleware.RequirePermission("investment:create"), svc.InvestInLoan)
+	api.POST("/admin/disburse-loan", middleware.RequirePermission("loan:disburse"), svc.DisburseLoan)
+	api.GET("/requester/loans/:loan_id/schedule", middleware.RequirePermission("loan:read:own"), svc.GetRepaymentSchedule)
+	api.POST("/requester/loans/:loan_id/repayments", middleware.RequirePermission("repayment:record:own"), svc.RecordRepayment)
+	api.POST("/admin/loan/:loan_id/repayments", middleware.RequirePermission("repayment:record"), svc.RecordRepayment)
+	api.GET("/investor/loans/:loan_id/payouts", middleware.RequirePermission("payout:read:own"), svc.GetInvestorPayouts)
+	api.GET("/admin/loan/:loan_id/history", middleware.RequirePermission("loan:read"), svc.GetLoanStatusHistory)
 
 	// Step 1: Create Loan
 	tokenRequester := login(t, "loan_requester1", "loan123")
@@ -82,6 +107,7 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 		"amount":             1000000,
 		"rate":               12,
 		"roi":                10,
+		"tenure_months":      6,
 	}
 	loanBody, _ := json.Marshal(loanPayload)
 
@@ -97,9 +123,8 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 
 	// Step 2: Approve Loan (happy path)
 	tokenAdmin := login(t, "admin", "admin123")
+	// A phone photo carrying its capture time and location in EXIF
 	proofPath := "../test_db/proof.jpg"
-	createDummyFile(proofPath)
-	//defer os.Remove(proofPath)
 
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
@@ -124,6 +149,18 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 		t.Fatalf("ApproveLoan failed: %s", resp.Body.String())
 	}
 
+	var fundingDeadline string
+	db.DB.QueryRow(`SELECT COALESCE(funding_deadline, '') FROM loans WHERE id = 1`).Scan(&fundingDeadline)
+	if fundingDeadline == "" {
+		t.Error("Expected approval to open a funding window")
+	}
+	var takenAt string
+	var latitude, longitude float64
+	db.DB.QueryRow(`SELECT photo_taken_at, latitude, longitude FROM approvals WHERE loan_id = 1`).Scan(&takenAt, &latitude, &longitude)
+	if takenAt != "2025-06-25T10:15:30+07:00" || latitude != -6.2075 || longitude != 106.845 {
+		t.Errorf("Expected the visit metadata from the photo, got %s at %v, %v", takenAt, latitude, longitude)
+	}
+
 	// Step 3: Invest (happy path, 2 investors)
 	tokenInvestor := login(t, "investor1", "investor123")
 	investPayload := map[string]interface{}{
@@ -159,8 +196,8 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 	}
 
 	// Step 4: Disbursement
-	disbursePath := "test/fixtures/agreement.jpg"
-	createDummyFile(disbursePath)
+	disbursePath := "test/fixtures/agreement.pdf"
+	createDummyFile(disbursePath, "%PDF-1.4\n% signed agreement\n%%EOF\n")
 	defer os.Remove(disbursePath)
 
 	body = &bytes.Buffer{}
@@ -168,7 +205,7 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 	writer.WriteField("loan_id", "1")
 	writer.WriteField("field_officer_id", "EMP999")
 	writer.WriteField("disbursement_date", "2025-06-26")
-	fileWriter, _ = writer.CreateFormFile("signed_agreement", "agreement.jpg")
+	fileWriter, _ = writer.CreateFormFile("signed_agreement", "agreement.pdf")
 	fileBytes, _ = os.ReadFile(disbursePath)
 	fileWriter.Write(fileBytes)
 	writer.Close()
@@ -183,10 +220,138 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 		t.Fatalf("Disbursement failed: %s", resp.Body.String())
 	}
 
+	// Step 5: Requester views the repayment schedule
+	req, _ = http.NewRequest("GET", "/api/requester/loans/1/schedule", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenRequester)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("GetRepaymentSchedule failed: %s", resp.Body.String())
+	}
+	var schedule models.RepaymentSchedule
+	json.Unmarshal(resp.Body.Bytes(), &schedule)
+	if len(schedule.Installments) != 6 {
+		t.Errorf("Expected 6 installments, got %d", len(schedule.Installments))
+	}
+	if schedule.OutstandingBalance != money.FromRupiah(1000000) {
+		t.Errorf("Expected outstanding balance 1000000, got %s", schedule.OutstandingBalance)
+	}
+
+	// Another requester must not see it
+	tokenRequester2 := login(t, "loan_requester2", "loan123")
+	req, _ = http.NewRequest("GET", "/api/requester/loans/1/schedule", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenRequester2)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another requester's schedule, got %d", resp.Code)
+	}
+
+	// Step 6: Repayments. First one covers the first installment (10,000 interest + 166,666.67 principal)
+	// and a bit of the second; the admin then settles everything that is left.
+	repaymentBody, _ := json.Marshal(map[string]interface{}{"amount": 200000, "paid_at": "2025-07-20"})
+	req, _ = http.NewRequest("POST", "/api/requester/loans/1/repayments", bytes.NewBuffer(repaymentBody))
+	req.Header.Set("Authorization", "Bearer "+tokenRequester)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("RecordRepayment failed: %s", resp.Body.String())
+	}
+	var repaymentResult struct {
+		Allocations []models.Allocation `json:"allocations"`
+		Outstanding money.Amount        `json:"outstanding"`
+		LoanStatus  string              `json:"loan_status"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &repaymentResult)
+	if len(repaymentResult.Allocations) != 2 || repaymentResult.Allocations[1].Interest != money.FromRupiah(10000) {
+		t.Errorf("Unexpected allocation: %+v", repaymentResult.Allocations)
+	}
+	if repaymentResult.Outstanding != money.FromRupiah(860000) || repaymentResult.LoanStatus != "disbursed" {
+		t.Errorf("Unexpected repayment result: %+v", repaymentResult)
+	}
+
+	// Overpaying is rejected
+	repaymentBody, _ = json.Marshal(map[string]interface{}{"amount": 860001})
+	req, _ = http.NewRequest("POST", "/api/admin/loan/1/repayments", bytes.NewBuffer(repaymentBody))
+	req.Header.Set("Authorization", "Bearer "+tokenAdmin)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected overpayment to be rejected, got %d", resp.Code)
+	}
+
+	repaymentBody, _ = json.Marshal(map[string]interface{}{"amount": 860000, "paid_at": "2025-07-21"})
+	req, _ = http.NewRequest("POST", "/api/admin/loan/1/repayments", bytes.NewBuffer(repaymentBody))
+	req.Header.Set("Authorization", "Bearer "+tokenAdmin)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Final repayment failed: %s", resp.Body.String())
+	}
+	json.Unmarshal(resp.Body.Bytes(), &repaymentResult)
+	if repaymentResult.LoanStatus != "repaid" {
+		t.Errorf("Expected loan to be repaid, got %s", repaymentResult.LoanStatus)
+	}
+
+	// Step 7: Investor 1 funded half the loan, so gets half the principal back
+	// plus half of the interest earned at 10% ROI (out of the 12% rate).
+	req, _ = http.NewRequest("GET", "/api/investor/loans/1/payouts", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenInvestor)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("GetInvestorPayouts failed: %s", resp.Body.String())
+	}
+	var payouts models.InvestorPayouts
+	json.Unmarshal(resp.Body.Bytes(), &payouts)
+	if len(payouts.Payouts) != 2 {
+		t.Errorf("Expected 2 payouts, got %d", len(payouts.Payouts))
+	}
+	// Each repayment's investor interest (16,666.67 and 33,333.33) is split to the
+	// sen, and investor 1 picks up the rounded-up half sen both times.
+	if payouts.TotalPrincipal != money.FromRupiah(500000) || payouts.TotalInterest.String() != "25000.01" {
+		t.Errorf("Unexpected payout totals: principal %s, interest %s", payouts.TotalPrincipal, payouts.TotalInterest)
+	}
+
+	// Step 8: Every status change was recorded
+	req, _ = http.NewRequest("GET", "/api/admin/loan/1/history", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenAdmin)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("GetLoanStatusHistory failed: %s", resp.Body.String())
+	}
+	var history struct {
+		History []models.StatusChange `json:"history"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &history)
+	expected := []string{"proposed", "approved", "invested", "disbursed", "repaid"}
+	if len(history.History) != len(expected) {
+		t.Fatalf("Expected %d history entries, got %d", len(expected), len(history.History))
+	}
+	for i, change := range history.History {
+		if change.ToStatus != expected[i] {
+			t.Errorf("History entry %d: expected %s, got %s", i, expected[i], change.ToStatus)
+		}
+	}
+	if history.History[1].Actor != "admin" || history.History[2].Actor != "investor2" {
+		t.Errorf("Unexpected actors: %+v", history.History)
+	}
+
 	// EDGE CASE 1: Approve without proof (should fail)
-	setupTestEnv()
+	clearLoans()
 	db.DB.Exec(`INSERT INTO loans (id, borrower_id_number, amount, rate, roi, status, requester_id)
-			VALUES (1, '8888888888888888', 1000000, 12, 10, 'proposed', 2)`)
+			VALUES (1, '8888888888888888', 100000000, 12, 10, 'proposed', 2)`)
 	body = &bytes.Buffer{}
 	writer = multipart.NewWriter(body)
 	writer.WriteField("loan_id", "1")
@@ -205,10 +370,10 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 	}
 
 	// EDGE CASE 2: Leave <10% uninvested (should fail)
-	setupTestEnv()
+	clearLoans()
 	// Create approved loan for testing
 	db.DB.Exec(`INSERT INTO loans (id, borrower_id_number, amount, rate, roi, status, requester_id)
-				VALUES (2, '8888888888888888', 1000000, 12, 10, 'approved', 2)`)
+				VALUES (2, '8888888888888888', 100000000, 12, 10, 'approved', 2)`)
 	db.DB.Exec(`INSERT INTO approvals (loan_id, validator_id, proof_url, approved_at)
 				VALUES (2, 'EMP001', '/dummy.jpg', '2025-06-25')`)
 	tokenInvestor3 := login(t, "investor3", "investor123")
@@ -258,3 +423,63 @@ func TestLoanLifecycleAndEdgeCases(t *testing.T) {
 	os.RemoveAll("uploads")
 
 }
+
+// A failure halfway through approval must not leave the proof image behind
+// or change the loan status.
+func TestFailedApprovalLeavesNoPartialState(t *testing.T) {
+	setupTestEnv(t)
+	gin.SetMode(gin.TestMode)
+	defer os.RemoveAll("uploads")
+
+	router := gin.Default()
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(svc.Store))
+	api.POST("/admin/approve-loan", middleware.RequirePermission("loan:approve"), svc.ApproveLoan)
+
+	// A stale approval row makes the approval insert fail on its UNIQUE loan_id
+	db.DB.Exec(`INSERT INTO loans (id, borrower_id_number, amount, rate, roi, status, requester_id)
+			VALUES (1, '8888888888888888', 100000000, 12, 10, 'proposed', 2)`)
+	db.DB.Exec(`INSERT INTO approvals (loan_id, validator_id, proof_url, approved_at)
+			VALUES (1, 'EMP001', '/dummy.jpg', '2025-06-25')`)
+
+	tokenAdmin := login(t, "admin", "admin123")
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("loan_id", "1")
+	writer.WriteField("field_validator_employee_id", "EMP002")
+	writer.WriteField("approval_date", "2025-06-25")
+	fileWriter, _ := writer.CreateFormFile("visit_proof", "proof.jpg")
+	proof, _ := os.ReadFile("../test_db/proof.jpg")
+	fileWriter.Write(proof)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/api/admin/approve-loan", body)
+	req.Header.Set("Authorization", "Bearer "+tokenAdmin)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected approval to fail, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	entries, _ := os.ReadDir("uploads")
+	for _, entry := range entries {
+		// Agreement PDFs may be written concurrently by other tests' notifications
+		if !strings.HasPrefix(entry.Name(), "agreement_") {
+			t.Errorf("Expected proof image to be cleaned up, found %s", entry.Name())
+		}
+	}
+
+	var status string
+	db.DB.QueryRow(`SELECT status FROM loans WHERE id = 1`).Scan(&status)
+	if status != "proposed" {
+		t.Errorf("Loan status should be unchanged, got %s", status)
+	}
+	var documents int
+	db.DB.QueryRow(`SELECT COUNT(*) FROM documents`).Scan(&documents)
+	if documents != 0 {
+		t.Errorf("Expected no document record, got %d", documents)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loan-service-engine/config"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Failed logins are counted per username and per client IP.
+const (
+	scopeUsername = "username"
+	scopeIP       = "ip"
+)
+
+// freeLoginFailures wrong passwords in a row are answered without a delay.
+const freeLoginFailures = 2
+
+// dummyPasswordHash is checked when the username does not exist, so unknown
+// usernames take as long to refuse as wrong passwords.
+const dummyPasswordHash = "$2a$10$4pm4kZHYNbyYgzTe2yCHLe9dKzK52Ij4vqNeDgb0RUhsEEqV5ZA3a"
+
+// loginRetryAfter reports how long the username and the client must wait
+// before trying to log in again; zero means they may try now.
+func (s *Service) loginRetryAfter(c *gin.Context, username string) (time.Duration, error) {
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, key := range [][2]string{{scopeUsername, username}, {scopeIP, c.ClientIP()}} {
+		f, err := s.Store.LoginFailures().Get(c, key[0], key[1])
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if d := nextLoginAllowed(f).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// nextLoginAllowed is the end of a lockout, or of the delay that grows with
+// each failure of a username beyond the free ones.
+func nextLoginAllowed(f models.LoginFailure) time.Time {
+	if f.LockedUntil != "" {
+		until, _ := time.Parse(time.RFC3339, f.LockedUntil)
+		return until
+	}
+	if f.Scope != scopeUsername || f.Failures <= freeLoginFailures {
+		return time.Time{}
+	}
+	delay := config.LoginDelayBase
+	for n := freeLoginFailures + 1; n < f.Failures && delay < config.LoginLockoutDuration; n++ {
+		delay *= 2
+	}
+	delay = min(delay, config.LoginLockoutDuration)
+	last, _ := time.Parse(time.RFC3339, f.LastFailedAt)
+	return last.Add(delay)
+}
+
+// recordLoginFailure counts a failed login against the username and the
+// client IP, and locks out whichever reached its limit. Errors are only
+// logged, since the login has failed anyway.
+func (s *Service) recordLoginFailure(c *gin.Context, username string) {
+	now := time.Now().UTC()
+	since := now.Add(-config.LoginLockoutDuration).Format(time.RFC3339)
+	limits := []struct {
+		scope, subject string
+		max            int
+	}{
+		{scopeUsername, username, config.LoginMaxFailures},
+		{scopeIP, c.ClientIP(), config.LoginMaxFailuresPerIP},
+	}
+	for _, limit := range limits {
+		f, err := s.Store.LoginFailures().Record(c, limit.scope, limit.subject, now.Format(time.RFC3339), since)
+		if err != nil {
+			log.Println("Failed to record login failure:", err)
+			continue
+		}
+		if f.Failures < limit.max {
+			continue
+		}
+		until := now.Add(config.LoginLockoutDuration).Format(time.RFC3339)
+		if err := s.Store.LoginFailures().Lock(c, limit.scope, limit.subject, until); err != nil {
+			log.Println("Failed to lock out login:", err)
+			continue
+		}
+		log.Printf("Locked out %s %q until %s after %d failed logins", limit.scope, limit.subject, until, f.Failures)
+	}
+}
+
+// tooManyLoginAttempts answers a login made during a delay or lockout. The
+// answer is the same whether or not the username exists.
+func tooManyLoginAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+// ListLoginLockouts shows the usernames and IPs currently locked out.
+func (s *Service) ListLoginLockouts(c *gin.Context) {
+	lockouts, err := s.Store.LoginFailures().ListLocked(c, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Println("Failed to list lockouts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// UnlockUser lifts a lockout of a user's username and forgets its failures.
+func (s *Service) UnlockUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	user, err := s.Store.Users().GetByID(c, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not unlock user"})
+		return
+	}
+
+	if _, err := s.Store.LoginFailures().Clear(c, scopeUsername, user.Username); err != nil {
+		log.Println("Failed to clear login failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not unlock user"})
+		return
+	}
+
+	log.Printf("Admin %d unlocked user %d", c.GetInt("userID"), userID)
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// UnlockIP lifts a lockout of a client IP and forgets its failures.
+func (s *Service) UnlockIP(c *gin.Context) {
+	ip := c.Param("ip")
+	cleared, err := s.Store.LoginFailures().Clear(c, scopeIP, ip)
+	if err != nil {
+		log.Println("Failed to clear login failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not unlock IP"})
+		return
+	}
+	if !cleared {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No failed logins recorded for this IP"})
+		return
+	}
+
+	log.Printf("Admin %d unlocked IP %s", c.GetInt("userID"), ip)
+	c.JSON(http.StatusOK, gin.H{"message": "IP unlocked"})
+}
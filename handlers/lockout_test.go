@@ -0,0 +1,160 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginLockout(t *testing.T) {
+	config.LoadEnv("../.env")
+	defer config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	var investorID int
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@email.com", Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+		{Username: "investor1", Email: "investor1@email.com", Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+	} {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		u.PasswordHash = string(hash)
+		id, err := store.Users().Create(ctx, u)
+		if err != nil {
+			t.Fatalf("Failed to create user %s: %v", u.Username, err)
+		}
+		if u.Role == "investor" {
+			investorID = id
+		}
+	}
+	lockSvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.SetTrustedProxies(config.TrustedProxies)
+	router.POST("/login", lockSvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.POST("/admin/users/:user_id/unlock", middleware.RequirePermission("user:manage"), lockSvc.UnlockUser)
+	api.GET("/admin/lockouts", middleware.RequirePermission("user:manage"), lockSvc.ListLoginLockouts)
+	api.DELETE("/admin/lockouts/ip/:ip", middleware.RequirePermission("user:manage"), lockSvc.UnlockIP)
+
+	login := func(username, password string) (int, string) {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": username, "password": password})
+		return resp.Code, resp.Body.String()
+	}
+	var result map[string]string
+	_, body := login("admin", "secret")
+	json.Unmarshal([]byte(body), &result)
+	admin := result["token"]
+
+	// Unknown usernames and wrong passwords look the same
+	unknownCode, unknownBody := login("ghost", "secret")
+	wrongCode, wrongBody := login("investor1", "wrong")
+	if unknownCode != http.StatusUnauthorized || unknownBody != wrongBody || wrongCode != unknownCode {
+		t.Errorf("Expected identical 401s, got %d %s and %d %s", unknownCode, unknownBody, wrongCode, wrongBody)
+	}
+
+	// After the free failures each attempt must wait
+	login("investor1", "wrong")
+	login("investor1", "wrong")
+	resp := doJSON(router, "POST", "/login", "", map[string]string{"username": "investor1", "password": "secret"})
+	if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected a 1 second delay after 3 failures, got %d Retry-After %q", resp.Code, resp.Header().Get("Retry-After"))
+	}
+
+	// Reaching the limit locks the username out, even with the right password
+	config.LoginDelayBase = 0
+	login("investor1", "wrong")
+	login("investor1", "wrong")
+	if code, _ := login("investor1", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected investor1 to be locked out, got %d", code)
+	}
+	for i := 0; i < 4; i++ {
+		login("ghost", "wrong")
+	}
+	if code, _ := login("ghost", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected unknown usernames to be locked out too, got %d", code)
+	}
+	resp = doJSON(router, "GET", "/api/admin/lockouts", admin, nil)
+	var listed struct {
+		Lockouts []models.LoginFailure `json:"lockouts"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &listed)
+	if len(listed.Lockouts) != 2 {
+		t.Errorf("Expected 2 lockouts, got %s", resp.Body.String())
+	}
+
+	if resp := doJSON(router, "POST", "/api/admin/users/"+strconv.Itoa(investorID)+"/unlock", admin, nil); resp.Code != http.StatusOK {
+		t.Fatalf("Unlock failed: %s", resp.Body.String())
+	}
+	if code, body := login("investor1", "secret"); code != http.StatusOK {
+		t.Errorf("Expected login after unlock, got %d %s", code, body)
+	}
+
+	// Too many failures from one IP lock it out for every username
+	config.LoginMaxFailuresPerIP = 11
+	login("someone", "wrong")
+	if code, _ := login("investor1", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the IP to be locked out, got %d", code)
+	}
+	if resp := doJSON(router, "DELETE", "/api/admin/lockouts/ip/192.0.2.1", admin, nil); resp.Code != http.StatusOK {
+		t.Fatalf("Unlocking the IP failed: %s", resp.Body.String())
+	}
+	if code, _ := login("investor1", "secret"); code != http.StatusOK {
+		t.Errorf("Expected login after unlocking the IP, got %d", code)
+	}
+	if resp := doJSON(router, "DELETE", "/api/admin/lockouts/ip/192.0.2.1", admin, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected unlocking a clean IP to be 404, got %d", resp.Code)
+	}
+
+	// X-Forwarded-For is only believed from a trusted proxy: a client
+	// changing it on every attempt is still counted by its own address
+	forwarded := func(forwardedFor, username, password string) int {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(map[string]string{"username": username, "password": password})
+		req, _ := http.NewRequest("POST", "/login", &body)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	config.LoginMaxFailuresPerIP = 3
+	for i := 0; i < 3; i++ {
+		forwarded("203.0.113."+strconv.Itoa(i), "spoofer", "wrong")
+	}
+	if code := forwarded("203.0.113.99", "investor1", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a spoofed X-Forwarded-For not to escape the IP limit, got %d", code)
+	}
+	if resp := doJSON(router, "DELETE", "/api/admin/lockouts/ip/203.0.113.0", admin, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected the spoofed IP not to be locked out, got %d", resp.Code)
+	}
+	doJSON(router, "DELETE", "/api/admin/lockouts/ip/192.0.2.1", admin, nil)
+
+	// Behind a trusted proxy the forwarded client IP is the one counted
+	router.SetTrustedProxies([]string{"192.0.2.1"})
+	for i := 0; i < 3; i++ {
+		forwarded("203.0.113.7", "proxied", "wrong")
+	}
+	if code := forwarded("203.0.113.7", "investor1", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the forwarded client IP to be locked out, got %d", code)
+	}
+	if code := forwarded("203.0.113.8", "investor1", "secret"); code != http.StatusOK {
+		t.Errorf("Expected other clients behind the proxy to log in, got %d", code)
+	}
+}
@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/loanstate"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The handlers only depend on the repositories, so the whole funding flow
+// runs against the in-memory store without touching SQLite.
+func TestFundingFlowOnMemoryStore(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@email.com", Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+		{Username: "loan_requester1", Email: "requester1@email.com", Role: "requester", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+		{Username: "investor1", Email: "investor1@email.com", Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+	} {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		u.PasswordHash = string(hash)
+		if _, err := store.Users().Create(ctx, u); err != nil {
+			t.Fatalf("Failed to create user %s: %v", u.Username, err)
+		}
+	}
+	memSvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.POST("/login", memSvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.POST("/requester/create-loan", middleware.RequirePermission("loan:create"), memSvc.CreateLoan)
+	api.POST("/investor/invest", middleware.RequirePermission("investment:create"), memSvc.InvestInLoan)
+
+	token := func(username string) string {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": username, "password": "secret"})
+		if resp.Code != 200 {
+			t.Fatalf("Login as %s failed: %s", username, resp.Body.String())
+		}
+		var result map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result["token"]
+	}
+
+	resp := doJSON(router, "POST", "/api/requester/create-loan", token("loan_requester1"), map[string]interface{}{
+		"borrower_id_number": "1122334455667788",
+		"amount":             1000000,
+		"rate":               12,
+		"roi":                10,
+		"tenure_months":      6,
+	})
+	if resp.Code != 201 {
+		t.Fatalf("CreateLoan failed: %s", resp.Body.String())
+	}
+
+	// Approval needs an uploaded proof, so record it directly
+	tx, _ := store.Begin(ctx)
+	tx.Approvals().Create(ctx, 1, models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-01-01", ProofURL: "/proof.jpg"})
+	if _, err := loanstate.Default.Transition(ctx, tx, 1, loanstate.Approved, 1, ""); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	tx.Commit()
+
+	investor := token("investor1")
+	if resp := doJSON(router, "POST", "/api/investor/invest", investor, map[string]interface{}{"loan_id": 1, "amount": 1200000}); resp.Code != 400 {
+		t.Errorf("Expected overfunding to be rejected, got %d", resp.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if resp := doJSON(router, "POST", "/api/investor/invest", investor, map[string]interface{}{"loan_id": 1, "amount": 500000}); resp.Code != 200 {
+			t.Fatalf("Invest failed: %s", resp.Body.String())
+		}
+	}
+
+	loan, _ := store.Loans().Get(ctx, 1)
+	if loan.Status != "invested" {
+		t.Errorf("Expected loan to be invested, got %s", loan.Status)
+	}
+	history, _ := store.Loans().StatusHistory(ctx, 1)
+	if len(history) != 3 || history[2].ToStatus != "invested" || history[0].Actor != "loan_requester1" {
+		t.Errorf("Unexpected history %+v", history)
+	}
+}
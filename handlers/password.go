@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"loan-service-engine/config"
+	"loan-service-engine/repository"
+	"loan-service-engine/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tokenPurposePasswordReset = "password_reset"
+
+// commonPasswords are refused outright, whatever else they contain.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"12345678": true, "123456789": true, "1234567890": true, "87654321": true,
+	"qwerty123": true, "qwertyuiop": true, "1q2w3e4r": true, "1qaz2wsx": true,
+	"iloveyou1": true, "letmein1": true, "welcome1": true, "abc12345": true,
+	"admin123": true, "admin1234": true, "changeme1": true, "trustno1": true,
+}
+
+// checkPasswordPolicy returns why a new password is too weak, or "" when it
+// is acceptable. bcrypt only uses the first 72 bytes, so longer passwords
+// are refused rather than silently cut.
+func checkPasswordPolicy(password, username, email string) string {
+	if len(password) < 8 {
+		return "Password must be at least 8 characters"
+	}
+	if len(password) > 72 {
+		return "Password must be at most 72 bytes"
+	}
+	hasLetter := strings.IndexFunc(password, unicode.IsLetter) >= 0
+	hasOther := strings.IndexFunc(password, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0
+	if !hasLetter || !hasOther {
+		return "Password must mix letters with digits or symbols"
+	}
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return "Password is too common"
+	}
+	for _, personal := range []string{username, strings.Split(email, "@")[0]} {
+		if len(personal) >= 3 && strings.Contains(lower, strings.ToLower(personal)) {
+			return "Password must not contain your username or email"
+		}
+	}
+	return ""
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ChangePassword replaces the caller's password. Every session is logged
+// out and the caller gets a fresh token pair in a new session.
+func (s *Service) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	user, err := s.Store.Users().GetByID(c, c.GetInt("userID"))
+	if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	// A wrong current password counts as a failed login, so a stolen
+	// session cannot be used to guess the password without the lockout
+	if wait, err := s.loginRetryAfter(c, user.Username); err != nil {
+		log.Println("Failed to check login failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	} else if wait > 0 {
+		tooManyLoginAttempts(c, wait)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+		s.recordLoginFailure(c, user.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current one"})
+		return
+	}
+	if problem := checkPasswordPolicy(req.NewPassword, user.Username, user.Email); problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Failed to hash password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+
+	// The new session keeps the second factor the current one passed
+	current, err := s.Store.Sessions().Get(c, c.GetString("sessionID"))
+	if err != nil {
+		log.Println("Failed to load session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := tx.Users().UpdatePassword(c, user.ID, string(hash)); err != nil {
+		log.Println("Failed to update password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	if _, err := tx.Sessions().RevokeAllForUser(c, user.ID, now); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	if _, err := tx.LoginFailures().Clear(c, scopeUsername, user.Username); err != nil {
+		log.Println("Failed to clear login failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	session, err := startSession(c, tx, user.ID, current.TwoFactorVerifiedAt)
+	if err != nil {
+		log.Println("Failed to create session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	tokens, err := issueTokens(c, tx, user, session)
+	if err != nil {
+		log.Println("Failed to issue tokens:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit password change:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+
+	log.Printf("User %d changed their password", user.ID)
+	c.JSON(http.StatusOK, tokens)
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ForgotPassword mails a single-use reset link. It answers the same whether
+// or not the email belongs to an account.
+func (s *Service) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	response := gin.H{"message": "If an account uses this email, a password reset link has been sent"}
+
+	user, err := s.Store.Users().GetByEmail(c, normalizeEmail(req.Email))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusOK, response)
+		return
+	} else if err != nil {
+		log.Println("Failed to look up user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send password reset email"})
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(config.PasswordResetTTL).Format(time.RFC3339)
+	token, err := issueToken(c, s.Store, user.ID, tokenPurposePasswordReset, config.PasswordResetTTL)
+	if err != nil {
+		log.Println("Failed to create password reset token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send password reset email"})
+		return
+	}
+	s.Mail(utils.ComposePasswordResetEmail(user.Email, user.Username, token, expiresAt))
+	c.JSON(http.StatusOK, response)
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// ResetPassword sets a new password with the token from the reset email.
+// Every session of the user is logged out, other reset links stop working
+// and any login lockout of the username is lifted.
+func (s *Service) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	userID, err := tx.Tokens().Consume(c, tokenPurposePasswordReset, hashToken(req.Token), now)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+		return
+	} else if err != nil {
+		log.Println("Failed to redeem password reset token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	user, err := tx.Users().GetByID(c, userID)
+	if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+
+	// A refused password rolls back, so the link can be used again
+	if problem := checkPasswordPolicy(req.NewPassword, user.Username, user.Email); problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Failed to hash password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+
+	if err := tx.Users().UpdatePassword(c, user.ID, string(hash)); err != nil {
+		log.Println("Failed to update password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	if err := tx.Tokens().ConsumeAll(c, user.ID, tokenPurposePasswordReset, now); err != nil {
+		log.Println("Failed to invalidate reset tokens:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	n, err := tx.Sessions().RevokeAllForUser(c, user.ID, now)
+	if err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	if _, err := tx.LoginFailures().Clear(c, scopeUsername, user.Username); err != nil {
+		log.Println("Failed to clear login failures:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit password reset:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+
+	log.Printf("User %d reset their password, %d sessions logged out", user.ID, n)
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, you can now log in with the new password"})
+}
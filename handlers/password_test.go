@@ -0,0 +1,147 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	config.LoadEnv("../.env")
+	defer config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	store := repository.NewMemoryStore()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	store.Users().Create(context.Background(), models.User{
+		Username: "investor1", Email: "investor1@email.com", PasswordHash: string(hash), Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z",
+	})
+
+	pwSvc := handlers.NewService(store)
+	var outbox []utils.EmailPreview
+	pwSvc.Mail = func(e utils.EmailPreview) { outbox = append(outbox, e) }
+	lastToken := func() string {
+		m := mailedToken.FindStringSubmatch(outbox[len(outbox)-1].Body)
+		if m == nil {
+			t.Fatalf("No token in email: %s", outbox[len(outbox)-1].Body)
+		}
+		return m[1]
+	}
+
+	router := gin.New()
+	router.POST("/login", pwSvc.Login)
+	router.POST("/forgot-password", pwSvc.ForgotPassword)
+	router.POST("/reset-password", pwSvc.ResetPassword)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.GET("/loans/:id", pwSvc.GetLoanDetails)
+	api.POST("/password", pwSvc.ChangePassword)
+
+	login := func(password string) (string, int) {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": "investor1", "password": password})
+		var result map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result["token"], resp.Code
+	}
+	canUse := func(token string) bool {
+		// No loans exist, so an accepted token gets a 404
+		return doJSON(router, "GET", "/api/loans/1", token, nil).Code != http.StatusUnauthorized
+	}
+	change := func(token, current, next string) (string, int) {
+		resp := doJSON(router, "POST", "/api/password", token, map[string]string{"current_password": current, "new_password": next})
+		var result map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result["token"], resp.Code
+	}
+
+	// Changing the password needs the current one and a strong new one
+	token, _ := login("secret")
+	other, _ := login("secret")
+	weak := map[string]string{
+		"too short":         "a1b2c3",
+		"letters only":      "correcthorsebattery",
+		"common":            "Password123",
+		"contains username": "my-investor1-pass",
+	}
+	for name, password := range weak {
+		if _, code := change(token, "secret", password); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, code)
+		}
+	}
+	if _, code := change(token, "wrong", "correct-horse-42"); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong current password to be refused, got %d", code)
+	}
+	fresh, code := change(token, "secret", "correct-horse-42")
+	if code != http.StatusOK || fresh == "" {
+		t.Fatalf("Change failed with %d", code)
+	}
+	if canUse(token) || canUse(other) {
+		t.Error("Expected existing sessions to be logged out by a password change")
+	}
+	if !canUse(fresh) {
+		t.Error("Expected the token returned by the change to work")
+	}
+	if _, code := login("secret"); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old password to stop working, got %d", code)
+	}
+
+	// Unknown emails get the same answer and no email
+	resp := doJSON(router, "POST", "/forgot-password", "", map[string]string{"email": "nobody@email.com"})
+	if resp.Code != http.StatusOK || len(outbox) != 0 {
+		t.Errorf("Expected a silent 200 for an unknown email, got %d and %d emails", resp.Code, len(outbox))
+	}
+	doJSON(router, "POST", "/forgot-password", "", map[string]string{"email": "Investor1@Email.com"})
+	older := lastToken()
+	doJSON(router, "POST", "/forgot-password", "", map[string]string{"email": "investor1@email.com"})
+	reset := lastToken()
+
+	// Lock the account out; a reset lifts the lockout
+	config.LoginDelayBase = 0
+	for i := 0; i < config.LoginMaxFailures; i++ {
+		login("wrong")
+	}
+
+	if resp := doJSON(router, "POST", "/reset-password", "", map[string]string{"token": reset, "new_password": "short1"}); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected a weak password to be refused, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "POST", "/reset-password", "", map[string]string{"token": reset, "new_password": "staple-battery-7"}); resp.Code != http.StatusOK {
+		t.Fatalf("Reset failed: %s", resp.Body.String())
+	}
+	if canUse(fresh) {
+		t.Error("Expected sessions to be logged out by a reset")
+	}
+	for name, token := range map[string]string{"used": reset, "older": older, "unknown": "abc"} {
+		if resp := doJSON(router, "POST", "/reset-password", "", map[string]string{"token": token, "new_password": "another-pass-9"}); resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected the %s reset token to be refused, got %d", name, resp.Code)
+		}
+	}
+	token, code = login("staple-battery-7")
+	if code != http.StatusOK || !canUse(token) {
+		t.Errorf("Expected login with the new password, got %d", code)
+	}
+
+	// Guessing the current password through a session counts as failed
+	// logins and locks the username out
+	for i := 0; i < config.LoginMaxFailures; i++ {
+		if _, code := change(token, "wrong", "another-pass-9"); code != http.StatusUnauthorized {
+			t.Errorf("Expected a wrong current password to be refused, got %d", code)
+		}
+	}
+	if _, code := change(token, "staple-battery-7", "another-pass-9"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected password changes to be locked out, got %d", code)
+	}
+	if _, code := login("staple-battery-7"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected logins to be locked out as well, got %d", code)
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"loan-service-engine/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetInvestorPayouts lists what the logged-in investor has received from a loan's repayments.
+func (s *Service) GetInvestorPayouts(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	loanID, err := strconv.Atoi(c.Param("loan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+		return
+	}
+
+	result := models.InvestorPayouts{LoanID: loanID}
+	result.Invested, err = s.Store.Investments().TotalByInvestor(c, loanID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if result.Invested == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No investment found in this loan"})
+		return
+	}
+
+	result.Payouts, err = s.Store.Payouts().ListByInvestor(c, loanID, userID)
+	if err != nil {
+		log.Println("Failed to load payouts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payouts"})
+		return
+	}
+	for _, p := range result.Payouts {
+		result.TotalPrincipal += p.Principal
+		result.TotalInterest += p.Interest
+	}
+	result.TotalReceived = result.TotalPrincipal + result.TotalInterest
+
+	c.JSON(http.StatusOK, result)
+}
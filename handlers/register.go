@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"loan-service-engine/config"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/utils"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tokenPurposeEmailVerification = "email_verification"
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"required,oneof=requester investor admin"`
+	// InviteToken is required to register an admin account.
+	InviteToken string `json:"invite_token"`
+}
+
+// Register creates a requester or investor account, or an admin account
+// from an invite. Requesters and investors must verify their email before
+// they can log in; invited admins are verified by the invite itself.
+func (s *Service) Register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+
+	if !usernamePattern.MatchString(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be 3-32 letters, digits, '.', '_' or '-'"})
+		return
+	}
+	if problem := checkPasswordPolicy(req.Password, req.Username, req.Email); problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+	if req.Role == "admin" && req.InviteToken == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin accounts can only be created from an invite"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Failed to hash password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Users().GetByUsername(c, req.Username); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to check username:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+		return
+	}
+	if _, err := tx.Users().GetByEmail(c, req.Email); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to check email:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+		return
+	}
+
+	now := time.Now().UTC()
+	user := models.User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: string(hash),
+		Role:         req.Role,
+	}
+
+	if req.Role == "admin" {
+		err := tx.Invites().Consume(c, hashToken(req.InviteToken), req.Email, now.Format(time.RFC3339))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invite is invalid, expired or for another email"})
+			return
+		} else if err != nil {
+			log.Println("Failed to redeem invite:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+			return
+		}
+		// The invite was mailed to this address, which proves it.
+		user.EmailVerifiedAt = now.Format(time.RFC3339)
+	}
+
+	user.ID, err = tx.Users().Create(c, user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// Lost a race with another registration for the same name or email
+		c.JSON(http.StatusConflict, gin.H{"error": "Username or email is already registered"})
+		return
+	} else if err != nil {
+		log.Println("Failed to insert user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+		return
+	}
+
+	var token string
+	if user.EmailVerifiedAt == "" {
+		token, err = issueToken(c, tx, user.ID, tokenPurposeEmailVerification, config.EmailVerificationTTL)
+		if err != nil {
+			log.Println("Failed to create verification token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit registration:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user"})
+		return
+	}
+
+	message := "Registration successful, you can now log in"
+	if token != "" {
+		s.Mail(utils.ComposeVerificationEmail(user.Email, user.Username, user.Role, token))
+		message = "Registration successful, check your email to verify your account"
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": message, "user": user})
+}
+
+// VerifyEmail redeems the token from the verification email.
+func (s *Service) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify email"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	userID, err := tx.Tokens().Consume(c, tokenPurposeEmailVerification, hashToken(token), now)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+		return
+	} else if err != nil {
+		log.Println("Failed to redeem verification token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify email"})
+		return
+	}
+	if err := tx.Users().MarkEmailVerified(c, userID, now); err != nil {
+		log.Println("Failed to mark email verified:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify email"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit email verification:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified, you can now log in"})
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResendVerification mails a fresh verification link. It answers the same
+// whether or not the email belongs to an unverified account.
+func (s *Service) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	response := gin.H{"message": "If the account exists and is not verified yet, a new link has been sent"}
+
+	user, err := s.Store.Users().GetByEmail(c, normalizeEmail(req.Email))
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && user.EmailVerifiedAt != "") {
+		c.JSON(http.StatusOK, response)
+		return
+	} else if err != nil {
+		log.Println("Failed to look up user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send verification email"})
+		return
+	}
+
+	token, err := issueToken(c, s.Store, user.ID, tokenPurposeEmailVerification, config.EmailVerificationTTL)
+	if err != nil {
+		log.Println("Failed to create verification token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send verification email"})
+		return
+	}
+	s.Mail(utils.ComposeVerificationEmail(user.Email, user.Username, user.Role, token))
+	c.JSON(http.StatusOK, response)
+}
+
+type InviteAdminRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// InviteAdmin mails an invite that lets the recipient register an admin
+// account with that email address.
+func (s *Service) InviteAdmin(c *gin.Context) {
+	var req InviteAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	email := normalizeEmail(req.Email)
+
+	if _, err := s.Store.Users().GetByEmail(c, email); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to check email:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create invite"})
+		return
+	}
+
+	inviter, err := s.Store.Users().GetByID(c, c.GetInt("userID"))
+	if err != nil {
+		log.Println("Failed to look up inviting admin:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create invite"})
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		log.Println("Failed to generate invite token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create invite"})
+		return
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(config.AdminInviteTTL).Format(time.RFC3339)
+	err = s.Store.Invites().Create(c, models.Invite{
+		Email:     email,
+		TokenHash: hashToken(token),
+		InvitedBy: inviter.ID,
+		ExpiresAt: expiresAt,
+		CreatedAt: now.Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Println("Failed to insert invite:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create invite"})
+		return
+	}
+
+	s.Mail(utils.ComposeAdminInviteEmail(email, inviter.Username, token))
+	c.JSON(http.StatusCreated, gin.H{"message": "Invite sent", "email": email, "expires_at": expiresAt})
+}
+
+// issueToken stores a new single-use token for the user and returns the
+// plain token to be mailed.
+func issueToken(ctx context.Context, repos repository.Repositories, userID int, purpose string, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	err = repos.Tokens().Create(ctx, models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl).Format(time.RFC3339),
+		CreatedAt: now.Format(time.RFC3339),
+	})
+	return token, err
+}
+
+// newToken returns 32 random bytes, hex encoded.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored for a mailed token, so a leaked database
+// does not hand out working links.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,138 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var mailedToken = regexp.MustCompile(`(?:token|invite_token)=([0-9a-f]+)`)
+
+func TestRegistration(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	store := repository.NewMemoryStore()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	store.Users().Create(context.Background(), models.User{
+		Username: "admin", Email: "admin@email.com", PasswordHash: string(hash), Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z",
+	})
+
+	regSvc := handlers.NewService(store)
+	var outbox []utils.EmailPreview
+	regSvc.Mail = func(e utils.EmailPreview) { outbox = append(outbox, e) }
+	lastToken := func() string {
+		m := mailedToken.FindStringSubmatch(outbox[len(outbox)-1].Body)
+		if m == nil {
+			t.Fatalf("No token in email: %s", outbox[len(outbox)-1].Body)
+		}
+		return m[1]
+	}
+
+	router := gin.New()
+	router.POST("/login", regSvc.Login)
+	router.POST("/register", regSvc.Register)
+	router.GET("/verify-email", regSvc.VerifyEmail)
+	router.POST("/resend-verification", regSvc.ResendVerification)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.POST("/admin/invites", middleware.RequirePermission("user:invite"), regSvc.InviteAdmin)
+
+	investor := map[string]string{"username": "new_investor", "email": "New.Investor@Email.com", "password": "investor123", "role": "investor"}
+	resp := doJSON(router, "POST", "/register", "", investor)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("Register failed: %s", resp.Body.String())
+	}
+	if len(outbox) != 1 || outbox[0].To != "new.investor@email.com" {
+		t.Fatalf("Expected a verification email to the normalised address, got %+v", outbox)
+	}
+	if !regexp.MustCompile(`invest in approved loans`).MatchString(outbox[0].Body) {
+		t.Errorf("Investor email should carry investor onboarding steps: %s", outbox[0].Body)
+	}
+	verifyToken := lastToken()
+
+	// Edge cases
+	cases := []struct {
+		name    string
+		payload map[string]string
+		code    int
+	}{
+		{"duplicate username", map[string]string{"username": "new_investor", "email": "other@email.com", "password": "investor123", "role": "investor"}, http.StatusConflict},
+		{"duplicate email", map[string]string{"username": "other", "email": "new.investor@email.com", "password": "investor123", "role": "requester"}, http.StatusConflict},
+		{"admin without invite", map[string]string{"username": "sneaky", "email": "sneaky@email.com", "password": "admin12345", "role": "admin"}, http.StatusForbidden},
+		{"admin with bad invite", map[string]string{"username": "sneaky", "email": "sneaky@email.com", "password": "admin12345", "role": "admin", "invite_token": "abc"}, http.StatusForbidden},
+		{"invalid username", map[string]string{"username": "no spaces", "email": "x@email.com", "password": "investor123", "role": "investor"}, http.StatusBadRequest},
+		{"short password", map[string]string{"username": "shorty", "email": "shorty@email.com", "password": "short", "role": "investor"}, http.StatusBadRequest},
+		{"unknown role", map[string]string{"username": "boss", "email": "boss@email.com", "password": "investor123", "role": "superuser"}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if resp := doJSON(router, "POST", "/register", "", tc.payload); resp.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.code, resp.Code, resp.Body.String())
+		}
+	}
+
+	// Cannot log in before verifying
+	creds := map[string]string{"username": "new_investor", "password": "investor123"}
+	if resp := doJSON(router, "POST", "/login", "", creds); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected unverified login to be refused, got %d", resp.Code)
+	}
+
+	// Resending issues a new link; either link verifies once
+	doJSON(router, "POST", "/resend-verification", "", map[string]string{"email": "new.investor@email.com"})
+	if len(outbox) != 2 {
+		t.Fatalf("Expected a second verification email, got %d emails", len(outbox))
+	}
+	if resp := doJSON(router, "GET", "/verify-email?token=nope", "", nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown token to be rejected, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "GET", "/verify-email?token="+verifyToken, "", nil); resp.Code != http.StatusOK {
+		t.Fatalf("VerifyEmail failed: %s", resp.Body.String())
+	}
+	if resp := doJSON(router, "GET", "/verify-email?token="+verifyToken, "", nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected verification token to be single-use, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "POST", "/login", "", creds); resp.Code != http.StatusOK {
+		t.Errorf("Expected verified login to succeed, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	// Admins register from an invite, only with the invited email
+	resp = doJSON(router, "POST", "/login", "", map[string]string{"username": "admin", "password": "secret"})
+	var loginResult map[string]string
+	json.Unmarshal(resp.Body.Bytes(), &loginResult)
+	adminToken := loginResult["token"]
+	if resp := doJSON(router, "POST", "/api/admin/invites", adminToken, map[string]string{"email": "new.investor@email.com"}); resp.Code != http.StatusConflict {
+		t.Errorf("Expected invite for a registered email to be refused, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "POST", "/api/admin/invites", adminToken, map[string]string{"email": "second.admin@email.com"}); resp.Code != http.StatusCreated {
+		t.Fatalf("InviteAdmin failed: %s", resp.Body.String())
+	}
+	invite := lastToken()
+
+	admin := map[string]string{"username": "second_admin", "email": "other.admin@email.com", "password": "admin12345", "role": "admin", "invite_token": invite}
+	if resp := doJSON(router, "POST", "/register", "", admin); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected invite to be bound to its email, got %d", resp.Code)
+	}
+	admin["email"] = "second.admin@email.com"
+	if resp := doJSON(router, "POST", "/register", "", admin); resp.Code != http.StatusCreated {
+		t.Fatalf("Register admin failed: %s", resp.Body.String())
+	}
+	if resp := doJSON(router, "POST", "/login", "", map[string]string{"username": "second_admin", "password": "admin12345"}); resp.Code != http.StatusOK {
+		t.Errorf("Invited admin should be able to log in right away, got %d", resp.Code)
+	}
+	admin["username"], admin["email"] = "third_admin", "second.admin@email.com"
+	if resp := doJSON(router, "POST", "/register", "", admin); resp.Code != http.StatusConflict {
+		t.Errorf("Expected a second registration from the same invite to be refused, got %d", resp.Code)
+	}
+}
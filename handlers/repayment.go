@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loan-service-engine/config"
+	"loan-service-engine/loanstate"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+	"loan-service-engine/repayment"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// canAccessLoan allows users with the anyLoan permission on every loan and users
+// with the ownLoan permission on the loans they requested.
+func canAccessLoan(c *gin.Context, requesterID int, anyLoan, ownLoan string) bool {
+	return middleware.HasPermission(c, anyLoan) ||
+		(middleware.HasPermission(c, ownLoan) && requesterID == c.GetInt("userID"))
+}
+
+func (s *Service) GetRepaymentSchedule(c *gin.Context) {
+	loanID, err := strconv.Atoi(c.Param("loan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+		return
+	}
+
+	loan, err := s.Store.Loans().Get(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !canAccessLoan(c, loan.RequesterID, "loan:read", "loan:read:own") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to view this loan's schedule"})
+		return
+	}
+
+	if loan.Status != string(loanstate.Disbursed) && loan.Status != string(loanstate.Repaid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repayment schedule is only available for disbursed loans"})
+		return
+	}
+
+	schedule := models.RepaymentSchedule{
+		LoanID:          loanID,
+		RepaymentMethod: loan.RepaymentMethod,
+		TenureMonths:    loan.TenureMonths,
+	}
+	schedule.Installments, err = s.Store.Repayments().Installments(c, loanID)
+	if err != nil {
+		log.Println("Failed to load installments:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve repayment schedule"})
+		return
+	}
+
+	for _, inst := range schedule.Installments {
+		schedule.OutstandingBalance += inst.Principal - inst.PrincipalPaid
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (s *Service) RecordRepayment(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	loanID, err := strconv.Atoi(c.Param("loan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+		return
+	}
+
+	var req models.RepaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	paidAt := time.Now()
+	if req.PaidAt != "" {
+		paidAt, err = time.Parse("2006-01-02", req.PaidAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "paid_at must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+	paidAtStr := paidAt.Format("2006-01-02")
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	loan, err := tx.Loans().GetForUpdate(c, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !canAccessLoan(c, loan.RequesterID, "repayment:record", "repayment:record:own") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to record repayments for this loan"})
+		return
+	}
+	if loan.Status != string(loanstate.Disbursed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repayments can only be recorded for disbursed loans"})
+		return
+	}
+
+	installments, err := tx.Repayments().Installments(c, loanID)
+	if err != nil {
+		log.Println("Failed to load installments:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load repayment schedule"})
+		return
+	}
+
+	// Charge the late fee on installments that are overdue at the time of payment
+	var outstanding money.Amount
+	for i := range installments {
+		inst := &installments[i]
+		if inst.Status != "paid" && inst.Fee == 0 && config.LateFeeAmount > 0 && inst.DueDate < paidAtStr {
+			inst.Fee = config.LateFeeAmount
+			inst.TotalDue += inst.Fee
+		}
+		outstanding += repayment.Remaining(*inst)
+	}
+
+	if req.Amount > outstanding {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       "Repayment exceeds the outstanding amount",
+			"outstanding": outstanding,
+		})
+		return
+	}
+
+	allocations, _ := repayment.Allocate(req.Amount, installments, config.RepaymentWaterfall)
+
+	for _, inst := range installments {
+		if err := tx.Repayments().UpdateInstallment(c, inst); err != nil {
+			log.Println("Failed to update installment:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record repayment"})
+			return
+		}
+	}
+
+	var feePortion, interestPortion, principalPortion money.Amount
+	for _, a := range allocations {
+		feePortion += a.Fee
+		interestPortion += a.Interest
+		principalPortion += a.Principal
+	}
+
+	holdings, err := loadHoldings(c, tx, loanID)
+	if err != nil {
+		log.Println("Failed to load investments:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record repayment"})
+		return
+	}
+	payouts, platformRevenue := repayment.DistributePayouts(principalPortion, interestPortion, feePortion, loan.Rate, loan.ROI, holdings)
+
+	repaymentID, err := tx.Repayments().Create(c, models.Repayment{
+		LoanID:           loanID,
+		Amount:           req.Amount,
+		FeePortion:       feePortion,
+		InterestPortion:  interestPortion,
+		PrincipalPortion: principalPortion,
+		PlatformRevenue:  platformRevenue,
+		PaidAt:           paidAtStr,
+		RecordedBy:       userID,
+	})
+	if err != nil {
+		log.Println("Failed to insert repayment:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record repayment"})
+		return
+	}
+
+	for _, p := range payouts {
+		err := tx.Payouts().Create(c, models.Payout{
+			RepaymentID: repaymentID,
+			LoanID:      loanID,
+			InvestorID:  p.InvestorID,
+			Principal:   p.Principal,
+			Interest:    p.Interest,
+			Amount:      p.Principal + p.Interest,
+			PaidAt:      paidAtStr,
+		})
+		if err != nil {
+			log.Println("Failed to insert payout:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to distribute investor payouts"})
+			return
+		}
+	}
+
+	remaining := outstanding - req.Amount
+	if remaining <= 0 {
+		_, err = loanstate.Default.Transition(c, tx, loanID, loanstate.Repaid, userID, "")
+		if err != nil {
+			log.Println("Failed to update loan status to 'repaid':", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan status"})
+			return
+		}
+		loan.Status = string(loanstate.Repaid)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit repayment:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record repayment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Repayment recorded",
+		"allocations":      allocations,
+		"outstanding":      remaining,
+		"loan_status":      loan.Status,
+		"investor_payouts": len(payouts),
+		"platform_revenue": platformRevenue,
+	})
+}
+
+// loadHoldings sums each investor's contributions to a loan.
+func loadHoldings(ctx context.Context, tx repository.Repositories, loanID int) ([]repayment.Holding, error) {
+	investments, err := tx.Investments().ListByLoan(ctx, loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	var holdings []repayment.Holding
+	index := map[int]int{}
+	for _, inv := range investments {
+		i, ok := index[inv.InvestorID]
+		if !ok {
+			i = len(holdings)
+			index[inv.InvestorID] = i
+			holdings = append(holdings, repayment.Holding{InvestorID: inv.InvestorID})
+		}
+		holdings[i].Amount += inv.Amount
+	}
+	return holdings, nil
+}
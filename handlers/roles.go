@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GrantRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ListRoles shows every role and the permissions it grants.
+func (s *Service) ListRoles(c *gin.Context) {
+	roles, err := s.Store.Roles().List(c)
+	if err != nil {
+		log.Println("Failed to list roles:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetUserRoles shows a user's roles and the permissions they add up to.
+func (s *Service) GetUserRoles(c *gin.Context) {
+	userID, ok := s.userParam(c)
+	if !ok {
+		return
+	}
+	roles, err := s.Store.Roles().UserRoles(c, userID)
+	if err != nil {
+		log.Println("Failed to load roles:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	permissions, err := s.Store.Roles().UserPermissions(c, userID)
+	if err != nil {
+		log.Println("Failed to load permissions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "roles": roles, "permissions": permissions})
+}
+
+// GrantRole gives a user another role. It applies to the user's next request.
+func (s *Service) GrantRole(c *gin.Context) {
+	userID, ok := s.userParam(c)
+	if !ok {
+		return
+	}
+	var req GrantRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	exists, err := s.Store.Roles().Exists(c, req.Role)
+	if err != nil {
+		log.Println("Failed to look up role:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not grant role"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	adminID := c.GetInt("userID")
+	err = s.Store.Roles().Grant(c, userID, req.Role, adminID, time.Now().UTC().Format(time.RFC3339))
+	if errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already has this role"})
+		return
+	} else if err != nil {
+		log.Println("Failed to grant role:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not grant role"})
+		return
+	}
+
+	log.Printf("Admin %d granted role %s to user %d", adminID, req.Role, userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Role granted"})
+}
+
+// RevokeRole takes a role away from a user. The last admin cannot lose the
+// admin role, so someone is always able to manage roles.
+func (s *Service) RevokeRole(c *gin.Context) {
+	userID, ok := s.userParam(c)
+	if !ok {
+		return
+	}
+	role := c.Param("role")
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke role"})
+		return
+	}
+	defer tx.Rollback()
+
+	// The admins are counted, and their rows locked, before revoking, so two
+	// admins revoking each other at once cannot both succeed
+	admins := 0
+	if role == "admin" {
+		admins, err = tx.Roles().CountUsersForUpdate(c, role)
+		if err != nil {
+			log.Println("Failed to count admins:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke role"})
+			return
+		}
+	}
+
+	err = tx.Roles().Revoke(c, userID, role)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User does not have this role"})
+		return
+	} else if err != nil {
+		log.Println("Failed to revoke role:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke role"})
+		return
+	}
+	if role == "admin" && admins <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot revoke the role of the last admin"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit role revocation:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke role"})
+		return
+	}
+
+	log.Printf("Admin %d revoked role %s from user %d", c.GetInt("userID"), role, userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked"})
+}
+
+// userParam reads the :user_id of an admin user route and checks the user
+// exists, answering the request itself when not.
+func (s *Service) userParam(c *gin.Context) (int, bool) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	if _, err := s.Store.Users().GetByID(c, userID); errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return 0, false
+	} else if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, false
+	}
+	return userID, true
+}
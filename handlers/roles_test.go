@@ -0,0 +1,196 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/db"
+	"loan-service-engine/handlers"
+	"loan-service-engine/loanstate"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRoleManagement(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	ids := map[string]int{}
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@email.com", Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+		{Username: "investor1", Email: "investor1@email.com", Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+	} {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		u.PasswordHash = string(hash)
+		id, err := store.Users().Create(ctx, u)
+		if err != nil {
+			t.Fatalf("Failed to create user %s: %v", u.Username, err)
+		}
+		ids[u.Username] = id
+	}
+	roleSvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.POST("/login", roleSvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.POST("/requester/create-loan", middleware.RequirePermission("loan:create"), roleSvc.CreateLoan)
+	api.POST("/investor/invest", middleware.RequirePermission("investment:create"), roleSvc.InvestInLoan)
+	api.GET("/admin/roles", middleware.RequirePermission("role:manage"), roleSvc.ListRoles)
+	api.GET("/admin/users/:user_id/roles", middleware.RequirePermission("role:manage"), roleSvc.GetUserRoles)
+	api.POST("/admin/users/:user_id/roles", middleware.RequirePermission("role:manage"), roleSvc.GrantRole)
+	api.DELETE("/admin/users/:user_id/roles/:role", middleware.RequirePermission("role:manage"), roleSvc.RevokeRole)
+
+	token := func(username string) string {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": username, "password": "secret"})
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Login as %s failed: %s", username, resp.Body.String())
+		}
+		var result map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result["token"]
+	}
+	admin, investor := token("admin"), token("investor1")
+	userRoles := "/api/admin/users/" + strconv.Itoa(ids["investor1"]) + "/roles"
+	createLoan := func() int {
+		return doJSON(router, "POST", "/api/requester/create-loan", investor, map[string]interface{}{
+			"borrower_id_number": "1122334455667788",
+			"amount":             1000000,
+			"rate":               12,
+			"roi":                10,
+			"tenure_months":      6,
+		}).Code
+	}
+
+	resp := doJSON(router, "GET", "/api/admin/roles", admin, nil)
+	var listed struct {
+		Roles []models.Role `json:"roles"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &listed)
+	if resp.Code != http.StatusOK || len(listed.Roles) != 3 {
+		t.Errorf("Expected 3 roles, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if resp := doJSON(router, "GET", "/api/admin/roles", investor, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected investor to be refused role management, got %d", resp.Code)
+	}
+
+	// A granted role applies to tokens issued before the grant
+	if code := createLoan(); code != http.StatusForbidden {
+		t.Errorf("Expected investor without requester role to be refused, got %d", code)
+	}
+	if resp := doJSON(router, "POST", userRoles, admin, map[string]string{"role": "requester"}); resp.Code != http.StatusOK {
+		t.Fatalf("Grant failed: %s", resp.Body.String())
+	}
+	if resp := doJSON(router, "POST", userRoles, admin, map[string]string{"role": "requester"}); resp.Code != http.StatusConflict {
+		t.Errorf("Expected granting a held role to conflict, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "POST", userRoles, admin, map[string]string{"role": "auditor"}); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown role to be refused, got %d", resp.Code)
+	}
+	if code := createLoan(); code != http.StatusCreated {
+		t.Fatalf("Expected investor with requester role to create a loan, got %d", code)
+	}
+	resp = doJSON(router, "GET", userRoles, admin, nil)
+	var held struct {
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &held)
+	if len(held.Roles) != 2 || len(held.Permissions) != 7 {
+		t.Errorf("Expected 2 roles with 7 permissions, got %s", resp.Body.String())
+	}
+
+	// Holding both roles does not allow funding one's own loan
+	tx, _ := store.Begin(ctx)
+	tx.Approvals().Create(ctx, 1, models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-01-01", ProofURL: "/proof.jpg"})
+	if _, err := loanstate.Default.Transition(ctx, tx, 1, loanstate.Approved, ids["admin"], ""); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	tx.Commit()
+	if resp := doJSON(router, "POST", "/api/investor/invest", investor, map[string]interface{}{"loan_id": 1, "amount": 500000}); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected investing in one's own loan to be refused, got %d", resp.Code)
+	}
+
+	if resp := doJSON(router, "DELETE", userRoles+"/requester", admin, nil); resp.Code != http.StatusOK {
+		t.Errorf("Revoke failed: %s", resp.Body.String())
+	}
+	if resp := doJSON(router, "DELETE", userRoles+"/requester", admin, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking a role not held to be 404, got %d", resp.Code)
+	}
+	if code := createLoan(); code != http.StatusForbidden {
+		t.Errorf("Expected revoked role to apply immediately, got %d", code)
+	}
+
+	// The last admin keeps the admin role
+	adminRoles := "/api/admin/users/" + strconv.Itoa(ids["admin"]) + "/roles"
+	if resp := doJSON(router, "DELETE", adminRoles+"/admin", admin, nil); resp.Code != http.StatusConflict {
+		t.Errorf("Expected revoking the last admin to conflict, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "GET", "/api/admin/users/999/roles", admin, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown user to be 404, got %d", resp.Code)
+	}
+}
+
+// Two admins revoking each other's admin role at the same time must not
+// leave the service without an admin.
+func TestConcurrentAdminRevocationsKeepAnAdmin(t *testing.T) {
+	setupTestEnv(t)
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(svc.Store))
+	api.DELETE("/admin/users/:user_id/roles/:role", middleware.RequirePermission("role:manage"), svc.RevokeRole)
+
+	var adminID, secondID int
+	db.DB.QueryRow(`SELECT id FROM users WHERE username = 'admin'`).Scan(&adminID)
+	db.DB.QueryRow(`SELECT id FROM users WHERE username = 'investor1'`).Scan(&secondID)
+	if _, err := db.DB.Exec(`INSERT INTO user_roles (user_id, role, granted_at) VALUES (?, 'admin', '2025-01-01T00:00:00Z')`, secondID); err != nil {
+		t.Fatalf("Failed to make investor1 an admin: %v", err)
+	}
+	tokens := map[int]string{
+		adminID:  login(t, "admin", "admin123"),
+		secondID: login(t, "investor1", "investor123"),
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		codes []int
+	)
+	for revoker, target := range map[int]int{adminID: secondID, secondID: adminID} {
+		wg.Add(1)
+		go func(revoker, target int) {
+			defer wg.Done()
+			resp := doJSON(router, "DELETE", "/api/admin/users/"+strconv.Itoa(target)+"/roles/admin", tokens[revoker], nil)
+			mu.Lock()
+			codes = append(codes, resp.Code)
+			mu.Unlock()
+		}(revoker, target)
+	}
+	wg.Wait()
+
+	// The loser either finds itself the last admin or has already lost the
+	// role by the time its request is authorised
+	sort.Ints(codes)
+	if codes[0] != http.StatusOK || (codes[1] != http.StatusForbidden && codes[1] != http.StatusConflict) {
+		t.Errorf("Expected exactly one revocation to succeed, got %v", codes)
+	}
+	var admins int
+	db.DB.QueryRow(`SELECT COUNT(*) FROM user_roles WHERE role = 'admin'`).Scan(&admins)
+	if admins != 1 {
+		t.Errorf("Expected 1 admin left, got %d", admins)
+	}
+}
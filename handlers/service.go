@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"loan-service-engine/config"
+	"loan-service-engine/mailer"
+	"loan-service-engine/repository"
+	"loan-service-engine/scanner"
+	"loan-service-engine/storage"
+	"loan-service-engine/utils"
+)
+
+// Service holds what the HTTP handlers depend on. The handlers are its
+// methods, so they can run against the SQL store in production and an
+// in-memory store in tests.
+type Service struct {
+	Store repository.Store
+	// Files keeps proofs, signed agreements and generated PDFs.
+	Files storage.Storage
+	// Scanner checks uploads for malware before they are accepted.
+	Scanner scanner.Scanner
+
+	// Mail sends an email through config.Mailer. Emails that carry secrets,
+	// such as verification links, reach the user only this way: they are
+	// never echoed in API responses, and the composers in utils log only
+	// their recipient and subject. Tests replace Mail to read them. The
+	// funding expiry job sends its notices through it as well.
+	Mail func(utils.EmailPreview)
+}
+
+func NewService(store repository.Store) *Service {
+	return &Service{Store: store, Files: config.Files, Scanner: config.Scanner, Mail: sendWith(config.Mailer)}
+}
+
+// sendWith adapts a sender to the Mail hook. Emails go out once the
+// request's work is done, so a failed send is logged rather than failing
+// the request; a new link can be asked for again.
+func sendWith(sender mailer.Sender) func(utils.EmailPreview) {
+	return func(email utils.EmailPreview) {
+		if err := sender.Send(context.Background(), email); err != nil {
+			log.Printf("Failed to send %q to %s: %v", email.Subject, email.To, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"loan-service-engine/config"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenResponse is returned by login and refresh. Token is the access token
+// for the Authorization header; RefreshToken is exchanged at /refresh for a
+// new pair once the access token expires.
+type TokenResponse struct {
+	Token                 string `json:"token"`
+	TokenType             string `json:"token_type"`
+	ExpiresAt             string `json:"expires_at"`
+	RefreshToken          string `json:"refresh_token"`
+	RefreshTokenExpiresAt string `json:"refresh_token_expires_at"`
+}
+
+// startSession records a new login. twoFactorVerifiedAt is set when the
+// login passed the second factor.
+func startSession(ctx context.Context, repos repository.Repositories, userID int, twoFactorVerifiedAt string) (models.Session, error) {
+	id, err := newToken()
+	if err != nil {
+		return models.Session{}, err
+	}
+	session := models.Session{
+		ID:                  id,
+		UserID:              userID,
+		CreatedAt:           time.Now().UTC().Format(time.RFC3339),
+		TwoFactorVerifiedAt: twoFactorVerifiedAt,
+	}
+	return session, repos.Sessions().Create(ctx, session)
+}
+
+// issueTokens signs a short-lived access token for the session and stores a
+// new refresh token for it.
+func issueTokens(ctx context.Context, repos repository.Repositories, user models.User, session models.Session) (TokenResponse, error) {
+	now := time.Now().UTC()
+
+	// amr tells services verifying the token how the user authenticated
+	amr := []string{"pwd"}
+	if session.TwoFactorVerifiedAt != "" {
+		amr = append(amr, "otp")
+	}
+
+	// roles is informational; the API itself checks the user's current
+	// roles on every request
+	roles, err := repos.Roles().UserRoles(ctx, user.ID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	jti, err := newToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	expiresAt := now.Add(config.AccessTokenTTL)
+	access, err := config.JWTKeys.Sign(jwt.MapClaims{
+		"sub":   user.ID,
+		"roles": roles,
+		"sid":   session.ID,
+		"amr":   amr,
+		"jti":   jti,
+		"iat":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refresh, err := newToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	refreshExpiresAt := now.Add(config.RefreshTokenTTL)
+	err = repos.Sessions().AddRefreshToken(ctx, models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hashToken(refresh),
+		ExpiresAt: refreshExpiresAt.Format(time.RFC3339),
+		CreatedAt: now.Format(time.RFC3339),
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		Token:                 access,
+		TokenType:             "Bearer",
+		ExpiresAt:             expiresAt.Format(time.RFC3339),
+		RefreshToken:          refresh,
+		RefreshTokenExpiresAt: refreshExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token. Each
+// refresh token works once; presenting a used one means it was copied, so
+// the whole session is revoked.
+func (s *Service) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	hash := hashToken(req.RefreshToken)
+	refresh, err := tx.Sessions().GetRefreshToken(c, hash)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load refresh token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+
+	session, err := tx.Sessions().Get(c, refresh.SessionID)
+	if err != nil {
+		log.Println("Failed to load session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+	if session.RevokedAt != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been logged out"})
+		return
+	}
+
+	if refresh.ExpiresAt <= now {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has expired"})
+		return
+	}
+
+	used, err := tx.Sessions().UseRefreshToken(c, hash, now)
+	if err != nil {
+		log.Println("Failed to use refresh token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+	if !used {
+		err := tx.Sessions().Revoke(c, session.ID, now)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Println("Failed to revoke session after refresh token reuse:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+			return
+		}
+		log.Printf("Refresh token reused for session of user %d, session revoked", session.UserID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used, session has been logged out"})
+		return
+	}
+
+	user, err := tx.Users().GetByID(c, session.UserID)
+	if err != nil {
+		log.Println("Failed to load session user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+	tokens, err := issueTokens(c, tx, user, session)
+	if err != nil {
+		log.Println("Failed to issue tokens:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit token refresh:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token refresh failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the access token it is called with and ends its session,
+// so the session's refresh token stops working too.
+func (s *Service) Logout(c *gin.Context) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	expiresAt := c.GetTime("tokenExpiresAt").UTC().Format(time.RFC3339)
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Logout failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.Revocations().Revoke(c, c.GetString("jti"), expiresAt, now); err != nil {
+		log.Println("Failed to revoke access token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Logout failed"})
+		return
+	}
+	if err := tx.Sessions().Revoke(c, c.GetString("sessionID"), now); err != nil {
+		log.Println("Failed to revoke session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Logout failed"})
+		return
+	}
+	// Housekeeping: entries for expired tokens are no longer needed
+	if err := tx.Revocations().PurgeExpired(c, now); err != nil {
+		log.Println("Failed to purge revoked tokens:", err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit logout:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Logout failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutAll ends every session of the calling user, on all devices.
+func (s *Service) LogoutAll(c *gin.Context) {
+	s.revokeSessions(c, c.GetInt("userID"))
+}
+
+// RevokeUserSessions lets an admin end every session of a user, for example
+// after a password leak.
+func (s *Service) RevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if _, err := s.Store.Users().GetByID(c, userID); errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke sessions"})
+		return
+	}
+	s.revokeSessions(c, userID)
+}
+
+func (s *Service) revokeSessions(c *gin.Context, userID int) {
+	n, err := s.Store.Sessions().RevokeAllForUser(c, userID, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions logged out", "sessions_revoked": n})
+}
+
+// JWKS publishes the public keys access tokens are signed with, so other
+// services can verify them. Retired keys drop out once removed from
+// JWT_SIGNING_KEYS, so verifiers should not cache the set for long.
+func (s *Service) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, config.JWTKeys.JWKS())
+}
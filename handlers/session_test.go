@@ -0,0 +1,217 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/jwtkeys"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSessions(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	var investorID int
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@email.com", Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+		{Username: "investor1", Email: "investor1@email.com", Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+	} {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		u.PasswordHash = string(hash)
+		id, err := store.Users().Create(ctx, u)
+		if err != nil {
+			t.Fatalf("Failed to create user %s: %v", u.Username, err)
+		}
+		if u.Role == "investor" {
+			investorID = id
+		}
+	}
+	sessSvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.POST("/login", sessSvc.Login)
+	router.POST("/refresh", sessSvc.Refresh)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.GET("/loans/:id", sessSvc.GetLoanDetails)
+	api.POST("/logout", sessSvc.Logout)
+	api.POST("/logout-all", sessSvc.LogoutAll)
+	api.POST("/admin/users/:user_id/logout-all", middleware.RequirePermission("user:manage"), sessSvc.RevokeUserSessions)
+
+	tokens := func(body *bytes.Buffer) map[string]string {
+		var result map[string]string
+		json.Unmarshal(body.Bytes(), &result)
+		return result
+	}
+	login := func(username string) map[string]string {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": username, "password": "secret"})
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Login as %s failed: %s", username, resp.Body.String())
+		}
+		result := tokens(resp.Body)
+		if result["token"] == "" || result["refresh_token"] == "" {
+			t.Fatalf("Expected an access and a refresh token, got %s", resp.Body.String())
+		}
+		return result
+	}
+	refresh := func(token string) int {
+		return doJSON(router, "POST", "/refresh", "", map[string]string{"refresh_token": token}).Code
+	}
+	canUse := func(token string) bool {
+		// No loans exist, so an accepted token gets a 404
+		return doJSON(router, "GET", "/api/loans/1", token, nil).Code != http.StatusUnauthorized
+	}
+
+	// Refreshing rotates both tokens; the old refresh token is spent
+	first := login("investor1")
+	resp := doJSON(router, "POST", "/refresh", "", map[string]string{"refresh_token": first["refresh_token"]})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Refresh failed: %s", resp.Body.String())
+	}
+	rotated := tokens(resp.Body)
+	if rotated["refresh_token"] == first["refresh_token"] || rotated["token"] == first["token"] {
+		t.Errorf("Expected refresh to issue a new token pair")
+	}
+	if !canUse(rotated["token"]) {
+		t.Errorf("Expected the refreshed access token to work")
+	}
+	if code := refresh("unknown"); code != http.StatusUnauthorized {
+		t.Errorf("Expected unknown refresh token to be rejected, got %d", code)
+	}
+
+	// Reusing a spent refresh token logs the whole session out
+	if code := refresh(first["refresh_token"]); code != http.StatusUnauthorized {
+		t.Errorf("Expected reused refresh token to be rejected, got %d", code)
+	}
+	if canUse(rotated["token"]) {
+		t.Errorf("Expected the session's access token to be revoked after refresh token reuse")
+	}
+	if code := refresh(rotated["refresh_token"]); code != http.StatusUnauthorized {
+		t.Errorf("Expected the session's latest refresh token to be revoked, got %d", code)
+	}
+
+	// Logout revokes the access token and its session's refresh token
+	second := login("investor1")
+	if resp := doJSON(router, "POST", "/api/logout", second["token"], nil); resp.Code != http.StatusOK {
+		t.Fatalf("Logout failed: %s", resp.Body.String())
+	}
+	if canUse(second["token"]) {
+		t.Errorf("Expected the access token to be rejected after logout")
+	}
+	if code := refresh(second["refresh_token"]); code != http.StatusUnauthorized {
+		t.Errorf("Expected the refresh token to be rejected after logout, got %d", code)
+	}
+
+	// Logging out everywhere ends every session of the user
+	phone, laptop := login("investor1"), login("investor1")
+	resp = doJSON(router, "POST", "/api/logout-all", phone["token"], nil)
+	var result map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	if resp.Code != http.StatusOK || result["sessions_revoked"] != float64(2) {
+		t.Fatalf("Expected logout-all to revoke 2 sessions, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if canUse(phone["token"]) || canUse(laptop["token"]) {
+		t.Errorf("Expected every access token to be rejected after logout-all")
+	}
+
+	// Admins can end another user's sessions
+	tablet := login("investor1")
+	admin := login("admin")
+	if resp := doJSON(router, "POST", "/api/admin/users/999/logout-all", admin["token"], nil); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown user to be rejected, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "POST", "/api/admin/users/"+strconv.Itoa(investorID)+"/logout-all", admin["token"], nil); resp.Code != http.StatusOK {
+		t.Fatalf("Admin logout-all failed: %s", resp.Body.String())
+	}
+	if canUse(tablet["token"]) {
+		t.Errorf("Expected the user's access token to be rejected after admin logout-all")
+	}
+	if !canUse(login("investor1")["token"]) {
+		t.Errorf("Expected the user to be able to log in again")
+	}
+
+	// A signed token whose subject is not a user ID is refused
+	parsed, err := config.JWTKeys.Parse(login("investor1")["token"])
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	claims := parsed.Claims.(jwt.MapClaims)
+	if _, ok := claims["role"]; ok {
+		t.Errorf("Expected no legacy role claim, got %v", claims["role"])
+	}
+	claims["sub"] = "investor1"
+	odd, _ := config.JWTKeys.Sign(claims)
+	if resp := doJSON(router, "POST", "/api/logout", odd, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a token without a numeric subject to be 401, got %d", resp.Code)
+	}
+}
+
+// With an asymmetric key configured, tokens carry its kid and the key is
+// published for other services.
+func TestTokensSignedWithKeySet(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	pemBytes, _ := jwtkeys.Generate("EdDSA")
+	path := filepath.Join(t.TempDir(), "ed.pem")
+	os.WriteFile(path, pemBytes, 0600)
+	keys, err := jwtkeys.Load("ed-1="+path, "", "", time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+	previous := config.JWTKeys
+	config.JWTKeys = keys
+	defer func() { config.JWTKeys = previous }()
+
+	store := repository.NewMemoryStore()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	store.Users().Create(context.Background(), models.User{
+		Username: "investor1", Email: "investor1@email.com", PasswordHash: string(hash), Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z",
+	})
+	keySvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.GET("/.well-known/jwks.json", keySvc.JWKS)
+	router.POST("/login", keySvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.POST("/logout", keySvc.Logout)
+
+	resp := doJSON(router, "POST", "/login", "", map[string]string{"username": "investor1", "password": "secret"})
+	var result map[string]string
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	header, _ := base64.RawURLEncoding.DecodeString(strings.Split(result["token"], ".")[0])
+	if !strings.Contains(string(header), `"kid":"ed-1"`) || !strings.Contains(string(header), `"alg":"EdDSA"`) {
+		t.Errorf("Expected an EdDSA token naming key ed-1, got header %s", header)
+	}
+	if resp := doJSON(router, "POST", "/api/logout", result["token"], nil); resp.Code != http.StatusOK {
+		t.Errorf("Expected the EdDSA token to be accepted, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	resp = doJSON(router, "GET", "/.well-known/jwks.json", "", nil)
+	var jwks jwtkeys.JWKS
+	json.Unmarshal(resp.Body.Bytes(), &jwks)
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "ed-1" || jwks.Keys[0].X == "" {
+		t.Errorf("Expected key ed-1 in the JWKS, got %s", resp.Body.String())
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"loan-service-engine/loanstate"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transitionLoan applies a single status change in its own transaction.
+func (s *Service) transitionLoan(ctx context.Context, loanID int, to loanstate.State, actorID int, reason string) error {
+	tx, err := s.Store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := loanstate.Default.Transition(ctx, tx, loanID, to, actorID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Service) GetLoanStatusHistory(c *gin.Context) {
+	loanID, err := strconv.Atoi(c.Param("loan_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid loan ID"})
+		return
+	}
+
+	history, err := s.Store.Loans().StatusHistory(c, loanID)
+	if err != nil {
+		log.Println("Failed to load status history:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve status history"})
+		return
+	}
+
+	if len(history) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"loan_id": loanID, "history": history})
+}
+
+// respondTransitionError maps loanstate errors to HTTP responses.
+func respondTransitionError(c *gin.Context, err error) {
+	var invalid *loanstate.InvalidTransitionError
+	var blocked *loanstate.GuardError
+
+	switch {
+	case errors.Is(err, loanstate.ErrLoanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+	case errors.As(err, &blocked):
+		c.JSON(http.StatusBadRequest, gin.H{"error": blocked.Error()})
+	case errors.Is(err, loanstate.ErrConcurrentUpdate):
+		c.JSON(http.StatusConflict, gin.H{"error": "Loan was updated by another request, please retry"})
+	default:
+		log.Println("Loan status transition failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loan status"})
+	}
+}
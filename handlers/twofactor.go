@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"loan-service-engine/config"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// twoFactorAudience marks the short-lived token handed out between the
+	// password and the code step. It has no session, so it is never
+	// accepted as an access token.
+	twoFactorAudience     = "login-2fa"
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+// totpOptions are the parameters every common authenticator app uses.
+var totpOptions = totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+var totpCodePattern = regexp.MustCompile(`^[0-9]{6}$`)
+
+// TwoFactorStatus reports whether the caller has two-factor authentication
+// enabled and whether their role requires it.
+func (s *Service) TwoFactorStatus(c *gin.Context) {
+	userID := c.GetInt("userID")
+	credential, err := s.Store.TwoFactor().GetTOTP(c, userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to load two-factor settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+		return
+	}
+	remaining, err := s.Store.TwoFactor().CountRecoveryCodes(c, userID)
+	if err != nil {
+		log.Println("Failed to count recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  credential.ConfirmedAt != "",
+		"required":                 config.TwoFactorRequired(c.GetStringSlice("roles")...),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollTwoFactor creates a TOTP secret for the caller and returns it as
+// text, as an otpauth:// URI and as a QR code PNG for authenticator apps.
+// It takes effect once confirmed with a code; enrolling again before that
+// replaces the secret.
+func (s *Service) EnrollTwoFactor(c *gin.Context) {
+	user, err := s.Store.Users().GetByID(c, c.GetInt("userID"))
+	if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrollment"})
+		return
+	}
+	if credential, err := s.Store.TwoFactor().GetTOTP(c, user.ID); err == nil && credential.ConfirmedAt != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	} else if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to load two-factor settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrollment"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.TOTPIssuer,
+		AccountName: user.Username,
+		Period:      uint(totpOptions.Period),
+		Digits:      totpOptions.Digits,
+		Algorithm:   totpOptions.Algorithm,
+	})
+	if err != nil {
+		log.Println("Failed to generate TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrollment"})
+		return
+	}
+	var qr bytes.Buffer
+	img, err := key.Image(256, 256)
+	if err == nil {
+		err = png.Encode(&qr, img)
+	}
+	if err != nil {
+		log.Println("Failed to render QR code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrollment"})
+		return
+	}
+
+	err = s.Store.TwoFactor().SaveTOTP(c, models.TOTPCredential{
+		UserID:    user.ID,
+		Secret:    key.Secret(),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Println("Failed to save TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Scan the QR code with an authenticator app, then confirm with a code from it",
+		"secret":      key.Secret(),
+		"otpauth_uri": key.URL(),
+		"qr_code_png": base64.StdEncoding.EncodeToString(qr.Bytes()),
+	})
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ConfirmTwoFactor turns two-factor authentication on with the first code
+// from the authenticator app and returns the recovery codes. They are shown
+// only this once.
+func (s *Service) ConfirmTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID := c.GetInt("userID")
+	credential, err := tx.TwoFactor().GetTOTP(c, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load two-factor settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		return
+	}
+	if credential.ConfirmedAt != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	ok, err := checkTOTP(c, tx, credential, req.Code)
+	if err != nil {
+		log.Println("Failed to check TOTP code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := tx.TwoFactor().ConfirmTOTP(c, userID, now); err != nil {
+		log.Println("Failed to confirm TOTP:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(c, tx, userID)
+	if err != nil {
+		log.Println("Failed to create recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit two-factor enrollment:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled. Keep the recovery codes somewhere safe and log in again",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes. It takes a
+// code from the authenticator app, not a recovery code.
+func (s *Service) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID := c.GetInt("userID")
+	credential, err := tx.TwoFactor().GetTOTP(c, userID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && credential.ConfirmedAt == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load two-factor settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+
+	ok, err := checkTOTP(c, tx, credential, req.Code)
+	if err != nil {
+		log.Println("Failed to check TOTP code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(c, tx, userID)
+	if err != nil {
+		log.Println("Failed to create recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "New recovery codes created, the old ones no longer work", "recovery_codes": codes})
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactor turns two-factor authentication off for users whose role
+// does not require it. It takes the password and a code or recovery code.
+func (s *Service) DisableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	if config.TwoFactorRequired(c.GetStringSlice("roles")...) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role"})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := tx.Users().GetByID(c, c.GetInt("userID"))
+	if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+		return
+	}
+	credential, err := tx.TwoFactor().GetTOTP(c, user.ID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && credential.ConfirmedAt == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load two-factor settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		return
+	}
+	ok, err := checkSecondFactor(c, tx, credential, req.Code)
+	if err != nil {
+		log.Println("Failed to check second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+		return
+	}
+
+	if err := tx.TwoFactor().DeleteTOTP(c, user.ID); err != nil {
+		log.Println("Failed to delete TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit disabling two-factor authentication:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// ResetTwoFactor lets an admin remove the two-factor setup of a user who
+// lost both the authenticator and the recovery codes. The user's sessions
+// are ended; they log in with the password and enroll again.
+func (s *Service) ResetTwoFactor(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset two-factor authentication"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Users().GetByID(c, userID); errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset two-factor authentication"})
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := tx.TwoFactor().DeleteTOTP(c, userID); err != nil {
+		log.Println("Failed to delete TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset two-factor authentication"})
+		return
+	}
+	if _, err := tx.Sessions().RevokeAllForUser(c, userID, now); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset two-factor authentication"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit two-factor reset:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset two-factor authentication"})
+		return
+	}
+
+	log.Printf("Admin %d reset two-factor authentication of user %d", c.GetInt("userID"), userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset and sessions logged out"})
+}
+
+// startTwoFactorLogin answers a correct password of a user with two-factor
+// authentication with a short-lived token for the code step instead of
+// access tokens.
+func (s *Service) startTwoFactorLogin(c *gin.Context, user models.User) {
+	jti, err := newToken()
+	if err != nil {
+		log.Println("Failed to generate token ID:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(twoFactorChallengeTTL)
+	token, err := config.JWTKeys.Sign(jwt.MapClaims{
+		"sub": user.ID,
+		"aud": twoFactorAudience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": expiresAt.Unix(),
+	})
+	if err != nil {
+		log.Println("Failed to sign two-factor token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"two_factor_required": true,
+		"two_factor_token":    token,
+		"expires_at":          expiresAt.Format(time.RFC3339),
+	})
+}
+
+type TwoFactorLoginRequest struct {
+	TwoFactorToken string `json:"two_factor_token" binding:"required"`
+	// Code is a code from the authenticator app or a recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+// VerifyTwoFactorLogin is the second login step: it exchanges the token
+// from Login and a code for access tokens. The token allows a single
+// attempt, so a wrong code means logging in with the password again.
+func (s *Service) VerifyTwoFactorLogin(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	token, err := config.JWTKeys.Parse(req.TwoFactorToken)
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor login has expired, log in again"})
+		return
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	audience, _ := claims.GetAudience()
+	sub, _ := claims["sub"].(float64)
+	jti, _ := claims["jti"].(string)
+	expiresAt, _ := claims.GetExpirationTime()
+	if len(audience) != 1 || audience[0] != twoFactorAudience || sub == 0 || jti == "" || expiresAt == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+	userID := int(sub)
+
+	tx, err := s.Store.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if revoked, err := tx.Revocations().IsRevoked(c, jti); err != nil {
+		log.Println("Failed to check token revocation:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	} else if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor login has expired, log in again"})
+		return
+	}
+	if err := tx.Revocations().Revoke(c, jti, expiresAt.UTC().Format(time.RFC3339), now); err != nil {
+		log.Println("Failed to use two-factor token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	user, err := tx.Users().GetByID(c, userID)
+	if err != nil {
+		log.Println("Failed to load user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	credential, err := tx.TwoFactor().GetTOTP(c, userID)
+	ok := false
+	if err == nil && credential.ConfirmedAt != "" {
+		ok, err = checkSecondFactor(c, tx, credential, req.Code)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to check second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	if !ok {
+		// Keep the token used up
+		if err := tx.Commit(); err != nil {
+			log.Println("Failed to commit two-factor attempt:", err)
+		}
+		log.Printf("Failed two-factor login for user %d", userID)
+		s.recordLoginFailure(c, user.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code, log in again"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit two-factor login:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+
+	s.logIn(c, user, now)
+}
+
+// checkSecondFactor accepts a code from the authenticator app or one of the
+// user's unused recovery codes.
+func checkSecondFactor(ctx context.Context, repos repository.Repositories, credential models.TOTPCredential, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if totpCodePattern.MatchString(code) {
+		return checkTOTP(ctx, repos, credential, code)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	return repos.TwoFactor().UseRecoveryCode(ctx, credential.UserID, hashToken(normalizeRecoveryCode(code)), now)
+}
+
+// checkTOTP accepts the code for the current 30 second step or the one on
+// either side of it, to allow for clock drift. Each step works only once.
+func checkTOTP(ctx context.Context, repos repository.Repositories, credential models.TOTPCredential, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	current := time.Now().Unix() / int64(totpOptions.Period)
+	for step := current - 1; step <= current+1; step++ {
+		expected, err := totp.GenerateCodeCustom(credential.Secret, time.Unix(step*int64(totpOptions.Period), 0), totpOptions)
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return repos.TwoFactor().UseTOTPStep(ctx, credential.UserID, step)
+		}
+	}
+	return false, nil
+}
+
+// replaceRecoveryCodes stores a fresh set of recovery codes for the user
+// and returns them in the form shown to the user.
+func replaceRecoveryCodes(ctx context.Context, repos repository.Repositories, userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+	err := repos.TwoFactor().ReplaceRecoveryCodes(ctx, userID, hashes, time.Now().UTC().Format(time.RFC3339))
+	return codes, err
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
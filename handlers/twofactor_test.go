@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTwoFactor(t *testing.T) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	var investorID int
+	for _, u := range []models.User{
+		{Username: "admin", Email: "admin@email.com", Role: "admin", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+		{Username: "investor1", Email: "investor1@email.com", Role: "investor", EmailVerifiedAt: "2025-01-01T00:00:00Z"},
+	} {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		u.PasswordHash = string(hash)
+		id, err := store.Users().Create(ctx, u)
+		if err != nil {
+			t.Fatalf("Failed to create user %s: %v", u.Username, err)
+		}
+		if u.Role == "investor" {
+			investorID = id
+		}
+	}
+	tfaSvc := handlers.NewService(store)
+
+	router := gin.New()
+	router.POST("/login", tfaSvc.Login)
+	router.POST("/login/2fa", tfaSvc.VerifyTwoFactorLogin)
+	router.POST("/refresh", tfaSvc.Refresh)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.GET("/2fa", tfaSvc.TwoFactorStatus)
+	api.POST("/2fa/enroll", tfaSvc.EnrollTwoFactor)
+	api.POST("/2fa/confirm", tfaSvc.ConfirmTwoFactor)
+	api.POST("/2fa/recovery-codes", tfaSvc.RegenerateRecoveryCodes)
+	api.POST("/2fa/disable", tfaSvc.DisableTwoFactor)
+	admin := api.Group("/admin", middleware.RequireTwoFactor())
+	admin.GET("/loans", middleware.RequirePermission("loan:read"), tfaSvc.ListLoans)
+	admin.POST("/users/:user_id/2fa/reset", middleware.RequirePermission("user:manage"), tfaSvc.ResetTwoFactor)
+
+	decode := func(body *bytes.Buffer) map[string]interface{} {
+		var result map[string]interface{}
+		json.Unmarshal(body.Bytes(), &result)
+		return result
+	}
+	// password logs in and returns the access token, or the two-factor
+	// token when a code is needed
+	password := func(username string) (string, bool) {
+		resp := doJSON(router, "POST", "/login", "", map[string]string{"username": username, "password": "secret"})
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Login as %s failed: %s", username, resp.Body.String())
+		}
+		result := decode(resp.Body)
+		if result["two_factor_required"] == true {
+			return result["two_factor_token"].(string), true
+		}
+		return result["token"].(string), false
+	}
+	secondStep := func(challenge, code string) (string, int) {
+		resp := doJSON(router, "POST", "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": code})
+		token, _ := decode(resp.Body)["token"].(string)
+		return token, resp.Code
+	}
+	// enroll turns 2FA on and returns the secret and recovery codes
+	enroll := func(token string) (string, []string) {
+		resp := doJSON(router, "POST", "/api/2fa/enroll", token, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Enroll failed: %s", resp.Body.String())
+		}
+		result := decode(resp.Body)
+		secret := result["secret"].(string)
+		if !strings.HasPrefix(result["otpauth_uri"].(string), "otpauth://totp/") {
+			t.Errorf("Expected an otpauth URI, got %v", result["otpauth_uri"])
+		}
+		qr, _ := base64.StdEncoding.DecodeString(result["qr_code_png"].(string))
+		if !bytes.HasPrefix(qr, []byte("\x89PNG")) {
+			t.Error("Expected the QR code to be a PNG")
+		}
+
+		if resp := doJSON(router, "POST", "/api/2fa/confirm", token, map[string]string{"code": "000000"}); resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected a wrong code to be refused, got %d", resp.Code)
+		}
+		code, _ := totp.GenerateCode(secret, time.Now())
+		resp = doJSON(router, "POST", "/api/2fa/confirm", token, map[string]string{"code": code})
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Confirm failed: %s", resp.Body.String())
+		}
+		var confirmed struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &confirmed)
+		if len(confirmed.RecoveryCodes) != 10 {
+			t.Fatalf("Expected 10 recovery codes, got %d", len(confirmed.RecoveryCodes))
+		}
+		return secret, confirmed.RecoveryCodes
+	}
+
+	// Admins must use 2FA: a password-only session cannot reach admin endpoints
+	adminToken, _ := password("admin")
+	if resp := doJSON(router, "GET", "/api/admin/loans", adminToken, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected admin without 2FA to be refused, got %d", resp.Code)
+	}
+	adminSecret, adminRecoveryCodes := enroll(adminToken)
+	challenge, needsCode := password("admin")
+	if !needsCode {
+		t.Fatal("Expected a code to be required after enrolling")
+	}
+	if resp := doJSON(router, "GET", "/api/admin/loans", challenge, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the two-factor token to be refused as an access token, got %d", resp.Code)
+	}
+	// The confirmation code's time step is spent, so use the next one
+	code, _ := totp.GenerateCode(adminSecret, time.Now().Add(30*time.Second))
+	adminToken, status := secondStep(challenge, code)
+	if status != http.StatusOK {
+		t.Fatalf("Expected the code to complete the login, got %d", status)
+	}
+	if resp := doJSON(router, "GET", "/api/admin/loans", adminToken, nil); resp.Code != http.StatusOK {
+		t.Errorf("Expected admin with 2FA to be allowed, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if _, status := secondStep(challenge, code); status != http.StatusUnauthorized {
+		t.Errorf("Expected the two-factor token to be single-use, got %d", status)
+	}
+	challenge, _ = password("admin")
+	if _, status := secondStep(challenge, code); status != http.StatusUnauthorized {
+		t.Errorf("Expected a replayed code to be refused, got %d", status)
+	}
+	if resp := doJSON(router, "POST", "/api/2fa/disable", adminToken, map[string]string{"password": "secret", "code": code}); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected admins to be unable to disable 2FA, got %d", resp.Code)
+	}
+
+	// Refreshed tokens keep the second factor of their session
+	challenge, _ = password("admin")
+	resp := doJSON(router, "POST", "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": adminRecoveryCodes[0]})
+	resp = doJSON(router, "POST", "/refresh", "", map[string]string{"refresh_token": decode(resp.Body)["refresh_token"].(string)})
+	refreshed, _ := decode(resp.Body)["token"].(string)
+	if resp := doJSON(router, "GET", "/api/admin/loans", refreshed, nil); resp.Code != http.StatusOK {
+		t.Errorf("Expected a refreshed 2FA session to reach admin endpoints, got %d", resp.Code)
+	}
+
+	// Investors opt in and can use recovery codes once each
+	investorToken, _ := password("investor1")
+	investorSecret, recoveryCodes := enroll(investorToken)
+	challenge, _ = password("investor1")
+	if _, status := secondStep(challenge, "000000"); status != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong code to be refused, got %d", status)
+	}
+	code, _ = totp.GenerateCode(investorSecret, time.Now().Add(30*time.Second))
+	if _, status := secondStep(challenge, code); status != http.StatusUnauthorized {
+		t.Errorf("Expected the two-factor token to be spent by a failed attempt, got %d", status)
+	}
+	challenge, _ = password("investor1")
+	investorToken, status = secondStep(challenge, strings.ToUpper(recoveryCodes[0]))
+	if status != http.StatusOK {
+		t.Fatalf("Expected a recovery code to complete the login, got %d", status)
+	}
+	challenge, _ = password("investor1")
+	if _, status := secondStep(challenge, recoveryCodes[0]); status != http.StatusUnauthorized {
+		t.Errorf("Expected a recovery code to be single-use, got %d", status)
+	}
+	resp = doJSON(router, "GET", "/api/2fa", investorToken, nil)
+	if result := decode(resp.Body); result["enabled"] != true || result["required"] != false || result["recovery_codes_remaining"] != float64(9) {
+		t.Errorf("Unexpected 2FA status %s", resp.Body.String())
+	}
+	if resp := doJSON(router, "POST", "/api/2fa/disable", investorToken, map[string]string{"password": "wrong", "code": recoveryCodes[1]}); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected disabling with a wrong password to fail, got %d", resp.Code)
+	}
+	if resp := doJSON(router, "POST", "/api/2fa/disable", investorToken, map[string]string{"password": "secret", "code": recoveryCodes[1]}); resp.Code != http.StatusOK {
+		t.Errorf("Disable failed: %s", resp.Body.String())
+	}
+	if _, needsCode := password("investor1"); needsCode {
+		t.Error("Expected password-only login after disabling 2FA")
+	}
+
+	// An admin can reset a user who lost their authenticator
+	investorToken, _ = password("investor1")
+	enroll(investorToken)
+	if resp := doJSON(router, "POST", "/api/admin/users/"+strconv.Itoa(investorID)+"/2fa/reset", adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("Reset failed: %s", resp.Body.String())
+	}
+	if resp := doJSON(router, "GET", "/api/2fa", investorToken, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the user's sessions to end on reset, got %d", resp.Code)
+	}
+	if _, needsCode := password("investor1"); needsCode {
+		t.Error("Expected password-only login after a reset")
+	}
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"loan-service-engine/document"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formOverhead is room in a request for the form fields sent with an upload.
+const formOverhead = 1 << 20
+
+// typeNames names the document types in error messages.
+var typeNames = map[string]string{
+	document.JPEG: "JPEG",
+	document.PNG:  "PNG",
+	document.HEIC: "HEIC",
+	document.PDF:  "PDF",
+}
+
+// limitBody refuses to read more of the request than an upload of maxSize
+// and its form fields need.
+func limitBody(c *gin.Context, maxSize int64) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+formOverhead)
+}
+
+// respondUploadError explains why the upload called what was refused.
+func respondUploadError(c *gin.Context, what string, maxSize int64, allowed []string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, document.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("%s must be at most %d MB", what, maxSize>>20)})
+	case errors.Is(err, document.ErrUnsupportedType):
+		names := make([]string, len(allowed))
+		for i, t := range allowed {
+			names[i] = typeNames[t]
+		}
+		list := names[len(names)-1]
+		if len(names) > 1 {
+			list = strings.Join(names[:len(names)-1], ", ") + " or " + list
+		}
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("%s must be a %s file", what, list)})
+	case errors.Is(err, document.ErrExtensionMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": what + " has a file extension that does not match its content"})
+	case errors.Is(err, document.ErrInvalidImage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": what + " could not be read as an image"})
+	default:
+		log.Printf("Reading %s failed: %v", what, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+	}
+}
+
+// scanUpload runs the virus scanner over an upload and returns the scan
+// state to record for it. contents are the upload as received and the
+// versions of it that are stored, so the bytes kept and served are the ones
+// that were scanned. An upload any part of which could not be scanned is
+// accepted into quarantine and scanned again later. An infected upload is
+// refused with 422; scanUpload responds itself and returns false.
+func (s *Service) scanUpload(c *gin.Context, what string, contents ...[]byte) (string, bool) {
+	status := models.ScanClean
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+		result, err := s.Scanner.Scan(c, bytes.NewReader(content))
+		if err != nil {
+			log.Printf("Scanning %s failed, quarantining it: %v", what, err)
+			status = models.ScanQuarantined
+			continue
+		}
+		if result.Infected {
+			log.Printf("Refused %s: virus scan found %s", what, result.Signature)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": what + " was refused by the virus scan", "signature": result.Signature})
+			return "", false
+		}
+	}
+	return status, true
+}
+
+// quarantined reports whether the document recorded under recorded is
+// waiting for a virus scan to pass.
+func quarantined(ctx context.Context, repos repository.Repositories, recorded string) (bool, error) {
+	doc, err := repos.Documents().GetByKey(ctx, storageKey(recorded))
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return doc.ScanStatus == models.ScanQuarantined, err
+}
+
+// stagedUpload holds uploaded files waiting to be stored. Files are stored
+// under the SHA-256 of their content, so the same file uploaded twice is
+// kept once and can later be checked against its recorded hash. They are
+// only stored as part of the DB work that references them, and deleted
+// again if the workflow does not complete, so an upload never outlives the
+// record that references it.
+type stagedUpload struct {
+	files       storage.Storage
+	store       repository.Store
+	pending     []stagedFile
+	stored      []string
+	quarantined bool
+	kept        bool
+}
+
+type stagedFile struct {
+	doc     models.Document
+	content []byte
+}
+
+func stageUpload(files storage.Storage, store repository.Store) *stagedUpload {
+	return &stagedUpload{files: files, store: store}
+}
+
+// Add queues content to be stored with the given scan state and returns
+// its document, whose Key is the SHA-256 of the content followed by ext.
+func (u *stagedUpload) Add(content []byte, contentType, ext, scanStatus string) models.Document {
+	sum := sha256.Sum256(content)
+	doc := models.Document{
+		SHA256:      hex.EncodeToString(sum[:]),
+		Size:        int64(len(content)),
+		ContentType: contentType,
+		ScanStatus:  scanStatus,
+	}
+	doc.Key = doc.SHA256 + ext
+	u.pending = append(u.pending, stagedFile{doc: doc, content: content})
+	return doc
+}
+
+// Promote records the queued documents in tx and stores the ones not stored
+// before. Content stored before stays quarantined until it is scanned
+// clean, by this upload or by a rescan. Call it with the DB work that
+// references the files, before the loan transition that checks them.
+func (u *stagedUpload) Promote(ctx context.Context, tx repository.Tx) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, f := range u.pending {
+		f.doc.CreatedAt = now
+		if f.doc.ScanStatus == models.ScanClean {
+			f.doc.ScannedAt = now
+		}
+		created, err := tx.Documents().Create(ctx, f.doc)
+		if err != nil {
+			return err
+		}
+		if !created {
+			existing, err := tx.Documents().GetByKey(ctx, f.doc.Key)
+			if err != nil {
+				return err
+			}
+			if existing.ScanStatus == models.ScanQuarantined && f.doc.ScanStatus == models.ScanClean {
+				if err := tx.Documents().SetScanStatus(ctx, f.doc.SHA256, models.ScanClean, now); err != nil {
+					return err
+				}
+			} else if existing.ScanStatus == models.ScanQuarantined {
+				u.quarantined = true
+			}
+			continue
+		}
+		if err := u.files.Put(ctx, f.doc.Key, bytes.NewReader(f.content), f.doc.ContentType); err != nil {
+			return err
+		}
+		u.stored = append(u.stored, f.doc.Key)
+		if f.doc.ScanStatus == models.ScanQuarantined {
+			u.quarantined = true
+		}
+	}
+	return nil
+}
+
+// Quarantined reports whether any promoted file is waiting for a virus scan.
+func (u *stagedUpload) Quarantined() bool {
+	return u.quarantined
+}
+
+// Keep marks the workflow as committed so Cleanup leaves the files alone.
+func (u *stagedUpload) Keep() {
+	u.kept = true
+}
+
+// Cleanup deletes the files stored by Promote unless Keep was called. Meant
+// to be deferred before the transaction's Rollback, so it runs after it.
+func (u *stagedUpload) Cleanup() {
+	if u.kept {
+		return
+	}
+	ctx := context.Background()
+	for _, key := range u.stored {
+		// Another upload of the same content may have committed meanwhile
+		if _, err := u.store.Documents().GetByKey(ctx, key); err == nil {
+			continue
+		}
+		if err := u.files.Delete(ctx, key); err != nil {
+			log.Printf("Failed to remove orphaned upload %s: %v", key, err)
+		}
+	}
+}
+
+// storageKey is the key of a file recorded on a loan. Older records hold
+// the file's URL under /uploads/ rather than the bare key.
+func storageKey(recorded string) string {
+	return path.Base(recorded)
+}
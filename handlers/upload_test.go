@@ -0,0 +1,330 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"loan-service-engine/config"
+	"loan-service-engine/handlers"
+	"loan-service-engine/middleware"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/scanner"
+	"loan-service-engine/scheduler"
+	"loan-service-engine/storage"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// postFile sends a multipart form with one file and the given fields.
+func postFile(router *gin.Engine, path, token, field, filename string, content []byte, fields map[string]string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	fileWriter, _ := writer.CreateFormFile(field, filename)
+	fileWriter.Write(content)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", path, body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// uploadTestEnv serves the upload and file endpoints from a memory store
+// holding an admin and two proposed loans, and returns the admin's token.
+func uploadTestEnv(t *testing.T) (*gin.Engine, *handlers.Service, storage.Storage, string) {
+	config.LoadEnv("../.env")
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	store.Users().Create(ctx, models.User{Username: "admin", Email: "admin@email.com", Role: "admin", PasswordHash: string(hash), EmailVerifiedAt: "2025-01-01T00:00:00Z"})
+	for i := 0; i < 2; i++ {
+		store.Loans().Create(ctx, models.Loan{BorrowerIDNumber: "1111111111111111", Amount: 1000000, Status: "proposed", RequesterID: 1})
+	}
+
+	files := storage.NewLocal(t.TempDir(), "/files/", nil)
+	uploadSvc := handlers.NewService(store)
+	uploadSvc.Files = files
+	router := gin.New()
+	router.GET("/files/*key", gin.WrapH(files))
+	router.POST("/login", uploadSvc.Login)
+	api := router.Group("/api")
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.GET("/loans/:id", uploadSvc.GetLoanDetails)
+	api.GET("/loans/:id/files/:file", uploadSvc.DownloadLoanFile)
+	api.GET("/loans/:id/files/:file/verify", uploadSvc.VerifyLoanFile)
+	api.POST("/admin/approve-loan", uploadSvc.ApproveLoan)
+	api.POST("/admin/disburse-loan", uploadSvc.DisburseLoan)
+
+	resp := doJSON(router, "POST", "/login", "", map[string]string{"username": "admin", "password": "secret"})
+	var login map[string]string
+	json.Unmarshal(resp.Body.Bytes(), &login)
+	return router, uploadSvc, files, login["token"]
+}
+
+func TestUploadValidation(t *testing.T) {
+	router, uploadSvc, _, token := uploadTestEnv(t)
+	store := uploadSvc.Store
+	ctx := context.Background()
+
+	photo, _ := os.ReadFile("../test_db/proof.jpg")
+	approval := map[string]string{"loan_id": "1", "field_validator_employee_id": "EMP001", "approval_date": "2025-06-25"}
+	disbursement := map[string]string{"loan_id": "1", "field_officer_id": "EMP999", "disbursement_date": "2025-06-26"}
+
+	defer func(size int64) { config.MaxProofSize = size }(config.MaxProofSize)
+	config.MaxProofSize = 1 << 20
+	for _, tc := range []struct {
+		name, path, field, filename string
+		content                     []byte
+		fields                      map[string]string
+		want                        int
+	}{
+		{"text as a photo", "/api/admin/approve-loan", "visit_proof", "proof.jpg", []byte("not a photo"), approval, http.StatusUnsupportedMediaType},
+		{"JPEG named .png", "/api/admin/approve-loan", "visit_proof", "proof.png", photo, approval, http.StatusBadRequest},
+		{"truncated JPEG", "/api/admin/approve-loan", "visit_proof", "proof.jpg", photo[:200], approval, http.StatusBadRequest},
+		{"photo over the size limit", "/api/admin/approve-loan", "visit_proof", "proof.jpg", append(photo, make([]byte, 1<<20)...), approval, http.StatusRequestEntityTooLarge},
+		{"request over the size limit", "/api/admin/approve-loan", "visit_proof", "proof.jpg", make([]byte, 3<<20), approval, http.StatusRequestEntityTooLarge},
+		{"photo as an agreement", "/api/admin/disburse-loan", "signed_agreement", "agreement.pdf", photo, disbursement, http.StatusUnsupportedMediaType},
+	} {
+		if resp := postFile(router, tc.path, token, tc.field, tc.filename, tc.content, tc.fields); resp.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, resp.Code, resp.Body.String())
+		}
+	}
+	if loan, _ := store.Loans().Get(ctx, 1); loan.Status != "proposed" {
+		t.Fatalf("Expected refused uploads to leave the loan proposed, got %s", loan.Status)
+	}
+
+	resp := postFile(router, "/api/admin/approve-loan", token, "visit_proof", "proof.jpg", photo, approval)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("ApproveLoan failed: %s", resp.Body.String())
+	}
+
+	// Admins see the visit metadata and a thumbnail for review
+	resp = doJSON(router, "GET", "/api/loans/1", token, nil)
+	var details models.LoanDetails
+	json.Unmarshal(resp.Body.Bytes(), &details)
+	a := details.Approval
+	if a.PhotoTakenAt != "2025-06-25T10:15:30+07:00" || a.Latitude == nil || *a.Latitude != -6.2075 || a.ThumbnailURL != "/api/loans/1/files/proof-thumbnail" {
+		t.Errorf("Expected the visit metadata and thumbnail, got %+v", a)
+	}
+
+	download := func(path string) []byte {
+		resp := doJSON(router, "GET", path, token, nil)
+		if resp.Code != http.StatusFound {
+			t.Fatalf("Expected %s to redirect, got %d", path, resp.Code)
+		}
+		return doJSON(router, "GET", resp.Header().Get("Location"), "", nil).Body.Bytes()
+	}
+	stored := download("/api/loans/1/files/proof")
+	if bytes.Contains(stored, []byte("Exif")) || bytes.Contains(stored, []byte("PhoneMaker")) {
+		t.Error("Expected the stored proof to be stripped of EXIF data")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stored)); err != nil {
+		t.Errorf("Expected the stored proof to be a JPEG: %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(download(a.ThumbnailURL))); err != nil {
+		t.Errorf("Expected the thumbnail to be a JPEG: %v", err)
+	}
+}
+
+func TestDocumentIntegrity(t *testing.T) {
+	router, uploadSvc, files, token := uploadTestEnv(t)
+	store := uploadSvc.Store
+	ctx := context.Background()
+	photo, _ := os.ReadFile("../test_db/proof.jpg")
+
+	// The same photo approving two loans is stored once, under its hash
+	var hashes []string
+	for _, loanID := range []string{"1", "2"} {
+		resp := postFile(router, "/api/admin/approve-loan", token, "visit_proof", "proof.jpg", photo,
+			map[string]string{"loan_id": loanID, "field_validator_employee_id": "EMP001", "approval_date": "2025-06-25"})
+		var result map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		if resp.Code != http.StatusOK || len(result["proof_sha256"]) != 64 {
+			t.Fatalf("ApproveLoan failed: %s", resp.Body.String())
+		}
+		hashes = append(hashes, result["proof_sha256"])
+	}
+	first, _ := store.Approvals().Get(ctx, 1)
+	second, _ := store.Approvals().Get(ctx, 2)
+	if hashes[0] != hashes[1] || first.ProofURL != hashes[0]+".jpg" || second.ProofURL != first.ProofURL {
+		t.Errorf("Expected both approvals to share the stored proof, got %s and %s", first.ProofURL, second.ProofURL)
+	}
+
+	verify := func(path string) (map[string]interface{}, int) {
+		resp := doJSON(router, "GET", path, token, nil)
+		var result map[string]interface{}
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result, resp.Code
+	}
+	result, code := verify("/api/loans/1/files/proof/verify")
+	if code != http.StatusOK || result["intact"] != true || result["sha256"] != hashes[0] || result["computed_sha256"] != hashes[0] || result["content_type"] != "image/jpeg" {
+		t.Errorf("Expected the proof to verify, got %d %v", code, result)
+	}
+	if result, code := verify("/api/loans/2/files/proof-thumbnail/verify"); code != http.StatusOK || result["intact"] != true {
+		t.Errorf("Expected the thumbnail to verify, got %d %v", code, result)
+	}
+
+	// A file changed in storage no longer matches its recorded hash
+	files.Put(ctx, first.ProofURL, strings.NewReader("edited photo"), "image/jpeg")
+	result, code = verify("/api/loans/2/files/proof/verify")
+	if code != http.StatusOK || result["intact"] != false || result["computed_sha256"] == hashes[0] {
+		t.Errorf("Expected an altered proof to fail verification, got %d %v", code, result)
+	}
+	files.Delete(ctx, first.ProofURL)
+	if result, code := verify("/api/loans/2/files/proof/verify"); code != http.StatusOK || result["intact"] != false {
+		t.Errorf("Expected a missing proof to fail verification, got %d %v", code, result)
+	}
+
+	// Files uploaded before hashes were recorded cannot be verified
+	store.Loans().Create(ctx, models.Loan{BorrowerIDNumber: "1111111111111111", Amount: 1000000, Status: "approved", RequesterID: 1})
+	store.Approvals().Create(ctx, 3, models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-01-01", ProofURL: "/uploads/proof_legacy.jpg"})
+	if _, code := verify("/api/loans/3/files/proof/verify"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a file without an integrity record, got %d", code)
+	}
+}
+
+// fakeScanner fails with err while it is set and otherwise finds signature
+// in every file, or nothing when signature is empty. It keeps what it was
+// given in scanned.
+type fakeScanner struct {
+	err       error
+	signature string
+	scanned   [][]byte
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	content, _ := io.ReadAll(r)
+	s.scanned = append(s.scanned, content)
+	if s.err != nil {
+		return scanner.Result{}, s.err
+	}
+	return scanner.Result{Infected: s.signature != "", Signature: s.signature}, nil
+}
+
+func TestVirusScanQuarantine(t *testing.T) {
+	router, uploadSvc, files, token := uploadTestEnv(t)
+	fake := &fakeScanner{}
+	uploadSvc.Scanner = fake
+	store := uploadSvc.Store
+	ctx := context.Background()
+	store.Loans().Create(ctx, models.Loan{BorrowerIDNumber: "1111111111111111", Amount: 1000000, Rate: 12, TenureMonths: 6, Status: "invested", RequesterID: 1})
+
+	photo, _ := os.ReadFile("../test_db/proof.jpg")
+	agreement := []byte("%PDF-1.4\n% signed agreement\n%%EOF\n")
+	approve := func(loanID string, content []byte) *httptest.ResponseRecorder {
+		return postFile(router, "/api/admin/approve-loan", token, "visit_proof", "proof.jpg", content,
+			map[string]string{"loan_id": loanID, "field_validator_employee_id": "EMP001", "approval_date": "2025-06-25"})
+	}
+	status := func(loanID int) string {
+		loan, _ := store.Loans().Get(ctx, loanID)
+		return loan.Status
+	}
+
+	// Infected uploads are refused and never stored
+	fake.signature = "Eicar-Test-Signature"
+	if resp := approve("1", photo); resp.Code != http.StatusUnprocessableEntity || !strings.Contains(resp.Body.String(), "Eicar-Test-Signature") {
+		t.Errorf("Expected an infected proof to be refused, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if _, err := store.Approvals().Get(ctx, 1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected no approval for an infected proof, got %v", err)
+	}
+
+	// While the scanner is down uploads are quarantined and the loans wait
+	fake.signature, fake.err = "", errors.New("clamd unavailable")
+	resp := approve("1", photo)
+	if resp.Code != http.StatusAccepted || !strings.Contains(resp.Body.String(), models.ScanQuarantined) {
+		t.Fatalf("Expected the approval to wait for the scan, got %d: %s", resp.Code, resp.Body.String())
+	}
+	resp = postFile(router, "/api/admin/disburse-loan", token, "signed_agreement", "agreement.pdf", agreement,
+		map[string]string{"loan_id": "3", "field_officer_id": "EMP999", "disbursement_date": "2025-06-26"})
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("Expected the disbursement to wait for the scan, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if status(1) != "proposed" || status(3) != "invested" {
+		t.Errorf("Expected quarantine to hold the loans, got %s / %s", status(1), status(3))
+	}
+	if resp := doJSON(router, "GET", "/api/loans/1/files/proof", token, nil); resp.Code != http.StatusConflict {
+		t.Errorf("Expected a quarantined proof not to be served, got %d", resp.Code)
+	}
+	if resp := approve("1", photo); resp.Code != http.StatusConflict {
+		t.Errorf("Expected approving again during quarantine to be refused, got %d", resp.Code)
+	}
+
+	moved, err := scheduler.RescanQuarantined(ctx, store, files, fake)
+	if err != nil || len(moved) != 0 {
+		t.Fatalf("Expected nothing to move while the scanner is down, got %v %v", moved, err)
+	}
+
+	// A clean rescan releases the files and lets the loans move on
+	moved, err = scheduler.RescanQuarantined(ctx, store, files, &fakeScanner{})
+	if err != nil || len(moved) != 2 {
+		t.Fatalf("Expected both loans to move on, got %v %v", moved, err)
+	}
+	if status(1) != "approved" || status(3) != "disbursed" {
+		t.Errorf("Expected the loans to be approved and disbursed, got %s / %s", status(1), status(3))
+	}
+	if resp := doJSON(router, "GET", "/api/loans/1/files/proof", token, nil); resp.Code != http.StatusFound {
+		t.Errorf("Expected the released proof to be served, got %d", resp.Code)
+	}
+
+	// An infected rescan discards the file and the approval waiting for it
+	other := &bytes.Buffer{}
+	jpeg.Encode(other, image.NewGray(image.Rect(0, 0, 32, 32)), nil)
+	if resp := approve("2", other.Bytes()); resp.Code != http.StatusAccepted {
+		t.Fatalf("Expected the approval to wait for the scan, got %d: %s", resp.Code, resp.Body.String())
+	}
+	approval, _ := store.Approvals().Get(ctx, 2)
+	if _, err := scheduler.RescanQuarantined(ctx, store, files, &fakeScanner{signature: "Eicar-Test-Signature"}); err != nil {
+		t.Fatalf("RescanQuarantined failed: %v", err)
+	}
+	if _, err := store.Approvals().Get(ctx, 2); !errors.Is(err, repository.ErrNotFound) || status(2) != "proposed" {
+		t.Errorf("Expected the approval to be removed and the loan to stay proposed, got %v / %s", err, status(2))
+	}
+	if _, err := files.Get(ctx, approval.ProofURL); err == nil {
+		t.Error("Expected the infected proof to be deleted")
+	}
+	if docs, _ := store.Documents().ListByScanStatus(ctx, models.ScanQuarantined); len(docs) != 0 {
+		t.Errorf("Expected no quarantined documents left, got %d", len(docs))
+	}
+
+	fake.err, fake.scanned = nil, nil
+	if resp := approve("2", photo); resp.Code != http.StatusOK || status(2) != "approved" {
+		t.Errorf("Expected the loan to be approved with a clean proof, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	// The photo stored without its metadata, and its thumbnail, are
+	// scanned as well as the upload
+	approval, _ = store.Approvals().Get(ctx, 2)
+	for _, key := range []string{approval.ProofURL, approval.ThumbnailURL} {
+		f, _ := files.Get(ctx, key)
+		stored, _ := io.ReadAll(f)
+		f.Close()
+		found := false
+		for _, content := range fake.scanned {
+			found = found || bytes.Equal(content, stored)
+		}
+		if !found {
+			t.Errorf("Expected the stored %s to have been scanned", key)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one signing key, identified in token headers by its kid.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// Public returns the key other services verify tokens with.
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// KeySet signs tokens with its active key and verifies tokens signed by any
+// of its keys, so a new key can be published before it is used and an old
+// one kept until the tokens it signed have expired.
+//
+// Without asymmetric keys it falls back to HS256 with the shared secret.
+// Once keys are loaded the secret is ignored, except that it verifies
+// tokens without a kid until legacyUntil, so HS256 tokens issued before the
+// move to asymmetric keys can run out.
+type KeySet struct {
+	active      *Key
+	keys        map[string]*Key
+	secret      []byte
+	legacyUntil time.Time
+}
+
+// Load reads the PEM private keys listed in spec as comma separated
+// kid=path pairs. activeKID picks the signing key and defaults to the first
+// one listed. legacyUntil is when HS256 tokens stop being accepted once
+// keys are loaded; the zero time refuses them right away.
+func Load(spec, activeKID, secret string, legacyUntil time.Time) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*Key{}, secret: []byte(secret), legacyUntil: legacyUntil}
+
+	var order []string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, "=")
+		kid, path = strings.TrimSpace(kid), strings.TrimSpace(path)
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected kid=path", entry)
+		}
+		if _, dup := set.keys[kid]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		key, err := ParseKey(kid, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		set.keys[kid] = key
+		order = append(order, kid)
+	}
+
+	switch {
+	case activeKID != "":
+		set.active = set.keys[activeKID]
+		if set.active == nil {
+			return nil, fmt.Errorf("active key %q is not among the loaded keys", activeKID)
+		}
+	case len(order) > 0:
+		set.active = set.keys[order[0]]
+	case len(set.secret) == 0:
+		return nil, errors.New("no signing keys and no shared secret configured")
+	}
+	return set, nil
+}
+
+// ParseKey reads an RSA or Ed25519 private key in PKCS#8 or PKCS#1 PEM form.
+func ParseKey(kid string, pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, expected a private key", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, private: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, private: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, expected RSA or Ed25519", parsed)
+	}
+}
+
+// Generate creates a new private key for alg (RS256 or EdDSA) and returns
+// it PEM encoded in PKCS#8 form.
+func Generate(alg string) ([]byte, error) {
+	var key interface{}
+	var err error
+	switch strings.ToUpper(alg) {
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EDDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q, expected RS256 or EdDSA", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Sign signs the claims with the active key, naming it in the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.private)
+}
+
+// Parse verifies a token against the key its kid names and returns it with
+// its claims. The algorithm must be the one that key is used with.
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if !s.acceptsSecret() || token.Method != jwt.SigningMethodHS256 {
+				return nil, jwt.ErrTokenUnverifiable
+			}
+			return s.secret, nil
+		}
+		key := s.keys[kid]
+		if key == nil {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method != key.Method {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.Public(), nil
+	}, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
+}
+
+// acceptsSecret reports whether HS256 tokens signed with the shared secret
+// are still valid: always without asymmetric keys, and with them only
+// until legacyUntil.
+func (s *KeySet) acceptsSecret() bool {
+	if len(s.secret) == 0 {
+		return false
+	}
+	return len(s.keys) == 0 || time.Now().Before(s.legacyUntil)
+}
@@ -0,0 +1,181 @@
+package jwtkeys
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey generates a key for alg and returns its kid=path entry.
+func writeKey(t *testing.T, kid, alg string) string {
+	pemBytes, err := Generate(alg)
+	if err != nil {
+		t.Fatalf("Generate %s failed: %v", alg, err)
+	}
+	path := filepath.Join(t.TempDir(), kid+".pem")
+	if err := os.WriteFile(path, pemBytes, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return kid + "=" + path
+}
+
+func load(t *testing.T, spec, activeKID, secret string) *KeySet {
+	set, err := Load(spec, activeKID, secret, time.Time{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return set
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": 1, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func sign(t *testing.T, set *KeySet) string {
+	token, err := set.Sign(claims())
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	return token
+}
+
+func TestSignAndParse(t *testing.T) {
+	rsaKey, edKey := writeKey(t, "rsa-1", "RS256"), writeKey(t, "ed-1", "EdDSA")
+
+	for _, tc := range []struct{ kid, alg string }{{"rsa-1", "RS256"}, {"ed-1", "EdDSA"}} {
+		set := load(t, rsaKey+","+edKey, tc.kid, "")
+		token, err := set.Parse(sign(t, set))
+		if err != nil {
+			t.Fatalf("%s: Parse failed: %v", tc.kid, err)
+		}
+		if token.Header["kid"] != tc.kid || token.Method.Alg() != tc.alg {
+			t.Errorf("Expected %s signed with %s, got kid %v alg %s", tc.alg, tc.kid, token.Header["kid"], token.Method.Alg())
+		}
+	}
+
+	// HS256 with the shared secret when no keys are configured
+	hmac := load(t, "", "", "secret")
+	if _, err := hmac.Parse(sign(t, hmac)); err != nil {
+		t.Errorf("Expected HS256 token to verify: %v", err)
+	}
+	if _, err := load(t, rsaKey, "", "").Parse(sign(t, hmac)); err == nil {
+		t.Error("Expected HS256 token to be rejected without the shared secret")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey, newKey := writeKey(t, "2025-01", "EdDSA"), writeKey(t, "2025-02", "RS256")
+
+	before := load(t, oldKey, "", "")
+	token := sign(t, before)
+
+	// Step 1 and 2: the new key is published, then made active
+	during := load(t, oldKey+","+newKey, "2025-02", "")
+	if _, err := during.Parse(token); err != nil {
+		t.Errorf("Tokens from the old key should verify during rotation: %v", err)
+	}
+	if parsed, _ := during.Parse(sign(t, during)); parsed == nil || parsed.Header["kid"] != "2025-02" {
+		t.Error("Expected new tokens to be signed with the new key")
+	}
+	if got := len(during.JWKS().Keys); got != 2 {
+		t.Errorf("Expected both keys in the JWKS, got %d", got)
+	}
+
+	// Step 3: the old key is removed
+	after := load(t, newKey, "", "")
+	if _, err := after.Parse(token); err == nil {
+		t.Error("Expected tokens from a removed key to be rejected")
+	}
+}
+
+func TestAlgorithmMustMatchKey(t *testing.T) {
+	set := load(t, writeKey(t, "ed-1", "EdDSA"), "", "secret")
+
+	// An HS256 token naming an asymmetric key must not be verified with
+	// the shared secret or the public key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "ed-1"
+	signed, _ := forged.SignedString([]byte("secret"))
+	if _, err := set.Parse(signed); err == nil {
+		t.Error("Expected HS256 token with an asymmetric kid to be rejected")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	unknown.Header["kid"] = "missing"
+	signed, _ = unknown.SignedString([]byte("secret"))
+	if _, err := set.Parse(signed); err == nil {
+		t.Error("Expected token with an unknown kid to be rejected")
+	}
+}
+
+func TestLegacySecretExpires(t *testing.T) {
+	key := writeKey(t, "rsa-1", "RS256")
+	legacy := sign(t, load(t, "", "", "secret"))
+
+	// Once keys are loaded a token without a kid signed with the shared
+	// secret is refused, unless legacy tokens are still allowed
+	for _, tc := range []struct {
+		name   string
+		until  time.Time
+		accept bool
+	}{
+		{"no legacy window", time.Time{}, false},
+		{"within the legacy window", time.Now().Add(time.Hour), true},
+		{"after the legacy window", time.Now().Add(-time.Hour), false},
+	} {
+		set, err := Load(key, "", "secret", tc.until)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if _, err := set.Parse(legacy); (err == nil) != tc.accept {
+			t.Errorf("%s: expected accepted %v, got error %v", tc.name, tc.accept, err)
+		}
+	}
+}
+
+// A verifier holding only the published JWKS can check our tokens.
+func TestJWKSVerifiesTokens(t *testing.T) {
+	set := load(t, writeKey(t, "rsa-1", "RS256"), "", "secret")
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("Expected 1 published key, the secret must stay private; got %d", len(jwks.Keys))
+	}
+	jwk := jwks.Keys[0]
+	if jwk.KeyType != "RSA" || jwk.KeyID != "rsa-1" || jwk.Algorithm != "RS256" || jwk.Use != "sig" {
+		t.Fatalf("Unexpected JWK %+v", jwk)
+	}
+
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	_, err := jwt.Parse(sign(t, set), func(*jwt.Token) (interface{}, error) { return pub, nil }, jwt.WithValidMethods([]string{jwk.Algorithm}))
+	if err != nil {
+		t.Errorf("Expected token to verify with the published key: %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	key := writeKey(t, "rsa-1", "RS256")
+	notAKey := filepath.Join(t.TempDir(), "plain.txt")
+	os.WriteFile(notAKey, []byte("hello"), 0600)
+
+	cases := map[string][3]string{
+		"no keys or secret":  {"", "", ""},
+		"unknown active kid": {key, "rsa-2", ""},
+		"missing file":       {"rsa-2=/does/not/exist.pem", "", ""},
+		"not a PEM file":     {"plain=" + notAKey, "", ""},
+		"malformed entry":    {"rsa-1", "", ""},
+		"duplicate kid":      {key + "," + key, "", ""},
+	}
+	for name, args := range cases {
+		if _, err := Load(args[0], args[1], args[2], time.Time{}); err == nil {
+			t.Errorf("%s: expected Load to fail", name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package loanstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+	"loan-service-engine/repayment"
+	"loan-service-engine/repository"
+)
+
+// Default is the lifecycle used by the handlers:
+// proposed -> approved -> invested -> disbursed -> repaid, with proposed loans
+// able to be rejected or cancelled, and approved loans that fail to fund able
+// to be cancelled or to expire once their funding deadline passes.
+var Default = newDefault()
+
+func newDefault() *Machine {
+	m := New()
+
+	m.Allow(Proposed, Approved)
+	m.Allow(Approved, Invested)
+	m.Allow(Invested, Disbursed)
+	m.Allow(Disbursed, Repaid)
+	m.Allow(Proposed, Rejected)
+	m.Allow(Proposed, Cancelled)
+	m.Allow(Approved, Cancelled)
+	m.Allow(Approved, Expired)
+
+	m.Guard(Proposed, Approved, requireApproval)
+	m.Guard(Approved, Invested, requireFullyFunded)
+	m.Guard(Invested, Disbursed, requireDisbursement)
+	m.Guard(Disbursed, Repaid, requireNothingOutstanding)
+	m.Guard(Proposed, Rejected, requireReason)
+	m.Guard(Approved, Expired, requireDeadlinePassed)
+
+	m.OnTransition(Proposed, Approved, setFundingDeadline)
+	m.OnTransition(Approved, Cancelled, RefundInvestments)
+	m.OnTransition(Approved, Expired, RefundInvestments)
+	m.OnTransition(Invested, Disbursed, createRepaymentSchedule)
+
+	return m
+}
+
+func requireApproval(ctx context.Context, tx repository.Repositories, t Transition) error {
+	approval, err := tx.Approvals().Get(ctx, t.LoanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return Block("loan has no approval record")
+	} else if err != nil {
+		return err
+	}
+	return requireScanned(ctx, tx, approval.ProofURL, "visit proof")
+}
+
+func requireDisbursement(ctx context.Context, tx repository.Repositories, t Transition) error {
+	disbursement, err := tx.Disbursements().Get(ctx, t.LoanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return Block("loan has no disbursement record")
+	} else if err != nil {
+		return err
+	}
+	return requireScanned(ctx, tx, disbursement.SignedAgreementURL, "signed agreement")
+}
+
+// requireScanned blocks while the document recorded for the loan is
+// quarantined. Files uploaded before documents were recorded pass.
+func requireScanned(ctx context.Context, tx repository.Repositories, recorded, what string) error {
+	doc, err := tx.Documents().GetByKey(ctx, path.Base(recorded))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if doc.ScanStatus != models.ScanClean {
+		return Block(what + " is quarantined until it passes the virus scan")
+	}
+	return nil
+}
+
+func requireFullyFunded(ctx context.Context, tx repository.Repositories, t Transition) error {
+	loan, err := tx.Loans().Get(ctx, t.LoanID)
+	if err != nil {
+		return err
+	}
+	invested, err := tx.Investments().TotalActive(ctx, t.LoanID)
+	if err != nil {
+		return err
+	}
+	if invested < loan.Amount {
+		return Block("loan is not fully funded")
+	}
+	return nil
+}
+
+func requireNothingOutstanding(ctx context.Context, tx repository.Repositories, t Transition) error {
+	installments, err := tx.Repayments().Installments(ctx, t.LoanID)
+	if err != nil {
+		return err
+	}
+	for _, inst := range installments {
+		if inst.Status != "paid" {
+			return Block("loan still has unpaid installments")
+		}
+	}
+	return nil
+}
+
+func requireReason(ctx context.Context, tx repository.Repositories, t Transition) error {
+	if strings.TrimSpace(t.Reason) == "" {
+		return Block("a reason is required")
+	}
+	return nil
+}
+
+// RefundInvestments releases every active investment in a loan that will no
+// longer be funded. The rows are kept and marked 'refunded' for the audit trail.
+func RefundInvestments(ctx context.Context, tx repository.Repositories, t Transition) error {
+	return tx.Investments().RefundActive(ctx, t.LoanID, t.At.Format(time.RFC3339))
+}
+
+// Refund is what one investor gets back when a loan stops funding.
+type Refund struct {
+	InvestorID int
+	Email      string
+	Amount     money.Amount
+}
+
+// Refunds totals a loan's refunded investments per investor, in the order the
+// investors first invested.
+func Refunds(investments []models.Investment) []Refund {
+	var refunds []Refund
+	index := map[int]int{}
+	for _, inv := range investments {
+		if inv.Status != "refunded" {
+			continue
+		}
+		i, ok := index[inv.InvestorID]
+		if !ok {
+			i = len(refunds)
+			index[inv.InvestorID] = i
+			refunds = append(refunds, Refund{InvestorID: inv.InvestorID, Email: inv.Email})
+		}
+		refunds[i].Amount += inv.Amount
+	}
+	return refunds
+}
+
+// createRepaymentSchedule computes the installment plan for a loan from its
+// amount, rate and tenure and stores it in repayment_schedules. It runs as a
+// side effect of the invested -> disbursed transition.
+func createRepaymentSchedule(ctx context.Context, tx repository.Repositories, t Transition) error {
+	loan, err := tx.Loans().Get(ctx, t.LoanID)
+	if err != nil {
+		return fmt.Errorf("failed to load loan terms: %v", err)
+	}
+	disbursement, err := tx.Disbursements().Get(ctx, t.LoanID)
+	if err != nil {
+		return fmt.Errorf("failed to load disbursement: %v", err)
+	}
+
+	start, err := time.Parse("2006-01-02", disbursement.DisbursedAt)
+	if err != nil {
+		return fmt.Errorf("invalid disbursement date %q: %v", disbursement.DisbursedAt, err)
+	}
+
+	installments, err := repayment.GenerateSchedule(loan.Amount, loan.Rate, loan.TenureMonths, loan.RepaymentMethod, start)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Repayments().CreateInstallments(ctx, t.LoanID, installments); err != nil {
+		return fmt.Errorf("failed to store repayment schedule: %v", err)
+	}
+	return nil
+}
+
+// setFundingDeadline opens the funding window when a loan is approved.
+func setFundingDeadline(ctx context.Context, tx repository.Repositories, t Transition) error {
+	loan, err := tx.Loans().Get(ctx, t.LoanID)
+	if err != nil {
+		return err
+	}
+	deadline := t.At.AddDate(0, 0, loan.FundingWindowDays).Format(time.RFC3339)
+	return tx.Loans().SetFundingDeadline(ctx, t.LoanID, deadline)
+}
+
+func requireDeadlinePassed(ctx context.Context, tx repository.Repositories, t Transition) error {
+	loan, err := tx.Loans().Get(ctx, t.LoanID)
+	if err != nil {
+		return err
+	}
+	if loan.FundingDeadline == "" {
+		return Block("loan has no funding deadline")
+	}
+	if loan.FundingDeadline > t.At.Format(time.RFC3339) {
+		return Block("funding deadline has not passed yet")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Package loanstate defines the loan lifecycle: which status a loan may move
+// to from its current one, the checks that must pass first and the work done
+// as part of each move. Every transition is written to loan_status_history.
+package loanstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+)
+
+type State string
+
+const (
+	Proposed  State = "proposed"
+	Approved  State = "approved"
+	Invested  State = "invested"
+	Disbursed State = "disbursed"
+	Repaid    State = "repaid"
+	Rejected  State = "rejected"
+	Cancelled State = "cancelled"
+	Expired   State = "expired"
+)
+
+var ErrLoanNotFound = errors.New("loan not found")
+
+// ErrConcurrentUpdate is returned when the loan changed status between being
+// read and being updated.
+var ErrConcurrentUpdate = errors.New("loan status changed concurrently")
+
+// InvalidTransitionError is returned when the lifecycle does not allow moving
+// a loan from its current status to the requested one.
+type InvalidTransitionError struct {
+	From, To State
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("cannot move loan from '%s' to '%s'", e.From, e.To)
+}
+
+// GuardError is returned by guards to block a transition for a business
+// reason, as opposed to a failure while checking.
+type GuardError struct {
+	Reason string
+}
+
+func (e *GuardError) Error() string {
+	return e.Reason
+}
+
+// Block makes a GuardError with the given reason.
+func Block(reason string) error {
+	return &GuardError{Reason: reason}
+}
+
+// Transition describes a single status change.
+type Transition struct {
+	LoanID  int
+	From    State
+	To      State
+	ActorID int // 0 when the system made the change
+	Reason  string
+	// At is when the change is made, in UTC: the current time unless the
+	// context carries another through WithNow. Hooks use it instead of the
+	// clock.
+	At time.Time
+}
+
+type nowKey struct{}
+
+// WithNow makes transitions under ctx happen at now rather than at the
+// current time, so a job working from its own clock, such as the funding
+// expiry run, has its guards judge by that clock too.
+func WithNow(ctx context.Context, now time.Time) context.Context {
+	return context.WithValue(ctx, nowKey{}, now)
+}
+
+func nowFrom(ctx context.Context) time.Time {
+	if now, ok := ctx.Value(nowKey{}).(time.Time); ok {
+		return now.UTC()
+	}
+	return time.Now().UTC()
+}
+
+// Hook is run inside the transition's transaction. Guards run before the
+// status is updated and can veto the change; effects run after it.
+type Hook func(ctx context.Context, tx repository.Repositories, t Transition) error
+
+type edge struct {
+	from, to State
+}
+
+type Machine struct {
+	allowed map[edge]bool
+	guards  map[edge][]Hook
+	effects map[edge][]Hook
+}
+
+func New() *Machine {
+	return &Machine{
+		allowed: map[edge]bool{},
+		guards:  map[edge][]Hook{},
+		effects: map[edge][]Hook{},
+	}
+}
+
+// Allow registers from -> to as a valid transition.
+func (m *Machine) Allow(from, to State) {
+	m.allowed[edge{from, to}] = true
+}
+
+// Guard adds a check that must pass before from -> to is applied.
+func (m *Machine) Guard(from, to State, h Hook) {
+	m.guards[edge{from, to}] = append(m.guards[edge{from, to}], h)
+}
+
+// OnTransition adds a side effect run right after from -> to is applied.
+func (m *Machine) OnTransition(from, to State, h Hook) {
+	m.effects[edge{from, to}] = append(m.effects[edge{from, to}], h)
+}
+
+func (m *Machine) CanTransition(from, to State) bool {
+	return m.allowed[edge{from, to}]
+}
+
+// Transition moves a loan to the given status inside tx: it checks the move is
+// allowed, runs the guards, updates the loan status, records the history row
+// and runs the side effects. The caller commits or rolls back tx.
+func (m *Machine) Transition(ctx context.Context, tx repository.Repositories, loanID int, to State, actorID int, reason string) (Transition, error) {
+	loan, err := tx.Loans().GetForUpdate(ctx, loanID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return Transition{}, ErrLoanNotFound
+	} else if err != nil {
+		return Transition{}, err
+	}
+
+	t := Transition{LoanID: loanID, From: State(loan.Status), To: to, ActorID: actorID, Reason: reason, At: nowFrom(ctx)}
+	e := edge{t.From, t.To}
+	if !m.allowed[e] {
+		return t, &InvalidTransitionError{From: t.From, To: t.To}
+	}
+
+	for _, guard := range m.guards[e] {
+		if err := guard(ctx, tx, t); err != nil {
+			return t, err
+		}
+	}
+
+	updated, err := tx.Loans().UpdateStatus(ctx, loanID, string(t.From), string(t.To))
+	if err != nil {
+		return t, err
+	}
+	if !updated {
+		return t, ErrConcurrentUpdate
+	}
+
+	if err := record(ctx, tx, t); err != nil {
+		return t, err
+	}
+
+	for _, effect := range m.effects[e] {
+		if err := effect(ctx, tx, t); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// RecordCreation writes the initial history entry for a newly created loan.
+func RecordCreation(ctx context.Context, tx repository.Repositories, loanID, actorID int) error {
+	return record(ctx, tx, Transition{LoanID: loanID, To: Proposed, ActorID: actorID, At: nowFrom(ctx)})
+}
+
+func record(ctx context.Context, tx repository.Repositories, t Transition) error {
+	return tx.Loans().AddStatusChange(ctx, t.LoanID, models.StatusChange{
+		FromStatus: string(t.From),
+		ToStatus:   string(t.To),
+		ActorID:    t.ActorID,
+		Reason:     t.Reason,
+		ChangedAt:  t.At.Format(time.RFC3339),
+	})
+}
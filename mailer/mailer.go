@@ -0,0 +1,28 @@
+// Package mailer delivers the emails composed in utils.
+package mailer
+
+import (
+	"context"
+	"log"
+
+	"loan-service-engine/utils"
+)
+
+// Sender delivers one email. An error means it was not sent.
+type Sender interface {
+	Send(ctx context.Context, email utils.EmailPreview) error
+}
+
+// Log writes every email, body included, to the log instead of sending it.
+// It is meant for development only: the bodies carry live verification,
+// invite and password reset links.
+func Log() Sender {
+	return logSender{}
+}
+
+type logSender struct{}
+
+func (logSender) Send(ctx context.Context, email utils.EmailPreview) error {
+	log.Printf("[Email sent to log]\nTo: %s\nSubject: %s\n\n%s\n", email.To, email.Subject, email.Body)
+	return nil
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"loan-service-engine/utils"
+)
+
+// fakeSMTP accepts one message per connection and sends what it received,
+// envelope and data, on the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, received)
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func serveSMTP(conn net.Conn, received chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var got strings.Builder
+	io.WriteString(conn, "220 fake ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			io.WriteString(conn, "250 fake\r\n")
+		case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RCPT"):
+			got.WriteString(line)
+			io.WriteString(conn, "250 OK\r\n")
+		case command == "DATA":
+			io.WriteString(conn, "354 Go ahead\r\n")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				got.WriteString(line)
+			}
+			io.WriteString(conn, "250 Queued\r\n")
+		case command == "QUIT":
+			io.WriteString(conn, "221 Bye\r\n")
+			received <- got.String()
+			return
+		default:
+			io.WriteString(conn, "502 Not implemented\r\n")
+		}
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	address, received := fakeSMTP(t)
+	sender, err := NewSMTP(address, "Loan Service <no-reply@loan.example>", "", "", 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewSMTP failed: %v", err)
+	}
+
+	email := utils.EmailPreview{To: "investor1@email.com", Subject: "Loan #1 Expired", Body: "Dear Investor,\n\nLoan #1 has expired."}
+	if err := sender.Send(context.Background(), email); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	got := <-received
+	for _, want := range []string{
+		"MAIL FROM:<no-reply@loan.example>",
+		"RCPT TO:<investor1@email.com>",
+		"From: Loan Service <no-reply@loan.example>\r\n",
+		"To: investor1@email.com\r\n",
+		"Subject: Loan #1 Expired\r\n",
+		"\r\n\r\nDear Investor,\r\n\r\nLoan #1 has expired.\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected the message to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	// Headers cannot be injected through the recipient or subject
+	email.Subject = "Hello\r\nBcc: someone@evil.example"
+	if err := sender.Send(context.Background(), email); err == nil {
+		t.Errorf("Expected a subject with a line break to be refused")
+	}
+}
+
+func TestSMTPUnavailable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := ln.Addr().String()
+	ln.Close()
+
+	sender, _ := NewSMTP(address, "no-reply@loan.example", "", "", time.Second)
+	if err := sender.Send(context.Background(), utils.EmailPreview{To: "investor1@email.com", Subject: "Hi", Body: "Hi"}); err == nil {
+		t.Errorf("Expected sending without a server to fail")
+	}
+}
+
+func TestNewSMTPInvalid(t *testing.T) {
+	for _, c := range []struct{ address, from string }{
+		{"smtp.example.com", "no-reply@loan.example"},
+		{":25", "no-reply@loan.example"},
+		{"smtp.example.com:25", ""},
+		{"smtp.example.com:25", "not an address"},
+	} {
+		if _, err := NewSMTP(c.address, c.from, "", "", time.Second); err == nil {
+			t.Errorf("Expected %q from %q to be refused", c.address, c.from)
+		}
+	}
+}
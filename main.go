@@ -2,58 +2,207 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"loan-service-engine/config"
 	"loan-service-engine/db"
 	"loan-service-engine/handlers"
+	"loan-service-engine/jwtkeys"
+	"loan-service-engine/mailer"
 	"loan-service-engine/middleware"
+	"loan-service-engine/repository"
+	"loan-service-engine/scheduler"
+	"loan-service-engine/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	log.Println("Start the service")
 	config.LoadEnv()
 	db.Connect()
+
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
+	log.Println("Start the service")
+	if _, ok := config.Mailer.(*mailer.SMTP); !ok {
+		log.Println("MAIL_SENDER=log: emails, links included, are written to the log instead of sent. Use smtp outside development.")
+	}
+
+	if config.AutoMigrate {
+		if _, err := db.MigrateUp(db.DB); err != nil {
+			log.Fatal("Failed to migrate database: ", err)
+		}
+	}
+	if config.SeedDevData {
+		if err := db.SeedDev(db.DB); err != nil {
+			log.Fatal("Failed to seed database: ", err)
+		}
+	}
+
+	store := repository.NewSQLStore(db.DB)
+	svc := handlers.NewService(store)
+
+	// Background job expiring approved loans that missed their funding deadline
+	go scheduler.StartFundingExpiry(context.Background(), store, svc.Mail, config.FundingExpiryInterval)
+	// Background job scanning quarantined uploads again once the scanner is back
+	go scheduler.StartRescans(context.Background(), store, config.Files, config.Scanner, config.RescanInterval)
+
 	r := gin.Default()
+	// Only believe X-Forwarded-For from our own proxies, or clients could
+	// pick the IP their failed logins are counted against
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
 
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "pong"})
 	})
 
-	r.POST("/login", handlers.Login)
+	r.GET("/.well-known/jwks.json", svc.JWKS)
+	r.POST("/login", svc.Login)
+	r.POST("/login/2fa", svc.VerifyTwoFactorLogin)
+	r.POST("/refresh", svc.Refresh)
+	r.POST("/register", svc.Register)
+	r.GET("/verify-email", svc.VerifyEmail)
+	r.POST("/resend-verification", svc.ResendVerification)
+	r.POST("/forgot-password", svc.ForgotPassword)
+	r.POST("/reset-password", svc.ResetPassword)
+
+	// Signed download links for files kept on local disk; S3 serves its own
+	if local, ok := config.Files.(*storage.Local); ok {
+		r.GET(config.LocalFilesURLPrefix+"*key", gin.WrapH(local))
+	}
 
 	//Routes that needs authentications
 	api := r.Group("/api")
-	api.Use(middleware.JWTAuthMiddleware())
-	api.GET("/loans/:id", handlers.GetLoanDetails)
+	api.Use(middleware.JWTAuthMiddleware(store))
+	api.GET("/loans/:id", middleware.RequireTwoFactor(), svc.GetLoanDetails)
+	api.GET("/loans/:id/files/:file", middleware.RequireTwoFactor(), svc.DownloadLoanFile)
+	api.GET("/loans/:id/files/:file/verify", middleware.RequireTwoFactor(), svc.VerifyLoanFile)
+	api.POST("/logout", middleware.RequireUser(), svc.Logout)
+	api.POST("/logout-all", middleware.RequireUser(), svc.LogoutAll)
+	api.POST("/password", middleware.RequireUser(), svc.ChangePassword)
 
-	r.Static("/uploads", "./uploads")
+	// Reachable without a second factor, so users can set one up
+	twoFactorGroup := api.Group("/2fa", middleware.RequireUser())
+	{
+		twoFactorGroup.GET("", svc.TwoFactorStatus)
+		twoFactorGroup.POST("/enroll", svc.EnrollTwoFactor)
+		twoFactorGroup.POST("/confirm", svc.ConfirmTwoFactor)
+		twoFactorGroup.POST("/recovery-codes", svc.RegenerateRecoveryCodes)
+		twoFactorGroup.POST("/disable", svc.DisableTwoFactor)
+	}
+
+	// Each route needs a permission; which roles grant it is kept in the
+	// database (see GET /api/admin/roles)
+	can := middleware.RequirePermission
 
 	adminGroup := api.Group("/admin")
-	adminGroup.Use(middleware.RequireRole("admin"))
+	adminGroup.Use(middleware.RequireTwoFactor())
 	{
-		adminGroup.POST("/approve-loan", handlers.ApproveLoan)
-		adminGroup.GET("/loan/:loan_id/agreement", handlers.DownloadLoanAgreement)
-		adminGroup.POST("/disburse-loan", handlers.DisburseLoan)
-		adminGroup.GET("/loans", handlers.ListLoans)
-
+		adminGroup.POST("/approve-loan", can("loan:approve"), svc.ApproveLoan)
+		adminGroup.GET("/loan/:loan_id/agreement", can("agreement:download"), svc.DownloadLoanAgreement)
+		adminGroup.POST("/disburse-loan", can("loan:disburse"), svc.DisburseLoan)
+		adminGroup.GET("/loans", can("loan:read"), svc.ListLoans)
+		adminGroup.GET("/loan/:loan_id/schedule", can("loan:read"), svc.GetRepaymentSchedule)
+		adminGroup.POST("/loan/:loan_id/repayments", can("repayment:record"), svc.RecordRepayment)
+		adminGroup.GET("/loan/:loan_id/history", can("loan:read"), svc.GetLoanStatusHistory)
+		adminGroup.POST("/loan/:loan_id/reject", can("loan:reject"), svc.RejectLoan)
+		adminGroup.POST("/loan/:loan_id/cancel", can("loan:cancel"), svc.CancelLoan)
+		adminGroup.POST("/invites", can("user:invite"), svc.InviteAdmin)
+		adminGroup.POST("/users/:user_id/logout-all", can("user:manage"), svc.RevokeUserSessions)
+		adminGroup.POST("/users/:user_id/2fa/reset", can("user:manage"), svc.ResetTwoFactor)
+		adminGroup.POST("/users/:user_id/unlock", can("user:manage"), svc.UnlockUser)
+		adminGroup.GET("/lockouts", can("user:manage"), svc.ListLoginLockouts)
+		adminGroup.DELETE("/lockouts/ip/:ip", can("user:manage"), svc.UnlockIP)
+		// Keys cannot be used to issue more keys
+		adminGroup.POST("/api-keys", middleware.RequireUser(), can("api_key:manage"), svc.CreateAPIKey)
+		adminGroup.GET("/api-keys", can("api_key:manage"), svc.ListAPIKeys)
+		adminGroup.DELETE("/api-keys/:key_id", can("api_key:manage"), svc.RevokeAPIKey)
+		adminGroup.GET("/roles", can("role:manage"), svc.ListRoles)
+		adminGroup.GET("/users/:user_id/roles", can("role:manage"), svc.GetUserRoles)
+		adminGroup.POST("/users/:user_id/roles", can("role:manage"), svc.GrantRole)
+		adminGroup.DELETE("/users/:user_id/roles/:role", can("role:manage"), svc.RevokeRole)
 	}
 
 	requesterGroup := api.Group("/requester")
-	requesterGroup.Use(middleware.RequireRole("requester"))
+	requesterGroup.Use(middleware.RequireTwoFactor())
 	{
-		requesterGroup.POST("/create-loan", handlers.CreateLoan)
+		requesterGroup.POST("/create-loan", can("loan:create"), svc.CreateLoan)
+		requesterGroup.GET("/loans/:loan_id/schedule", can("loan:read:own"), svc.GetRepaymentSchedule)
+		requesterGroup.POST("/loans/:loan_id/repayments", can("repayment:record:own"), svc.RecordRepayment)
+		requesterGroup.POST("/loans/:loan_id/cancel", can("loan:cancel:own"), svc.CancelLoan)
 	}
 
 	investorGroup := api.Group("/investor")
-	investorGroup.Use(middleware.RequireRole("investor"))
+	investorGroup.Use(middleware.RequireTwoFactor())
 	{
-		investorGroup.POST("/invest", handlers.InvestInLoan)
+		investorGroup.POST("/invest", can("investment:create"), svc.InvestInLoan)
+		investorGroup.GET("/loans/:loan_id/payouts", can("payout:read:own"), svc.GetInvestorPayouts)
 	}
 
 	log.Println("Server running at http://localhost:8080")
 	r.Run(":8080")
 }
+
+// runCommand handles the maintenance subcommands:
+//
+//	go run main.go migrate up
+//	go run main.go migrate down [steps]
+//	go run main.go migrate status
+//	go run main.go seed
+func runCommand(args []string) {
+	switch {
+	case args[0] == "migrate" && len(args) >= 2 && args[1] == "up":
+		if _, err := db.MigrateUp(db.DB); err != nil {
+			log.Fatal(err)
+		}
+	case args[0] == "migrate" && len(args) >= 2 && args[1] == "down":
+		steps := 1
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				log.Fatal("migrate down expects a positive number of steps")
+			}
+			steps = n
+		}
+		if _, err := db.MigrateDown(db.DB, steps); err != nil {
+			log.Fatal(err)
+		}
+	case args[0] == "migrate" && len(args) >= 2 && args[1] == "status":
+		statuses, err := db.MigrationStatuses(db.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			appliedAt := s.AppliedAt
+			if appliedAt == "" {
+				appliedAt = "pending"
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+	case args[0] == "seed":
+		if err := db.SeedDev(db.DB); err != nil {
+			log.Fatal(err)
+		}
+	case args[0] == "keygen" && len(args) == 3:
+		// Writes a new JWT signing key; see "Signing keys" in the README
+		pemBytes, err := jwtkeys.Generate(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(args[2], pemBytes, 0600); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("Unknown command %q. Usage: migrate up | migrate down [steps] | migrate status | seed | keygen <RS256|EdDSA> <file>", args[0])
+	}
+}
@@ -1,17 +1,31 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"loan-service-engine/config"
+	"loan-service-engine/repository"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func JWTAuthMiddleware() gin.HandlerFunc {
+// JWTAuthMiddleware accepts a valid access token whose jti is not on the
+// revocation list and whose session has not been logged out, or an API key
+// in the X-API-Key header.
+func JWTAuthMiddleware(store repository.Repositories) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			authenticateAPIKey(c, store, key)
+			return
+		}
+
 		// Extract Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
@@ -21,22 +35,8 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Parse and verify token
-		secret := config.JwtSecret
-		if secret == "" {
-			log.Println("JWT_SECRET is missing")
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server config error"})
-			return
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Ensure signing method is HMAC
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(secret), nil
-		})
-
+		// Parse and verify token against the key named in its kid header
+		token, err := config.JWTKeys.Parse(tokenString)
 		if err != nil || !token.Valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
@@ -48,32 +48,164 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Extract user ID and role from claims
-		userID := int(claims["sub"].(float64)) // JWT stores numbers as float64
-		role := claims["role"].(string)
+		// Every access token belongs to a session and can be revoked by its jti
+		jti, _ := claims["jti"].(string)
+		sessionID, _ := claims["sid"].(string)
+		sub, hasSub := claims["sub"].(float64) // JWT stores numbers as float64
+		expiresAt, err := claims.GetExpirationTime()
+		if jti == "" || sessionID == "" || !hasSub || err != nil || expiresAt == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			return
+		}
+
+		revoked, err := store.Revocations().IsRevoked(c, jti)
+		if err != nil {
+			log.Println("Failed to check token revocation:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		session, err := store.Sessions().Get(c, sessionID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Failed to load session:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		if revoked || err != nil || session.RevokedAt != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+
+		// Roles and permissions come from the store rather than the token,
+		// so granting or revoking a role applies to the next request
+		userID := int(sub)
+		roles, err := store.Roles().UserRoles(c, userID)
+		if err != nil {
+			log.Println("Failed to load roles:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		permissions, err := store.Roles().UserPermissions(c, userID)
+		if err != nil {
+			log.Println("Failed to load permissions:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
 
 		// Set in context
 		c.Set("userID", userID)
-		c.Set("role", role)
+		c.Set("roles", roles)
+		c.Set("permissions", permissions)
+		c.Set("jti", jti)
+		c.Set("sessionID", sessionID)
+		c.Set("tokenExpiresAt", expiresAt.Time)
+		c.Set("twoFactor", session.TwoFactorVerifiedAt != "")
 
 		c.Next()
 	}
 }
 
-func RequireRole(requiredRole string) gin.HandlerFunc {
+// APIKeyScopes are the permissions an API key can carry: the loan workflow
+// an integration automates. Managing users, roles, invites and keys needs
+// an admin who logged in, with the second factor where it is required.
+var APIKeyScopes = []string{
+	"agreement:download",
+	"loan:approve",
+	"loan:cancel",
+	"loan:disburse",
+	"loan:read",
+	"loan:reject",
+	"repayment:record",
+}
+
+// authenticateAPIKey accepts an active API key. The request acts as the
+// admin who issued the key, with only those of the admin's current
+// permissions that are in the key's scopes and in APIKeyScopes.
+func authenticateAPIKey(c *gin.Context, store repository.Repositories, key string) {
+	apiKey, err := store.APIKeys().GetByHash(c, HashAPIKey(key))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Failed to load API key:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err != nil || apiKey.RevokedAt != "" || (apiKey.ExpiresAt != "" && apiKey.ExpiresAt <= now) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return
+	}
+
+	held, err := store.Roles().UserPermissions(c, apiKey.CreatedBy)
+	if err != nil {
+		log.Println("Failed to load permissions:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+		return
+	}
+	permissions := []string{}
+	for _, p := range held {
+		if slices.Contains(apiKey.Scopes, p) && slices.Contains(APIKeyScopes, p) {
+			permissions = append(permissions, p)
+		}
+	}
+	if err := store.APIKeys().Touch(c, apiKey.ID, now); err != nil {
+		log.Println("Failed to record API key use:", err)
+	}
+
+	c.Set("userID", apiKey.CreatedBy)
+	c.Set("roles", []string{})
+	c.Set("permissions", permissions)
+	c.Set("apiKeyID", apiKey.ID)
+	c.Next()
+}
+
+// HashAPIKey is what gets stored for an API key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireUser refuses API keys, for endpoints that manage a user's own
+// login, such as logging out or changing the password.
+func RequireUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		roleValue, exists := c.Get("role")
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Role not found in token"})
+		if _, ok := c.Get("apiKeyID"); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint needs a user login, not an API key"})
 			return
 		}
+		c.Next()
+	}
+}
 
-		role, ok := roleValue.(string)
-		if !ok || role != requiredRole {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden: insufficient role"})
+// RequireTwoFactor refuses users holding a role that must use two-factor
+// authentication (TWO_FACTOR_REQUIRED_ROLES) unless they logged in with a
+// code. It goes after JWTAuthMiddleware.
+func RequireTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if config.TwoFactorRequired(c.GetStringSlice("roles")...) && !c.GetBool("twoFactor") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role: enroll at /api/2fa/enroll, then log in again"})
 			return
 		}
+		c.Next()
+	}
+}
 
+// RequirePermission refuses users none of whose roles grant the permission.
+// It goes after JWTAuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden: missing permission " + permission})
+			return
+		}
 		c.Next()
 	}
 }
+
+// HasPermission reports whether the authenticated user holds the permission.
+// Handlers use it to choose between acting on any record and only their own.
+func HasPermission(c *gin.Context, permission string) bool {
+	for _, p := range c.GetStringSlice("permissions") {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
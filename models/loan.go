@@ -1,35 +1,57 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"loan-service-engine/money"
+)
 
 type CreateLoanRequest struct {
-	BorrowerIDNumber string  `json:"borrower_id_number" binding:"required"`
-	Amount           float64 `json:"amount" binding:"required"`
-	Rate             float64 `json:"rate" binding:"required"`
-	ROI              float64 `json:"roi" binding:"required"`
+	BorrowerIDNumber string       `json:"borrower_id_number" binding:"required"`
+	Amount           money.Amount `json:"amount" binding:"required"`
+	Rate             float64      `json:"rate" binding:"required"`
+	ROI              float64      `json:"roi" binding:"required"`
+	TenureMonths     int          `json:"tenure_months" binding:"required,gte=1,lte=60"`
+	RepaymentMethod  string       `json:"repayment_method" binding:"omitempty,oneof=flat annuity"`
+	// FundingWindowDays defaults to config.FundingWindowDays when omitted.
+	FundingWindowDays int `json:"funding_window_days" binding:"omitempty,gte=1,lte=90"`
 }
 
 // Loan represents a full loan record pulled from the DB.
 type Loan struct {
-	ID                 int     `json:"id"`
-	BorrowerIDNumber   string  `json:"borrower_id_number"`
-	Amount             float64 `json:"amount"`
-	Rate               float64 `json:"rate"`
-	ROI                float64 `json:"roi"`
-	Status             string  `json:"status"`
-	RequesterID        int     `json:"requester_id"`
-	AgreementLetterURL string  `json:"agreement_letter_url,omitempty"`
+	ID                 int          `json:"id"`
+	BorrowerIDNumber   string       `json:"borrower_id_number"`
+	Amount             money.Amount `json:"amount"`
+	Rate               float64      `json:"rate"`
+	ROI                float64      `json:"roi"`
+	TenureMonths       int          `json:"tenure_months"`
+	RepaymentMethod    string       `json:"repayment_method"`
+	Status             string       `json:"status"`
+	FundingWindowDays  int          `json:"funding_window_days"`
+	FundingDeadline    string       `json:"funding_deadline,omitempty"`
+	RequesterID        int          `json:"requester_id"`
+	AgreementLetterURL string       `json:"agreement_letter_url,omitempty"`
 }
 
 // LoanResponse is the version sent back to clients
 // (you can remove internal fields like requester_id if needed).
 type LoanResponse struct {
-	ID               int     `json:"id"`
-	BorrowerIDNumber string  `json:"borrower_id_number"`
-	Amount           float64 `json:"amount"`
-	Rate             float64 `json:"rate"`
-	ROI              float64 `json:"roi"`
-	Status           string  `json:"status"`
+	ID               int          `json:"id"`
+	BorrowerIDNumber string       `json:"borrower_id_number"`
+	Amount           money.Amount `json:"amount"`
+	Rate             float64      `json:"rate"`
+	ROI              float64      `json:"roi"`
+	TenureMonths     int          `json:"tenure_months"`
+	RepaymentMethod  string       `json:"repayment_method"`
+	Status           string       `json:"status"`
+}
+
+type RejectLoanRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type CancelLoanRequest struct {
+	Reason string `json:"reason"`
 }
 
 type ApprovalRequest struct {
@@ -39,35 +61,90 @@ type ApprovalRequest struct {
 }
 
 type InvestmentRequest struct {
-	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Amount money.Amount `json:"amount" binding:"required,gt=0"`
 }
 
+// LoanDetails is a loan as shown to one user. Fields the user may not see
+// are left empty and omitted.
 type LoanDetails struct {
 	ID               int              `json:"id"`
-	BorrowerIDNumber string           `json:"borrower_id_number"`
-	Amount           float64          `json:"amount"`
+	BorrowerIDNumber string           `json:"borrower_id_number,omitempty"`
+	Amount           money.Amount     `json:"amount"`
 	Rate             float64          `json:"rate"`
 	ROI              float64          `json:"roi"`
+	TenureMonths     int              `json:"tenure_months"`
+	RepaymentMethod  string           `json:"repayment_method"`
+	FundingDeadline  string           `json:"funding_deadline,omitempty"`
 	Status           string           `json:"status"`
-	Requester        string           `json:"requester"`
+	Requester        string           `json:"requester,omitempty"`
 	Approval         ApprovalInfo     `json:"approval,omitempty"`
 	Investments      []InvestmentInfo `json:"investments,omitempty"`
 	Disbursement     DisbursementInfo `json:"disbursement,omitempty"`
 }
 
+// ApprovalInfo records the field validator's visit. PhotoTakenAt and the
+// location come from the proof photo's EXIF data, when it had any.
 type ApprovalInfo struct {
-	ValidatorID string `json:"validator_id"`
-	ApprovedAt  string `json:"approved_at"`
-	ProofURL    string `json:"proof_url"`
+	ValidatorID  string   `json:"validator_id,omitempty"`
+	ApprovedAt   string   `json:"approved_at"`
+	ProofURL     string   `json:"proof_url,omitempty"`
+	ThumbnailURL string   `json:"thumbnail_url,omitempty"`
+	PhotoTakenAt string   `json:"photo_taken_at,omitempty"`
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+}
+
+// Investment is a stored investment together with the investor's username and email.
+type Investment struct {
+	ID             int          `json:"id"`
+	LoanID         int          `json:"loan_id"`
+	InvestorID     int          `json:"investor_id"`
+	Investor       string       `json:"investor"`
+	Email          string       `json:"email"`
+	Amount         money.Amount `json:"amount"`
+	InvestmentDate string       `json:"investment_date"`
+	Status         string       `json:"status"`
+	RefundedAt     string       `json:"refunded_at,omitempty"`
 }
 
+// InvestmentInfo names the investor only to admins and to the investor.
 type InvestmentInfo struct {
-	Investor string  `json:"investor"`
-	Amount   float64 `json:"amount"`
+	Investor string       `json:"investor,omitempty"`
+	Amount   money.Amount `json:"amount"`
+	Status   string       `json:"status"`
+}
+
+// Scan states of a Document.
+const (
+	ScanClean = "clean"
+	// ScanQuarantined documents could not be scanned yet. They cannot be
+	// downloaded and block the loan transition they were uploaded for.
+	ScanQuarantined = "quarantined"
+)
+
+// Document is an uploaded file, stored under a key derived from its SHA-256.
+type Document struct {
+	SHA256      string `json:"sha256"`
+	Key         string `json:"-"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	CreatedAt   string `json:"created_at"`
+	ScanStatus  string `json:"scan_status"`
+	ScannedAt   string `json:"scanned_at,omitempty"`
 }
 
 type DisbursementInfo struct {
-	OfficerID          string `json:"officer_id"`
+	OfficerID          string `json:"officer_id,omitempty"`
 	DisbursedAt        string `json:"disbursed_at"`
-	SignedAgreementURL string `json:"signed_agreement_url"`
+	SignedAgreementURL string `json:"signed_agreement_url,omitempty"`
+}
+
+// StatusChange is one row of a loan's status history.
+type StatusChange struct {
+	FromStatus string `json:"from_status,omitempty"`
+	ToStatus   string `json:"to_status"`
+	ActorID    int    `json:"actor_id,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	ChangedAt  string `json:"changed_at"`
 }
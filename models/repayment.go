@@ -0,0 +1,81 @@
+package models
+
+import "loan-service-engine/money"
+
+// Installment is a single row of a loan's repayment schedule.
+type Installment struct {
+	ID                 int          `json:"-"`
+	Number             int          `json:"installment_number"`
+	DueDate            string       `json:"due_date"`
+	Principal          money.Amount `json:"principal"`
+	Interest           money.Amount `json:"interest"`
+	Fee                money.Amount `json:"fee"`
+	TotalDue           money.Amount `json:"total_due"`
+	OutstandingBalance money.Amount `json:"outstanding_balance"`
+	PrincipalPaid      money.Amount `json:"principal_paid"`
+	InterestPaid       money.Amount `json:"interest_paid"`
+	FeePaid            money.Amount `json:"fee_paid"`
+	Status             string       `json:"status"`
+}
+
+type RepaymentSchedule struct {
+	LoanID             int           `json:"loan_id"`
+	RepaymentMethod    string        `json:"repayment_method"`
+	TenureMonths       int           `json:"tenure_months"`
+	OutstandingBalance money.Amount  `json:"outstanding_balance"`
+	Installments       []Installment `json:"installments"`
+}
+
+type RepaymentRequest struct {
+	Amount money.Amount `json:"amount" binding:"required,gt=0"`
+	PaidAt string       `json:"paid_at"` // YYYY-MM-DD, defaults to today
+}
+
+// Allocation is the part of a repayment applied to one installment.
+type Allocation struct {
+	InstallmentNumber int          `json:"installment_number"`
+	Fee               money.Amount `json:"fee"`
+	Interest          money.Amount `json:"interest"`
+	Principal         money.Amount `json:"principal"`
+}
+
+// Repayment is a stored repayment and how it was split.
+type Repayment struct {
+	ID               int          `json:"id"`
+	LoanID           int          `json:"loan_id"`
+	Amount           money.Amount `json:"amount"`
+	FeePortion       money.Amount `json:"fee_portion"`
+	InterestPortion  money.Amount `json:"interest_portion"`
+	PrincipalPortion money.Amount `json:"principal_portion"`
+	PlatformRevenue  money.Amount `json:"platform_revenue"`
+	PaidAt           string       `json:"paid_at"`
+	RecordedBy       int          `json:"recorded_by"`
+}
+
+// Payout is a stored investor payout from a single repayment.
+type Payout struct {
+	RepaymentID int          `json:"repayment_id"`
+	LoanID      int          `json:"loan_id"`
+	InvestorID  int          `json:"investor_id"`
+	Principal   money.Amount `json:"principal"`
+	Interest    money.Amount `json:"interest"`
+	Amount      money.Amount `json:"amount"`
+	PaidAt      string       `json:"paid_at"`
+}
+
+type PayoutInfo struct {
+	RepaymentID int          `json:"repayment_id"`
+	Principal   money.Amount `json:"principal"`
+	Interest    money.Amount `json:"interest"`
+	Amount      money.Amount `json:"amount"`
+	PaidAt      string       `json:"paid_at"`
+}
+
+type InvestorPayouts struct {
+	LoanID         int          `json:"loan_id"`
+	Invested       money.Amount `json:"invested"`
+	TotalPrincipal money.Amount `json:"total_principal"`
+	TotalInterest  money.Amount `json:"total_interest"`
+	TotalReceived  money.Amount `json:"total_received"`
+	Payouts        []PayoutInfo `json:"payouts"`
+}
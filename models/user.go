@@ -0,0 +1,97 @@
+package models
+
+// User is a stored account. PasswordHash is the bcrypt hash and is never sent to clients.
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+	// Role is the role the account registered with. Creating a user grants
+	// it; what the user may do depends on all the roles they hold.
+	Role            string `json:"role"`
+	EmailVerifiedAt string `json:"email_verified_at,omitempty"` // empty until the email is verified
+}
+
+// UserToken is a single-use token mailed to a user, such as an email
+// verification link. Only the SHA-256 of the token is stored.
+type UserToken struct {
+	UserID    int
+	Purpose   string
+	TokenHash string
+	ExpiresAt string
+	CreatedAt string
+}
+
+// Invite lets the holder of the mailed token register an admin account for Email.
+type Invite struct {
+	Email     string
+	TokenHash string
+	InvitedBy int
+	ExpiresAt string
+	CreatedAt string
+}
+
+// Session is one login. It stays valid through refresh token rotation
+// until it is revoked.
+type Session struct {
+	ID        string
+	UserID    int
+	CreatedAt string
+	RevokedAt string // empty while the session is active
+	// TwoFactorVerifiedAt is set when the login passed the second factor.
+	TwoFactorVerifiedAt string
+}
+
+// RefreshToken can be exchanged once for a new access and refresh token in
+// the same session. Only the SHA-256 of the token is stored.
+type RefreshToken struct {
+	SessionID string
+	TokenHash string
+	ExpiresAt string
+	CreatedAt string
+	UsedAt    string // empty until exchanged
+}
+
+// TOTPCredential is a user's authenticator app secret. It only protects
+// logins once ConfirmedAt is set.
+type TOTPCredential struct {
+	UserID      int
+	Secret      string
+	CreatedAt   string
+	ConfirmedAt string // empty until the first code is verified
+	// LastUsedStep is the time step of the last accepted code, which
+	// cannot be used again.
+	LastUsedStep int64
+}
+
+// LoginFailure counts recent failed logins for a username or a client IP.
+type LoginFailure struct {
+	Scope        string `json:"scope"` // "username" or "ip"
+	Subject      string `json:"subject"`
+	Failures     int    `json:"failures"`
+	LastFailedAt string `json:"last_failed_at"`
+	LockedUntil  string `json:"locked_until,omitempty"` // empty unless locked out
+}
+
+// Role is a named set of permissions such as "loan:approve".
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// APIKey lets an integration call the API without a login. It acts for the
+// admin who issued it, limited to Scopes. KeyHash is the SHA-256 of the key,
+// which is only shown once.
+type APIKey struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	KeyHash    string   `json:"-"`
+	Scopes     []string `json:"scopes"`
+	CreatedBy  int      `json:"created_by"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,193 @@
+// Package money represents Rupiah amounts exactly as an integer number of sen
+// (1/100 Rupiah), so sums and comparisons never suffer from floating point
+// error. Anything that has to be derived with a rate (interest, shares) is
+// rounded half away from zero to the nearest sen.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a Rupiah value in sen.
+type Amount int64
+
+const (
+	Sen    Amount = 1
+	Rupiah Amount = 100
+)
+
+// FromRupiah converts a whole Rupiah value.
+func FromRupiah(r int64) Amount {
+	return Amount(r) * Rupiah
+}
+
+// FromFloat converts a Rupiah value held as a float, rounding to the nearest sen.
+// Only meant for legacy REAL data and rate based calculations.
+func FromFloat(r float64) Amount {
+	return Amount(math.Round(r * float64(Rupiah)))
+}
+
+// Parse reads a decimal Rupiah value such as "1000000", "1500.5" or "12.34".
+// More than two decimal places cannot be represented and are rejected.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("money: empty amount")
+	}
+
+	neg := false
+	if s[0] == '-' || s[0] == '+' {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || (hasFrac && frac == "") {
+		return 0, fmt.Errorf("money: invalid amount %q", s)
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("money: %q has more than two decimal places", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("money: invalid amount %q", s)
+	}
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || strings.ContainsAny(frac, "+-") {
+		return 0, fmt.Errorf("money: invalid amount %q", s)
+	}
+	if w > (math.MaxInt64-f)/int64(Rupiah) {
+		return 0, fmt.Errorf("money: amount %q out of range", s)
+	}
+
+	a := Amount(w*int64(Rupiah) + f)
+	if neg {
+		a = -a
+	}
+	return a, nil
+}
+
+// String formats the amount with two decimals, e.g. "1000000.00".
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/int64(Rupiah), v%int64(Rupiah))
+}
+
+// Float returns the amount in Rupiah as a float, for display and rate maths only.
+func (a Amount) Float() float64 {
+	return float64(a) / float64(Rupiah)
+}
+
+// Percent returns pct percent of the amount, rounded to the nearest sen.
+func (a Amount) Percent(pct float64) Amount {
+	return Amount(math.Round(float64(a) * pct / 100))
+}
+
+// Mul scales the amount by a factor, rounded to the nearest sen.
+func (a Amount) Mul(f float64) Amount {
+	return Amount(math.Round(float64(a) * f))
+}
+
+// MulDiv returns a * num / den rounded half away from zero, without
+// overflowing on large intermediate products. Used for pro-rata shares.
+func (a Amount) MulDiv(num, den int64) Amount {
+	if den == 0 {
+		panic("money: division by zero")
+	}
+	p := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(num))
+	d := big.NewInt(den)
+
+	neg := (p.Sign() < 0) != (d.Sign() < 0)
+	p.Abs(p)
+	d.Abs(d)
+
+	q, r := new(big.Int).QuoRem(p, d, new(big.Int))
+	if new(big.Int).Mul(r, big.NewInt(2)).Cmp(d) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return Amount(q.Int64())
+}
+
+// MarshalJSON writes the amount as a JSON number in Rupiah with two decimals.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string in Rupiah.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else if strings.ContainsAny(s, "eE") {
+		// Exponent notation: go through a float and round to the nearest sen
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("money: invalid amount %s", s)
+		}
+		*a = FromFloat(f)
+		return nil
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value stores the amount as an integer number of sen.
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+// Scan reads an integer number of sen. A REAL result (e.g. from arithmetic
+// done in SQL) is rounded to the nearest sen.
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		*a = Amount(v)
+	case float64:
+		*a = Amount(math.Round(v))
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	case nil:
+		*a = 0
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
+
+func (a *Amount) scanString(s string) error {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("money: cannot scan %q", s)
+	}
+	*a = Amount(v)
+	return nil
+}
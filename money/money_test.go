@@ -0,0 +1,78 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]Amount{
+		"1000000":  100000000,
+		"1500.5":   150050,
+		"12.34":    1234,
+		"-0.01":    -1,
+		"0":        0,
+		" 100.00 ": 10000,
+	}
+	for in, want := range cases {
+		got, err := Parse(in)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Parse(%q) = %d, want %d", in, got, want)
+		}
+	}
+
+	for _, in := range []string{"", "1.234", "abc", "1.", ".5", "1.-5"} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) should fail", in)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	if s := Amount(100000050).String(); s != "1000000.50" {
+		t.Errorf("Unexpected format %s", s)
+	}
+	if s := Amount(-5).String(); s != "-0.05" {
+		t.Errorf("Unexpected format %s", s)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	var v struct {
+		Amount Amount `json:"amount"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 0.1}`), &v); err != nil || v.Amount != 10 {
+		t.Errorf("Expected 0.1 to parse exactly as 10 sen, got %d (%v)", v.Amount, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount": "250000.25"}`), &v); err != nil || v.Amount != 25000025 {
+		t.Errorf("Expected string amount to parse, got %d (%v)", v.Amount, err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount": 1e6}`), &v); err != nil || v.Amount != FromRupiah(1000000) {
+		t.Errorf("Expected exponent amount to parse, got %d (%v)", v.Amount, err)
+	}
+
+	out, _ := json.Marshal(v)
+	if string(out) != `{"amount":1000000.00}` {
+		t.Errorf("Unexpected JSON %s", out)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	// 1% of 0.50 is half a sen: rounds away from zero
+	if got := Amount(50).Percent(1); got != 1 {
+		t.Errorf("Percent should round half up, got %d", got)
+	}
+	// One third of 1,000,000.00
+	if got := FromRupiah(1000000).MulDiv(1, 3); got != 33333333 {
+		t.Errorf("Unexpected third %d", got)
+	}
+	// Large intermediate products must not overflow
+	big := FromRupiah(100000000)
+	if got := big.MulDiv(int64(big), int64(big)); got != big {
+		t.Errorf("MulDiv overflowed: %d", got)
+	}
+}
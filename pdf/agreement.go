@@ -1,25 +1,31 @@
 package pdf
 
 import (
-	"database/sql"
+	"bytes"
+	"context"
 	"fmt"
-	"loan-service-engine/db"
-	"os"
-	"path/filepath"
+	"loan-service-engine/money"
+	"loan-service-engine/storage"
 	"time"
 
 	"github.com/jung-kurt/gofpdf"
 )
 
-func GenerateAgreementPDF(loanID int, investorName string, amount float64) (string, error) {
-	filename := fmt.Sprintf("agreement_loan%d_%s.pdf", loanID, investorName)
-	outputDir := "uploads"
-	outputPath := filepath.Join(outputDir, filename)
+// AgreementKey is where GenerateAgreementPDF stores an investor's
+// agreement. It is keyed by the investor's ID, not their username, so no
+// username can name another agreement.
+func AgreementKey(loanID, investorID int) string {
+	return fmt.Sprintf("agreements/loan%d/investor-%d.pdf", loanID, investorID)
+}
 
-	err := os.MkdirAll(outputDir, os.ModePerm)
-	if err != nil {
-		return "", fmt.Errorf("failed to create output dir: %v", err)
-	}
+// BorrowerAgreementKey is where the borrower's copy of the agreement is stored.
+func BorrowerAgreementKey(loanID int) string {
+	return fmt.Sprintf("agreements/loan%d/borrower.pdf", loanID)
+}
+
+// GenerateAgreementPDF stores an investor's agreement and returns its key.
+func GenerateAgreementPDF(ctx context.Context, files storage.Storage, loanID, investorID int, investorName string, amount money.Amount) (string, error) {
+	key := AgreementKey(loanID, investorID)
 
 	pdf := gofpdf.New("P", "mm", "A4", "")
 	pdf.AddPage()
@@ -32,7 +38,7 @@ func GenerateAgreementPDF(loanID int, investorName string, amount float64) (stri
 	content := fmt.Sprintf(`
 Date: %s
 
-This document serves as an agreement that %s has invested an amount of %.2f into Loan #%d.
+This document serves as an agreement that %s has invested an amount of Rp %s into Loan #%d.
 
 The agreement becomes effective once the loan reaches its funding goal.
 
@@ -44,12 +50,21 @@ This document is automatically generated by the Loan Service System.
 		pdf.Ln(8)
 	}
 
-	err = pdf.OutputFileAndClose(outputPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate pdf: %v", err)
+	if err := store(ctx, files, key, pdf); err != nil {
+		return "", err
 	}
+	return key, nil
+}
 
-	return "/" + outputPath, nil
+func store(ctx context.Context, files storage.Storage, key string, pdf *gofpdf.Fpdf) error {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return fmt.Errorf("failed to generate pdf: %v", err)
+	}
+	if err := files.Put(ctx, key, &buf, "application/pdf"); err != nil {
+		return fmt.Errorf("failed to store pdf: %v", err)
+	}
+	return nil
 }
 
 func splitLines(text string) []string {
@@ -77,31 +92,20 @@ func splitByNewline(text string) []string {
 	return lines
 }
 
-func GenerateBorrowerAgreementPDF(loanID int, path string) error {
-	var (
-		borrowerName, borrowerEmail, nik string
-		amount, rate, roi                float64
-	)
-
-	err := db.DB.QueryRow(`
-		SELECT u.username, u.email, l.borrower_id_number, l.amount, l.rate, l.roi
-		FROM loans l
-		JOIN users u ON u.id = l.requester_id
-		WHERE l.id = ?
-	`, loanID).Scan(&borrowerName, &borrowerEmail, &nik, &amount, &rate, &roi)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("loan or borrower not found")
-		}
-		return fmt.Errorf("DB error: %v", err)
-	}
-
-	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to create output dir: %v", err)
-	}
+// BorrowerAgreement is what goes on the borrower's copy of the agreement.
+type BorrowerAgreement struct {
+	LoanID        int
+	BorrowerName  string
+	BorrowerEmail string
+	NIK           string
+	Amount        money.Amount
+	Rate          float64
+	ROI           float64
+}
 
+// GenerateBorrowerAgreementPDF stores the borrower's copy under
+// BorrowerAgreementKey.
+func GenerateBorrowerAgreementPDF(ctx context.Context, files storage.Storage, a BorrowerAgreement) error {
 	pdf := gofpdf.New("P", "mm", "A4", "")
 	pdf.AddPage()
 	pdf.SetFont("Arial", "", 12)
@@ -122,7 +126,7 @@ Email : %s
 NIK   : %s
 
 Loan Terms:
-Amount      : Rp %.2f
+Amount      : Rp %s
 Interest    : %.2f%%
 Expected ROI: %.2f%%
 
@@ -133,12 +137,12 @@ Signed by Borrower: _________________________
 
 Date: _______________
 
-`, date, loanID, borrowerName, borrowerEmail, nik, amount, rate, roi)
+`, date, a.LoanID, a.BorrowerName, a.BorrowerEmail, a.NIK, a.Amount, a.Rate, a.ROI)
 
 	for _, line := range splitByNewline(content) {
 		pdf.Cell(0, 10, line)
 		pdf.Ln(8)
 	}
 
-	return pdf.OutputFileAndClose(path)
+	return store(ctx, files, BorrowerAgreementKey(a.LoanID), pdf)
 }
@@ -0,0 +1,72 @@
+package repayment
+
+import (
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+)
+
+const (
+	ComponentFee       = "fee"
+	ComponentInterest  = "interest"
+	ComponentPrincipal = "principal"
+)
+
+// Remaining returns what is still owed on an installment across all components.
+func Remaining(inst models.Installment) money.Amount {
+	return (inst.Fee - inst.FeePaid) + (inst.Interest - inst.InterestPaid) + (inst.Principal - inst.PrincipalPaid)
+}
+
+// Allocate applies amount to installments oldest first. Within an installment
+// the components are settled in waterfall order (e.g. fee, interest, principal)
+// before moving on to the next one. Installments are updated in place and the
+// unallocated remainder is returned.
+func Allocate(amount money.Amount, installments []models.Installment, waterfall []string) ([]models.Allocation, money.Amount) {
+	var allocations []models.Allocation
+	left := amount
+
+	for i := range installments {
+		if left <= 0 {
+			break
+		}
+		inst := &installments[i]
+		if Remaining(*inst) <= 0 {
+			continue
+		}
+
+		alloc := models.Allocation{InstallmentNumber: inst.Number}
+		for _, component := range waterfall {
+			var due, paid, applied *money.Amount
+			switch component {
+			case ComponentFee:
+				due, paid, applied = &inst.Fee, &inst.FeePaid, &alloc.Fee
+			case ComponentInterest:
+				due, paid, applied = &inst.Interest, &inst.InterestPaid, &alloc.Interest
+			case ComponentPrincipal:
+				due, paid, applied = &inst.Principal, &inst.PrincipalPaid, &alloc.Principal
+			default:
+				continue
+			}
+
+			portion := *due - *paid
+			if portion > left {
+				portion = left
+			}
+			if portion <= 0 {
+				continue
+			}
+			*paid += portion
+			*applied = portion
+			left -= portion
+		}
+
+		switch {
+		case Remaining(*inst) <= 0:
+			inst.Status = "paid"
+		case inst.FeePaid+inst.InterestPaid+inst.PrincipalPaid > 0:
+			inst.Status = "partial"
+		}
+		allocations = append(allocations, alloc)
+	}
+
+	return allocations, left
+}
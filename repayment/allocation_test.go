@@ -0,0 +1,44 @@
+package repayment
+
+import (
+	"testing"
+
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+)
+
+func TestAllocateFollowsWaterfall(t *testing.T) {
+	installments := []models.Installment{
+		{Number: 1, Principal: money.FromRupiah(100000), Interest: money.FromRupiah(10000), Fee: money.FromRupiah(5000), Status: "pending"},
+		{Number: 2, Principal: money.FromRupiah(100000), Interest: money.FromRupiah(10000), Status: "pending"},
+	}
+
+	allocations, left := Allocate(money.FromRupiah(120000), installments, []string{ComponentFee, ComponentInterest, ComponentPrincipal})
+	if left != 0 {
+		t.Errorf("Expected everything to be allocated, %s left", left)
+	}
+	if len(allocations) != 2 {
+		t.Fatalf("Expected 2 allocations, got %d", len(allocations))
+	}
+	if allocations[0].Fee != money.FromRupiah(5000) || allocations[0].Interest != money.FromRupiah(10000) || allocations[0].Principal != money.FromRupiah(100000) {
+		t.Errorf("Unexpected first allocation: %+v", allocations[0])
+	}
+	if installments[0].Status != "paid" {
+		t.Errorf("First installment should be paid, got %s", installments[0].Status)
+	}
+	// Remaining 5,000 goes to interest of the second installment
+	if allocations[1].Interest != money.FromRupiah(5000) || allocations[1].Principal != 0 || installments[1].Status != "partial" {
+		t.Errorf("Unexpected second allocation: %+v (%s)", allocations[1], installments[1].Status)
+	}
+}
+
+func TestAllocatePrincipalFirst(t *testing.T) {
+	installments := []models.Installment{
+		{Number: 1, Principal: money.FromRupiah(100000), Interest: money.FromRupiah(10000), Status: "pending"},
+	}
+
+	allocations, _ := Allocate(money.FromRupiah(50000), installments, []string{ComponentPrincipal, ComponentInterest, ComponentFee})
+	if allocations[0].Principal != money.FromRupiah(50000) || allocations[0].Interest != 0 {
+		t.Errorf("Expected principal to be paid first, got %+v", allocations[0])
+	}
+}
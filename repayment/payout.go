@@ -0,0 +1,60 @@
+package repayment
+
+import (
+	"sort"
+
+	"loan-service-engine/money"
+)
+
+// Holding is an investor's share of a loan's principal.
+type Holding struct {
+	InvestorID int
+	Amount     money.Amount
+}
+
+// Payout is what one investor receives out of a single repayment.
+type Payout struct {
+	InvestorID int
+	Principal  money.Amount
+	Interest   money.Amount
+}
+
+// DistributePayouts splits the principal and interest parts of a repayment
+// between a loan's investors in proportion to what they funded. Investors earn
+// interest at the loan ROI; the spread between rate and ROI, plus any fees,
+// is kept by the platform and returned as platformRevenue.
+func DistributePayouts(principal, interest, fee money.Amount, rate, roi float64, holdings []Holding) (payouts []Payout, platformRevenue money.Amount) {
+	var total money.Amount
+	for _, h := range holdings {
+		total += h.Amount
+	}
+	if total <= 0 || len(holdings) == 0 {
+		return nil, principal + interest + fee
+	}
+
+	investorInterest := interest
+	if rate > 0 {
+		investorInterest = interest.Mul(roi / rate)
+	}
+
+	// Stable order so the rounding remainder always lands on the same investor
+	sorted := make([]Holding, len(holdings))
+	copy(sorted, holdings)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].InvestorID < sorted[j].InvestorID })
+
+	principalLeft, interestLeft := principal, investorInterest
+	for i, h := range sorted {
+		p := Payout{InvestorID: h.InvestorID}
+		if i == len(sorted)-1 {
+			p.Principal, p.Interest = principalLeft, interestLeft
+		} else {
+			p.Principal = principal.MulDiv(int64(h.Amount), int64(total))
+			p.Interest = investorInterest.MulDiv(int64(h.Amount), int64(total))
+			principalLeft -= p.Principal
+			interestLeft -= p.Interest
+		}
+		payouts = append(payouts, p)
+	}
+
+	return payouts, interest - investorInterest + fee
+}
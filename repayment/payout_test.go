@@ -0,0 +1,26 @@
+package repayment
+
+import (
+	"testing"
+
+	"loan-service-engine/money"
+)
+
+func TestDistributePayouts(t *testing.T) {
+	holdings := []Holding{{InvestorID: 5, Amount: money.FromRupiah(250000)}, {InvestorID: 4, Amount: money.FromRupiah(750000)}}
+
+	payouts, platform := DistributePayouts(money.FromRupiah(100000), money.FromRupiah(12000), money.FromRupiah(5000), 12, 10, holdings)
+	if len(payouts) != 2 {
+		t.Fatalf("Expected 2 payouts, got %d", len(payouts))
+	}
+	if payouts[0].InvestorID != 4 || payouts[0].Principal != money.FromRupiah(75000) || payouts[0].Interest != money.FromRupiah(7500) {
+		t.Errorf("Unexpected payout for investor 4: %+v", payouts[0])
+	}
+	if payouts[1].Principal != money.FromRupiah(25000) || payouts[1].Interest != money.FromRupiah(2500) {
+		t.Errorf("Unexpected payout for investor 5: %+v", payouts[1])
+	}
+	// 2,000 interest spread plus the 5,000 fee
+	if platform != money.FromRupiah(7000) {
+		t.Errorf("Expected platform revenue 7000, got %s", platform)
+	}
+}
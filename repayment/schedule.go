@@ -0,0 +1,110 @@
+package repayment
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+)
+
+const (
+	MethodFlat    = "flat"
+	MethodAnnuity = "annuity"
+)
+
+// GenerateSchedule builds the monthly installment plan for a disbursed loan.
+// annualRate is the loan rate in percent (e.g. 12 for 12% p.a.) and the first
+// installment falls due one month after disbursedAt.
+func GenerateSchedule(principal money.Amount, annualRate float64, tenureMonths int, method string, disbursedAt time.Time) ([]models.Installment, error) {
+	if principal <= 0 {
+		return nil, fmt.Errorf("principal must be positive")
+	}
+	if tenureMonths <= 0 {
+		return nil, fmt.Errorf("tenure must be at least one month")
+	}
+
+	monthlyRatePct := annualRate / 12
+
+	switch method {
+	case MethodFlat, "":
+		return flatSchedule(principal, monthlyRatePct, tenureMonths, disbursedAt), nil
+	case MethodAnnuity:
+		return annuitySchedule(principal, monthlyRatePct, tenureMonths, disbursedAt), nil
+	default:
+		return nil, fmt.Errorf("unknown repayment method %q", method)
+	}
+}
+
+// Flat: interest is charged on the original principal every month and the
+// principal is split evenly. The last installment absorbs rounding leftovers.
+func flatSchedule(principal money.Amount, monthlyRatePct float64, n int, start time.Time) []models.Installment {
+	interest := principal.Percent(monthlyRatePct)
+	principalPart := principal.MulDiv(1, int64(n))
+
+	balance := principal
+	installments := make([]models.Installment, 0, n)
+	for i := 1; i <= n; i++ {
+		p := principalPart
+		if i == n {
+			p = balance
+		}
+		balance -= p
+		installments = append(installments, models.Installment{
+			Number:             i,
+			DueDate:            addMonths(start, i).Format("2006-01-02"),
+			Principal:          p,
+			Interest:           interest,
+			TotalDue:           p + interest,
+			OutstandingBalance: balance,
+			Status:             "pending",
+		})
+	}
+	return installments
+}
+
+// Annuity: every installment has the same total; interest is charged on the
+// remaining balance so the principal share grows over time.
+func annuitySchedule(principal money.Amount, monthlyRatePct float64, n int, start time.Time) []models.Installment {
+	r := monthlyRatePct / 100
+	var payment money.Amount
+	if r == 0 {
+		payment = principal.MulDiv(1, int64(n))
+	} else {
+		payment = principal.Mul(r / (1 - math.Pow(1+r, -float64(n))))
+	}
+
+	balance := principal
+	installments := make([]models.Installment, 0, n)
+	for i := 1; i <= n; i++ {
+		interest := balance.Percent(monthlyRatePct)
+		p := payment - interest
+		if i == n {
+			p = balance
+		}
+		balance -= p
+		installments = append(installments, models.Installment{
+			Number:             i,
+			DueDate:            addMonths(start, i).Format("2006-01-02"),
+			Principal:          p,
+			Interest:           interest,
+			TotalDue:           p + interest,
+			OutstandingBalance: balance,
+			Status:             "pending",
+		})
+	}
+	return installments
+}
+
+// addMonths moves t forward by n months, clamping to the last day of the
+// target month (31 Jan + 1 month = 28/29 Feb instead of early March).
+func addMonths(t time.Time, n int) time.Time {
+	firstOfTarget := time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	lastDay := firstOfTarget.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(firstOfTarget.Year(), firstOfTarget.Month(), day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,70 @@
+package repayment
+
+import (
+	"testing"
+	"time"
+
+	"loan-service-engine/money"
+)
+
+func TestFlatSchedule(t *testing.T) {
+	start := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	installments, err := GenerateSchedule(money.FromRupiah(1000000), 12, 3, MethodFlat, start)
+	if err != nil {
+		t.Fatalf("GenerateSchedule failed: %v", err)
+	}
+	if len(installments) != 3 {
+		t.Fatalf("Expected 3 installments, got %d", len(installments))
+	}
+
+	var principal money.Amount
+	for _, inst := range installments {
+		if inst.Interest != money.FromRupiah(10000) {
+			t.Errorf("Installment %d: expected flat interest 10000, got %s", inst.Number, inst.Interest)
+		}
+		principal += inst.Principal
+	}
+	if principal != money.FromRupiah(1000000) {
+		t.Errorf("Principal parts should add up to the loan amount, got %s", principal)
+	}
+	// 1,000,000 / 3 does not divide evenly; the last installment takes the extra sen
+	if installments[0].Principal.String() != "333333.33" || installments[2].Principal.String() != "333333.34" {
+		t.Errorf("Unexpected principal split %s / %s", installments[0].Principal, installments[2].Principal)
+	}
+	if installments[2].OutstandingBalance != 0 {
+		t.Errorf("Last installment should clear the balance, got %s", installments[2].OutstandingBalance)
+	}
+
+	// 31 Jan + 1 month clamps to the end of February
+	if installments[0].DueDate != "2025-02-28" {
+		t.Errorf("Unexpected first due date %s", installments[0].DueDate)
+	}
+}
+
+func TestAnnuitySchedule(t *testing.T) {
+	start := time.Date(2025, 6, 26, 0, 0, 0, 0, time.UTC)
+	installments, err := GenerateSchedule(money.FromRupiah(1000000), 12, 12, MethodAnnuity, start)
+	if err != nil {
+		t.Fatalf("GenerateSchedule failed: %v", err)
+	}
+
+	// Standard annuity payment for 1,000,000 at 1% monthly over 12 months
+	if installments[0].TotalDue.String() != "88848.79" {
+		t.Errorf("Unexpected installment amount %s", installments[0].TotalDue)
+	}
+	if installments[0].Interest != money.FromRupiah(10000) {
+		t.Errorf("First month interest should be 10000, got %s", installments[0].Interest)
+	}
+	if installments[11].Interest >= installments[0].Interest {
+		t.Error("Interest share should decrease over an annuity schedule")
+	}
+	if installments[11].OutstandingBalance != 0 {
+		t.Errorf("Last installment should clear the balance, got %s", installments[11].OutstandingBalance)
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	if _, err := GenerateSchedule(money.FromRupiah(1000000), 12, 12, "balloon", time.Now()); err == nil {
+		t.Error("Expected error for unknown repayment method")
+	}
+}
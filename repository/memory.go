@@ -0,0 +1,1161 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+)
+
+// MemoryStore keeps everything in process memory. It behaves like SQLStore,
+// including transactions and unique constraints, and is meant for unit tests.
+//
+// Transactions hold the store's lock until they finish, so a goroutine must
+// not use the store itself while it has a Tx open.
+type MemoryStore struct {
+	memRepositories
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{memRepositories{state: &memState{data: newMemData()}}}
+}
+
+func (s *MemoryStore) Begin(ctx context.Context) (Tx, error) {
+	s.state.mu.Lock()
+	return &memTx{
+		memRepositories: memRepositories{state: s.state, inTx: true},
+		snapshot:        s.state.data.clone(),
+	}, nil
+}
+
+type memTx struct {
+	memRepositories
+	snapshot *memData
+	done     bool
+}
+
+func (t *memTx) Commit() error {
+	if t.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	t.done = true
+	t.state.mu.Unlock()
+	return nil
+}
+
+func (t *memTx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.state.data = t.snapshot
+	t.state.mu.Unlock()
+	return nil
+}
+
+type memState struct {
+	mu   sync.Mutex
+	data *memData
+}
+
+type memDisbursement struct {
+	info    models.DisbursementInfo
+	adminID int
+}
+
+type memStatusChange struct {
+	loanID int
+	change models.StatusChange
+}
+
+type memToken struct {
+	models.UserToken
+	used bool
+}
+
+type memInvite struct {
+	models.Invite
+	used bool
+}
+
+type memData struct {
+	users         map[int]models.User
+	loans         map[int]models.Loan
+	approvals     map[int]models.ApprovalInfo
+	disbursements map[int]memDisbursement
+	documents     map[string]models.Document // by sha256
+	investments   []models.Investment
+	installments  map[int][]models.Installment
+	repayments    []models.Repayment
+	payouts       []models.Payout
+	history       []memStatusChange
+	tokens        []memToken
+	invites       []memInvite
+	sessions      map[string]models.Session
+	refreshTokens []models.RefreshToken
+	revoked       map[string]string // jti -> expires_at
+	userRoles     []memUserRole
+	totp          map[int]models.TOTPCredential
+	recoveryCodes []memRecoveryCode
+	loginFailures map[memLoginKey]models.LoginFailure
+	apiKeys       []models.APIKey
+	sequences     map[string]int
+}
+
+func newMemData() *memData {
+	return &memData{
+		users:         map[int]models.User{},
+		loans:         map[int]models.Loan{},
+		approvals:     map[int]models.ApprovalInfo{},
+		disbursements: map[int]memDisbursement{},
+		documents:     map[string]models.Document{},
+		installments:  map[int][]models.Installment{},
+		sessions:      map[string]models.Session{},
+		revoked:       map[string]string{},
+		totp:          map[int]models.TOTPCredential{},
+		loginFailures: map[memLoginKey]models.LoginFailure{},
+		sequences:     map[string]int{},
+	}
+}
+
+func (d *memData) clone() *memData {
+	c := newMemData()
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	for k, v := range d.loans {
+		c.loans[k] = v
+	}
+	for k, v := range d.approvals {
+		c.approvals[k] = v
+	}
+	for k, v := range d.disbursements {
+		c.disbursements[k] = v
+	}
+	for k, v := range d.documents {
+		c.documents[k] = v
+	}
+	for k, v := range d.installments {
+		c.installments[k] = append([]models.Installment(nil), v...)
+	}
+	c.investments = append(c.investments, d.investments...)
+	c.repayments = append(c.repayments, d.repayments...)
+	c.payouts = append(c.payouts, d.payouts...)
+	c.history = append(c.history, d.history...)
+	c.tokens = append(c.tokens, d.tokens...)
+	c.invites = append(c.invites, d.invites...)
+	for k, v := range d.sessions {
+		c.sessions[k] = v
+	}
+	c.refreshTokens = append(c.refreshTokens, d.refreshTokens...)
+	for k, v := range d.revoked {
+		c.revoked[k] = v
+	}
+	for k, v := range d.totp {
+		c.totp[k] = v
+	}
+	c.recoveryCodes = append(c.recoveryCodes, d.recoveryCodes...)
+	c.userRoles = append(c.userRoles, d.userRoles...)
+	for k, v := range d.loginFailures {
+		c.loginFailures[k] = v
+	}
+	c.apiKeys = append(c.apiKeys, d.apiKeys...)
+	for k, v := range d.sequences {
+		c.sequences[k] = v
+	}
+	return c
+}
+
+// nextID hands out IDs per table, like AUTOINCREMENT.
+func (d *memData) nextID(table string) int {
+	d.sequences[table]++
+	return d.sequences[table]
+}
+
+// memRepositories is shared by the store and its transactions; inTx tells
+// whether the lock is already held.
+type memRepositories struct {
+	state *memState
+	inTx  bool
+}
+
+// use locks the store for a single call made outside a transaction and
+// returns the current data together with the matching unlock.
+func (r memRepositories) use() (*memData, func()) {
+	if r.inTx {
+		return r.state.data, func() {}
+	}
+	r.state.mu.Lock()
+	return r.state.data, r.state.mu.Unlock
+}
+
+func (r memRepositories) Users() UserRepository                 { return memUsers{r} }
+func (r memRepositories) Loans() LoanRepository                 { return memLoans{r} }
+func (r memRepositories) Approvals() ApprovalRepository         { return memApprovals{r} }
+func (r memRepositories) Disbursements() DisbursementRepository { return memDisbursements{r} }
+func (r memRepositories) Documents() DocumentRepository         { return memDocuments{r} }
+func (r memRepositories) Investments() InvestmentRepository     { return memInvestments{r} }
+func (r memRepositories) Repayments() RepaymentRepository       { return memRepayments{r} }
+func (r memRepositories) Payouts() PayoutRepository             { return memPayouts{r} }
+func (r memRepositories) Tokens() TokenRepository               { return memTokens{r} }
+func (r memRepositories) Invites() InviteRepository             { return memInvites{r} }
+func (r memRepositories) Sessions() SessionRepository           { return memSessions{r} }
+func (r memRepositories) Revocations() RevocationRepository     { return memRevocations{r} }
+func (r memRepositories) Roles() RoleRepository                 { return memRoles{r} }
+func (r memRepositories) LoginFailures() LoginFailureRepository { return memLoginFailures{r} }
+func (r memRepositories) APIKeys() APIKeyRepository             { return memAPIKeys{r} }
+func (r memRepositories) TwoFactor() TwoFactorRepository        { return memTwoFactor{r} }
+
+type memUsers struct{ memRepositories }
+
+func (r memUsers) Create(ctx context.Context, user models.User) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, u := range d.users {
+		if u.Username == user.Username || u.Email == user.Email {
+			return 0, ErrDuplicate
+		}
+	}
+	user.ID = d.nextID("users")
+	d.users[user.ID] = user
+	d.userRoles = append(d.userRoles, memUserRole{userID: user.ID, role: user.Role})
+	return user.ID, nil
+}
+
+func (r memUsers) GetByID(ctx context.Context, id int) (models.User, error) {
+	d, unlock := r.use()
+	defer unlock()
+	u, ok := d.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (r memUsers) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, u := range d.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memUsers) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, u := range d.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memUsers) MarkEmailVerified(ctx context.Context, id int, verifiedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	if u, ok := d.users[id]; ok {
+		u.EmailVerifiedAt = verifiedAt
+		d.users[id] = u
+	}
+	return nil
+}
+
+func (r memUsers) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	d, unlock := r.use()
+	defer unlock()
+	if u, ok := d.users[id]; ok {
+		u.PasswordHash = passwordHash
+		d.users[id] = u
+	}
+	return nil
+}
+
+type memTokens struct{ memRepositories }
+
+func (r memTokens) Create(ctx context.Context, token models.UserToken) error {
+	d, unlock := r.use()
+	defer unlock()
+	for _, t := range d.tokens {
+		if t.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	d.tokens = append(d.tokens, memToken{UserToken: token})
+	return nil
+}
+
+func (r memTokens) Consume(ctx context.Context, purpose, tokenHash, now string) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for i, t := range d.tokens {
+		if t.TokenHash == tokenHash && t.Purpose == purpose && !t.used && t.ExpiresAt > now {
+			d.tokens[i].used = true
+			return t.UserID, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (r memTokens) ConsumeAll(ctx context.Context, userID int, purpose, now string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for i, t := range d.tokens {
+		if t.UserID == userID && t.Purpose == purpose {
+			d.tokens[i].used = true
+		}
+	}
+	return nil
+}
+
+type memInvites struct{ memRepositories }
+
+func (r memInvites) Create(ctx context.Context, invite models.Invite) error {
+	d, unlock := r.use()
+	defer unlock()
+	for _, inv := range d.invites {
+		if inv.TokenHash == invite.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	d.invites = append(d.invites, memInvite{Invite: invite})
+	return nil
+}
+
+func (r memInvites) Consume(ctx context.Context, tokenHash, email, now string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for i, inv := range d.invites {
+		if inv.TokenHash == tokenHash && inv.Email == email && !inv.used && inv.ExpiresAt > now {
+			d.invites[i].used = true
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+type memSessions struct{ memRepositories }
+
+func (r memSessions) Create(ctx context.Context, session models.Session) error {
+	d, unlock := r.use()
+	defer unlock()
+	if _, ok := d.sessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	d.sessions[session.ID] = session
+	return nil
+}
+
+func (r memSessions) Get(ctx context.Context, id string) (models.Session, error) {
+	d, unlock := r.use()
+	defer unlock()
+	session, ok := d.sessions[id]
+	if !ok {
+		return models.Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r memSessions) Revoke(ctx context.Context, id, revokedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	if session, ok := d.sessions[id]; ok && session.RevokedAt == "" {
+		session.RevokedAt = revokedAt
+		d.sessions[id] = session
+	}
+	return nil
+}
+
+func (r memSessions) RevokeAllForUser(ctx context.Context, userID int, revokedAt string) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	n := 0
+	for id, session := range d.sessions {
+		if session.UserID == userID && session.RevokedAt == "" {
+			session.RevokedAt = revokedAt
+			d.sessions[id] = session
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memSessions) AddRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	d, unlock := r.use()
+	defer unlock()
+	for _, t := range d.refreshTokens {
+		if t.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	d.refreshTokens = append(d.refreshTokens, token)
+	return nil
+}
+
+func (r memSessions) GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, t := range d.refreshTokens {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+	return models.RefreshToken{}, ErrNotFound
+}
+
+func (r memSessions) UseRefreshToken(ctx context.Context, tokenHash, usedAt string) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for i, t := range d.refreshTokens {
+		if t.TokenHash == tokenHash && t.UsedAt == "" {
+			d.refreshTokens[i].UsedAt = usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type memRevocations struct{ memRepositories }
+
+func (r memRevocations) Revoke(ctx context.Context, jti, expiresAt, revokedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	if _, ok := d.revoked[jti]; !ok {
+		d.revoked[jti] = expiresAt
+	}
+	return nil
+}
+
+func (r memRevocations) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	_, ok := d.revoked[jti]
+	return ok, nil
+}
+
+func (r memRevocations) PurgeExpired(ctx context.Context, now string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for jti, expiresAt := range d.revoked {
+		if expiresAt < now {
+			delete(d.revoked, jti)
+		}
+	}
+	return nil
+}
+
+type memLoginKey struct{ scope, subject string }
+
+type memLoginFailures struct{ memRepositories }
+
+func (r memLoginFailures) Record(ctx context.Context, scope, subject, failedAt, since string) (models.LoginFailure, error) {
+	d, unlock := r.use()
+	defer unlock()
+	key := memLoginKey{scope, subject}
+	f, ok := d.loginFailures[key]
+	if !ok || f.LastFailedAt <= since {
+		f = models.LoginFailure{Scope: scope, Subject: subject}
+	}
+	f.Failures++
+	f.LastFailedAt = failedAt
+	d.loginFailures[key] = f
+	return f, nil
+}
+
+func (r memLoginFailures) Get(ctx context.Context, scope, subject string) (models.LoginFailure, error) {
+	d, unlock := r.use()
+	defer unlock()
+	f, ok := d.loginFailures[memLoginKey{scope, subject}]
+	if !ok {
+		return f, ErrNotFound
+	}
+	return f, nil
+}
+
+func (r memLoginFailures) Lock(ctx context.Context, scope, subject, until string) error {
+	d, unlock := r.use()
+	defer unlock()
+	key := memLoginKey{scope, subject}
+	if f, ok := d.loginFailures[key]; ok {
+		f.LockedUntil = until
+		d.loginFailures[key] = f
+	}
+	return nil
+}
+
+func (r memLoginFailures) Clear(ctx context.Context, scope, subject string) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	key := memLoginKey{scope, subject}
+	_, ok := d.loginFailures[key]
+	delete(d.loginFailures, key)
+	return ok, nil
+}
+
+func (r memLoginFailures) ListLocked(ctx context.Context, now string) ([]models.LoginFailure, error) {
+	d, unlock := r.use()
+	defer unlock()
+	locked := []models.LoginFailure{}
+	for _, f := range d.loginFailures {
+		if f.LockedUntil > now {
+			locked = append(locked, f)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].LockedUntil < locked[j].LockedUntil })
+	return locked, nil
+}
+
+type memAPIKeys struct{ memRepositories }
+
+func (r memAPIKeys) Create(ctx context.Context, key models.APIKey) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, k := range d.apiKeys {
+		if k.KeyHash == key.KeyHash {
+			return 0, ErrDuplicate
+		}
+	}
+	key.ID = d.nextID("api_keys")
+	key.Scopes = append([]string(nil), key.Scopes...)
+	d.apiKeys = append(d.apiKeys, key)
+	return key.ID, nil
+}
+
+func (r memAPIKeys) GetByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, k := range d.apiKeys {
+		if k.KeyHash == keyHash {
+			k.Scopes = append([]string(nil), k.Scopes...)
+			return k, nil
+		}
+	}
+	return models.APIKey{}, ErrNotFound
+}
+
+func (r memAPIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	d, unlock := r.use()
+	defer unlock()
+	keys := []models.APIKey{}
+	for _, k := range d.apiKeys {
+		k.Scopes = append([]string(nil), k.Scopes...)
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (r memAPIKeys) Revoke(ctx context.Context, id int, revokedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for i, k := range d.apiKeys {
+		if k.ID == id && k.RevokedAt == "" {
+			d.apiKeys[i].RevokedAt = revokedAt
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r memAPIKeys) Touch(ctx context.Context, id int, usedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for i, k := range d.apiKeys {
+		if k.ID == id {
+			d.apiKeys[i].LastUsedAt = usedAt
+		}
+	}
+	return nil
+}
+
+// defaultRoles mirrors the roles and permissions created by the permissions
+// migration; TestRolesMatchMigrations checks the two agree.
+var defaultRoles = []models.Role{
+	{Name: "admin", Description: "Runs the lending operation", Permissions: []string{"agreement:download", "api_key:manage", "loan:approve", "loan:cancel", "loan:disburse", "loan:read", "loan:reject", "repayment:record", "role:manage", "user:invite", "user:manage"}},
+	{Name: "investor", Description: "Invests in approved loans", Permissions: []string{"investment:create", "loan:read:investable", "payout:read:own"}},
+	{Name: "requester", Description: "Proposes loans and repays them", Permissions: []string{"loan:cancel:own", "loan:create", "loan:read:own", "repayment:record:own"}},
+}
+
+type memUserRole struct {
+	userID int
+	role   string
+}
+
+type memRoles struct{ memRepositories }
+
+func (r memRoles) List(ctx context.Context) ([]models.Role, error) {
+	roles := make([]models.Role, len(defaultRoles))
+	for i, role := range defaultRoles {
+		role.Permissions = append([]string(nil), role.Permissions...)
+		roles[i] = role
+	}
+	return roles, nil
+}
+
+func (r memRoles) Exists(ctx context.Context, role string) (bool, error) {
+	for _, known := range defaultRoles {
+		if known.Name == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memRoles) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	d, unlock := r.use()
+	defer unlock()
+	roles := []string{}
+	for _, ur := range d.userRoles {
+		if ur.userID == userID {
+			roles = append(roles, ur.role)
+		}
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func (r memRoles) UserPermissions(ctx context.Context, userID int) ([]string, error) {
+	held, _ := r.UserRoles(ctx, userID)
+	holds := map[string]bool{}
+	for _, role := range held {
+		holds[role] = true
+	}
+	seen := map[string]bool{}
+	permissions := []string{}
+	for _, role := range defaultRoles {
+		if !holds[role.Name] {
+			continue
+		}
+		for _, p := range role.Permissions {
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+func (r memRoles) Grant(ctx context.Context, userID int, role string, grantedBy int, grantedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for _, ur := range d.userRoles {
+		if ur.userID == userID && ur.role == role {
+			return ErrDuplicate
+		}
+	}
+	d.userRoles = append(d.userRoles, memUserRole{userID: userID, role: role})
+	return nil
+}
+
+func (r memRoles) Revoke(ctx context.Context, userID int, role string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for i, ur := range d.userRoles {
+		if ur.userID == userID && ur.role == role {
+			d.userRoles = append(d.userRoles[:i:i], d.userRoles[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r memRoles) CountUsers(ctx context.Context, role string) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	n := 0
+	for _, ur := range d.userRoles {
+		if ur.role == role {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CountUsersForUpdate is CountUsers: a memory transaction already holds the
+// store's lock.
+func (r memRoles) CountUsersForUpdate(ctx context.Context, role string) (int, error) {
+	return r.CountUsers(ctx, role)
+}
+
+type memRecoveryCode struct {
+	userID   int
+	codeHash string
+	usedAt   string
+}
+
+type memTwoFactor struct{ memRepositories }
+
+func (r memTwoFactor) GetTOTP(ctx context.Context, userID int) (models.TOTPCredential, error) {
+	d, unlock := r.use()
+	defer unlock()
+	credential, ok := d.totp[userID]
+	if !ok {
+		return models.TOTPCredential{}, ErrNotFound
+	}
+	return credential, nil
+}
+
+func (r memTwoFactor) SaveTOTP(ctx context.Context, credential models.TOTPCredential) error {
+	d, unlock := r.use()
+	defer unlock()
+	credential.ConfirmedAt, credential.LastUsedStep = "", 0
+	d.totp[credential.UserID] = credential
+	return nil
+}
+
+func (r memTwoFactor) ConfirmTOTP(ctx context.Context, userID int, confirmedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	credential, ok := d.totp[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	credential.ConfirmedAt = confirmedAt
+	d.totp[userID] = credential
+	return nil
+}
+
+func (r memTwoFactor) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	credential, ok := d.totp[userID]
+	if !ok || credential.LastUsedStep >= step {
+		return false, nil
+	}
+	credential.LastUsedStep = step
+	d.totp[userID] = credential
+	return true, nil
+}
+
+func (r memTwoFactor) DeleteTOTP(ctx context.Context, userID int) error {
+	d, unlock := r.use()
+	defer unlock()
+	delete(d.totp, userID)
+	r.dropRecoveryCodes(d, userID)
+	return nil
+}
+
+func (r memTwoFactor) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, createdAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	r.dropRecoveryCodes(d, userID)
+	for _, hash := range codeHashes {
+		d.recoveryCodes = append(d.recoveryCodes, memRecoveryCode{userID: userID, codeHash: hash})
+	}
+	return nil
+}
+
+func (r memTwoFactor) dropRecoveryCodes(d *memData, userID int) {
+	kept := d.recoveryCodes[:0]
+	for _, code := range d.recoveryCodes {
+		if code.userID != userID {
+			kept = append(kept, code)
+		}
+	}
+	d.recoveryCodes = kept
+}
+
+func (r memTwoFactor) UseRecoveryCode(ctx context.Context, userID int, codeHash, usedAt string) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for i, code := range d.recoveryCodes {
+		if code.userID == userID && code.codeHash == codeHash && code.usedAt == "" {
+			d.recoveryCodes[i].usedAt = usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memTwoFactor) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	n := 0
+	for _, code := range d.recoveryCodes {
+		if code.userID == userID && code.usedAt == "" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memLoans struct{ memRepositories }
+
+func (r memLoans) Create(ctx context.Context, loan models.Loan) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	loan.ID = d.nextID("loans")
+	d.loans[loan.ID] = loan
+	return loan.ID, nil
+}
+
+func (r memLoans) Get(ctx context.Context, id int) (models.Loan, error) {
+	d, unlock := r.use()
+	defer unlock()
+	l, ok := d.loans[id]
+	if !ok {
+		return models.Loan{}, ErrNotFound
+	}
+	return l, nil
+}
+
+// GetForUpdate is Get: a memory transaction already holds the store's lock.
+func (r memLoans) GetForUpdate(ctx context.Context, id int) (models.Loan, error) {
+	return r.Get(ctx, id)
+}
+
+func (r memLoans) List(ctx context.Context) ([]models.Loan, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var loans []models.Loan
+	for _, l := range d.loans {
+		loans = append(loans, l)
+	}
+	sort.Slice(loans, func(i, j int) bool { return loans[i].ID < loans[j].ID })
+	return loans, nil
+}
+
+func (r memLoans) UpdateStatus(ctx context.Context, id int, from, to string) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	l, ok := d.loans[id]
+	if !ok || l.Status != from {
+		return false, nil
+	}
+	l.Status = to
+	d.loans[id] = l
+	return true, nil
+}
+
+func (r memLoans) SetFundingDeadline(ctx context.Context, id int, deadline string) error {
+	d, unlock := r.use()
+	defer unlock()
+	if l, ok := d.loans[id]; ok {
+		l.FundingDeadline = deadline
+		d.loans[id] = l
+	}
+	return nil
+}
+
+func (r memLoans) ListPastFundingDeadline(ctx context.Context, status, before string) ([]int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var ids []int
+	for _, l := range d.loans {
+		if l.Status == status && l.FundingDeadline != "" && l.FundingDeadline < before {
+			ids = append(ids, l.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (r memLoans) AddStatusChange(ctx context.Context, loanID int, change models.StatusChange) error {
+	d, unlock := r.use()
+	defer unlock()
+	change.Actor = ""
+	d.history = append(d.history, memStatusChange{loanID: loanID, change: change})
+	return nil
+}
+
+func (r memLoans) StatusHistory(ctx context.Context, loanID int) ([]models.StatusChange, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var history []models.StatusChange
+	for _, h := range d.history {
+		if h.loanID != loanID {
+			continue
+		}
+		change := h.change
+		if u, ok := d.users[change.ActorID]; ok {
+			change.Actor = u.Username
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+type memApprovals struct{ memRepositories }
+
+func (r memApprovals) Create(ctx context.Context, loanID int, approval models.ApprovalInfo) error {
+	d, unlock := r.use()
+	defer unlock()
+	if _, ok := d.approvals[loanID]; ok {
+		return fmt.Errorf("UNIQUE constraint failed: approvals.loan_id")
+	}
+	d.approvals[loanID] = approval
+	return nil
+}
+
+func (r memApprovals) Get(ctx context.Context, loanID int) (models.ApprovalInfo, error) {
+	d, unlock := r.use()
+	defer unlock()
+	a, ok := d.approvals[loanID]
+	if !ok {
+		return models.ApprovalInfo{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (r memApprovals) ListByProof(ctx context.Context, key string) ([]int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var ids []int
+	for loanID, a := range d.approvals {
+		if a.ProofURL == key {
+			ids = append(ids, loanID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (r memApprovals) Delete(ctx context.Context, loanID int) error {
+	d, unlock := r.use()
+	defer unlock()
+	delete(d.approvals, loanID)
+	return nil
+}
+
+type memDisbursements struct{ memRepositories }
+
+func (r memDisbursements) Create(ctx context.Context, loanID, adminID int, disbursement models.DisbursementInfo) error {
+	d, unlock := r.use()
+	defer unlock()
+	if _, ok := d.disbursements[loanID]; ok {
+		return fmt.Errorf("UNIQUE constraint failed: disbursements.loan_id")
+	}
+	d.disbursements[loanID] = memDisbursement{info: disbursement, adminID: adminID}
+	return nil
+}
+
+func (r memDisbursements) Get(ctx context.Context, loanID int) (models.DisbursementInfo, error) {
+	d, unlock := r.use()
+	defer unlock()
+	disb, ok := d.disbursements[loanID]
+	if !ok {
+		return models.DisbursementInfo{}, ErrNotFound
+	}
+	return disb.info, nil
+}
+
+func (r memDisbursements) ListByAgreement(ctx context.Context, key string) ([]int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var ids []int
+	for loanID, disb := range d.disbursements {
+		if disb.info.SignedAgreementURL == key {
+			ids = append(ids, loanID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (r memDisbursements) Delete(ctx context.Context, loanID int) error {
+	d, unlock := r.use()
+	defer unlock()
+	delete(d.disbursements, loanID)
+	return nil
+}
+
+type memDocuments struct{ memRepositories }
+
+func (r memDocuments) Create(ctx context.Context, doc models.Document) (bool, error) {
+	d, unlock := r.use()
+	defer unlock()
+	if _, ok := d.documents[doc.SHA256]; ok {
+		return false, nil
+	}
+	d.documents[doc.SHA256] = doc
+	return true, nil
+}
+
+func (r memDocuments) GetByKey(ctx context.Context, key string) (models.Document, error) {
+	d, unlock := r.use()
+	defer unlock()
+	for _, doc := range d.documents {
+		if doc.Key == key {
+			return doc, nil
+		}
+	}
+	return models.Document{}, ErrNotFound
+}
+
+func (r memDocuments) ListByScanStatus(ctx context.Context, status string) ([]models.Document, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var docs []models.Document
+	for _, doc := range d.documents {
+		if doc.ScanStatus == status {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].CreatedAt < docs[j].CreatedAt })
+	return docs, nil
+}
+
+func (r memDocuments) SetScanStatus(ctx context.Context, sha256, status, scannedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	doc, ok := d.documents[sha256]
+	if !ok {
+		return ErrNotFound
+	}
+	doc.ScanStatus, doc.ScannedAt = status, scannedAt
+	d.documents[sha256] = doc
+	return nil
+}
+
+func (r memDocuments) Delete(ctx context.Context, sha256 string) error {
+	d, unlock := r.use()
+	defer unlock()
+	delete(d.documents, sha256)
+	return nil
+}
+
+type memInvestments struct{ memRepositories }
+
+func (r memInvestments) Create(ctx context.Context, inv models.Investment) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	if inv.Status == "" {
+		inv.Status = "active"
+	}
+	inv.ID = d.nextID("investments")
+	d.investments = append(d.investments, inv)
+	return inv.ID, nil
+}
+
+func (r memInvestments) ListByLoan(ctx context.Context, loanID int) ([]models.Investment, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var investments []models.Investment
+	for _, inv := range d.investments {
+		if inv.LoanID != loanID {
+			continue
+		}
+		u := d.users[inv.InvestorID]
+		inv.Investor, inv.Email = u.Username, u.Email
+		investments = append(investments, inv)
+	}
+	return investments, nil
+}
+
+func (r memInvestments) TotalActive(ctx context.Context, loanID int) (money.Amount, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var total money.Amount
+	for _, inv := range d.investments {
+		if inv.LoanID == loanID && inv.Status == "active" {
+			total += inv.Amount
+		}
+	}
+	return total, nil
+}
+
+func (r memInvestments) TotalByInvestor(ctx context.Context, loanID, investorID int) (money.Amount, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var total money.Amount
+	for _, inv := range d.investments {
+		if inv.LoanID == loanID && inv.InvestorID == investorID {
+			total += inv.Amount
+		}
+	}
+	return total, nil
+}
+
+func (r memInvestments) RefundActive(ctx context.Context, loanID int, refundedAt string) error {
+	d, unlock := r.use()
+	defer unlock()
+	for i, inv := range d.investments {
+		if inv.LoanID == loanID && inv.Status == "active" {
+			d.investments[i].Status = "refunded"
+			d.investments[i].RefundedAt = refundedAt
+		}
+	}
+	return nil
+}
+
+type memRepayments struct{ memRepositories }
+
+func (r memRepayments) CreateInstallments(ctx context.Context, loanID int, installments []models.Installment) error {
+	d, unlock := r.use()
+	defer unlock()
+	for _, inst := range installments {
+		for _, existing := range d.installments[loanID] {
+			if existing.Number == inst.Number {
+				return fmt.Errorf("UNIQUE constraint failed: repayment_schedules.loan_id, repayment_schedules.installment_number")
+			}
+		}
+		inst.ID = d.nextID("repayment_schedules")
+		d.installments[loanID] = append(d.installments[loanID], inst)
+	}
+	sort.Slice(d.installments[loanID], func(i, j int) bool {
+		return d.installments[loanID][i].Number < d.installments[loanID][j].Number
+	})
+	return nil
+}
+
+func (r memRepayments) Installments(ctx context.Context, loanID int) ([]models.Installment, error) {
+	d, unlock := r.use()
+	defer unlock()
+	return append([]models.Installment(nil), d.installments[loanID]...), nil
+}
+
+func (r memRepayments) UpdateInstallment(ctx context.Context, installment models.Installment) error {
+	d, unlock := r.use()
+	defer unlock()
+	for loanID, installments := range d.installments {
+		for i, inst := range installments {
+			if inst.ID == installment.ID {
+				d.installments[loanID][i] = installment
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (r memRepayments) Create(ctx context.Context, repayment models.Repayment) (int, error) {
+	d, unlock := r.use()
+	defer unlock()
+	repayment.ID = d.nextID("repayments")
+	d.repayments = append(d.repayments, repayment)
+	return repayment.ID, nil
+}
+
+type memPayouts struct{ memRepositories }
+
+func (r memPayouts) Create(ctx context.Context, payout models.Payout) error {
+	d, unlock := r.use()
+	defer unlock()
+	d.payouts = append(d.payouts, payout)
+	return nil
+}
+
+func (r memPayouts) ListByInvestor(ctx context.Context, loanID, investorID int) ([]models.PayoutInfo, error) {
+	d, unlock := r.use()
+	defer unlock()
+	var payouts []models.PayoutInfo
+	for _, p := range d.payouts {
+		if p.LoanID == loanID && p.InvestorID == investorID {
+			payouts = append(payouts, models.PayoutInfo{
+				RepaymentID: p.RepaymentID,
+				Principal:   p.Principal,
+				Interest:    p.Interest,
+				Amount:      p.Amount,
+				PaidAt:      p.PaidAt,
+			})
+		}
+	}
+	return payouts, nil
+}
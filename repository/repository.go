@@ -0,0 +1,255 @@
+// Package repository is the data access layer. Handlers, the loan lifecycle
+// and background jobs talk to these interfaces instead of the database, so
+// the same code runs against SQLite (SQLStore) or an in-memory store
+// (MemoryStore) in unit tests.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+)
+
+// ErrNotFound is returned when a single record lookup matches nothing.
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when a write breaks a unique constraint, such as
+// registering a username or email that is already taken.
+var ErrDuplicate = errors.New("record already exists")
+
+type UserRepository interface {
+	// Create grants the user their registration role. It returns
+	// ErrDuplicate when the username or email is taken.
+	Create(ctx context.Context, user models.User) (int, error)
+	GetByID(ctx context.Context, id int) (models.User, error)
+	GetByUsername(ctx context.Context, username string) (models.User, error)
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	MarkEmailVerified(ctx context.Context, id int, verifiedAt string) error
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+}
+
+// RoleRepository maps roles to permissions and users to roles.
+type RoleRepository interface {
+	// List returns every role with its permissions, ordered by name.
+	List(ctx context.Context) ([]models.Role, error)
+	Exists(ctx context.Context, role string) (bool, error)
+	// UserRoles returns the names of the user's roles, ordered by name.
+	UserRoles(ctx context.Context, userID int) ([]string, error)
+	// UserPermissions returns what the user's roles allow, ordered and
+	// without duplicates.
+	UserPermissions(ctx context.Context, userID int) ([]string, error)
+	// Grant gives the user a role, or returns ErrDuplicate if they hold it.
+	// grantedBy is the admin granting it.
+	Grant(ctx context.Context, userID int, role string, grantedBy int, grantedAt string) error
+	// Revoke takes a role away, or returns ErrNotFound if the user does not hold it.
+	Revoke(ctx context.Context, userID int, role string) error
+	// CountUsers reports how many users hold the role.
+	CountUsers(ctx context.Context, role string) (int, error)
+	// CountUsersForUpdate is CountUsers inside a transaction that keeps
+	// others from revoking the role until it ends. Checks that a role keeps
+	// a holder, such as the last admin's, must count this way.
+	CountUsersForUpdate(ctx context.Context, role string) (int, error)
+}
+
+// TokenRepository keeps the single-use tokens mailed to users.
+type TokenRepository interface {
+	Create(ctx context.Context, token models.UserToken) error
+	// Consume marks an unused token for the purpose that has not expired by
+	// now as used and returns its user. Anything else is ErrNotFound.
+	Consume(ctx context.Context, purpose, tokenHash, now string) (int, error)
+	// ConsumeAll marks every unused token of the user for the purpose as used.
+	ConsumeAll(ctx context.Context, userID int, purpose, now string) error
+}
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite models.Invite) error
+	// Consume marks an unused, unexpired invite for the email as used, or
+	// returns ErrNotFound.
+	Consume(ctx context.Context, tokenHash, email, now string) error
+}
+
+type LoanRepository interface {
+	Create(ctx context.Context, loan models.Loan) (int, error)
+	Get(ctx context.Context, id int) (models.Loan, error)
+	// GetForUpdate reads a loan inside a transaction and keeps other
+	// transactions from changing it until this one ends. Read-then-write
+	// workflows such as investment placement must load the loan this way.
+	GetForUpdate(ctx context.Context, id int) (models.Loan, error)
+	List(ctx context.Context) ([]models.Loan, error)
+	// UpdateStatus moves a loan from one status to another. It reports false,
+	// without error, when the loan was no longer in the from status.
+	UpdateStatus(ctx context.Context, id int, from, to string) (bool, error)
+	SetFundingDeadline(ctx context.Context, id int, deadline string) error
+	// ListPastFundingDeadline returns the IDs of loans in the given status
+	// whose funding deadline is before the given RFC3339 time.
+	ListPastFundingDeadline(ctx context.Context, status, before string) ([]int, error)
+
+	AddStatusChange(ctx context.Context, loanID int, change models.StatusChange) error
+	// StatusHistory returns a loan's status changes oldest first, with the
+	// actor's username filled in.
+	StatusHistory(ctx context.Context, loanID int) ([]models.StatusChange, error)
+}
+
+type ApprovalRepository interface {
+	Create(ctx context.Context, loanID int, approval models.ApprovalInfo) error
+	Get(ctx context.Context, loanID int) (models.ApprovalInfo, error)
+	// ListByProof returns the IDs of the loans whose approval has the proof
+	// stored under key.
+	ListByProof(ctx context.Context, key string) ([]int, error)
+	Delete(ctx context.Context, loanID int) error
+}
+
+type DisbursementRepository interface {
+	Create(ctx context.Context, loanID, adminID int, disbursement models.DisbursementInfo) error
+	Get(ctx context.Context, loanID int) (models.DisbursementInfo, error)
+	// ListByAgreement returns the IDs of the loans whose disbursement has
+	// the signed agreement stored under key.
+	ListByAgreement(ctx context.Context, key string) ([]int, error)
+	Delete(ctx context.Context, loanID int) error
+}
+
+// DocumentRepository records the hash, size and type of stored uploads.
+type DocumentRepository interface {
+	// Create records a document. It reports false, without error, when a
+	// document with the same hash is already recorded.
+	Create(ctx context.Context, document models.Document) (bool, error)
+	GetByKey(ctx context.Context, key string) (models.Document, error)
+	ListByScanStatus(ctx context.Context, status string) ([]models.Document, error)
+	SetScanStatus(ctx context.Context, sha256, status, scannedAt string) error
+	Delete(ctx context.Context, sha256 string) error
+}
+
+type InvestmentRepository interface {
+	Create(ctx context.Context, investment models.Investment) (int, error)
+	// ListByLoan returns every investment in a loan, refunded ones included.
+	ListByLoan(ctx context.Context, loanID int) ([]models.Investment, error)
+	// TotalActive sums the investments in a loan that have not been refunded.
+	TotalActive(ctx context.Context, loanID int) (money.Amount, error)
+	TotalByInvestor(ctx context.Context, loanID, investorID int) (money.Amount, error)
+	// RefundActive marks every active investment in a loan as refunded.
+	RefundActive(ctx context.Context, loanID int, refundedAt string) error
+}
+
+type RepaymentRepository interface {
+	CreateInstallments(ctx context.Context, loanID int, installments []models.Installment) error
+	// Installments returns a loan's schedule ordered by installment number.
+	Installments(ctx context.Context, loanID int) ([]models.Installment, error)
+	UpdateInstallment(ctx context.Context, installment models.Installment) error
+	Create(ctx context.Context, repayment models.Repayment) (int, error)
+}
+
+type PayoutRepository interface {
+	Create(ctx context.Context, payout models.Payout) error
+	ListByInvestor(ctx context.Context, loanID, investorID int) ([]models.PayoutInfo, error)
+}
+
+// SessionRepository keeps login sessions and their refresh tokens.
+type SessionRepository interface {
+	Create(ctx context.Context, session models.Session) error
+	Get(ctx context.Context, id string) (models.Session, error)
+	Revoke(ctx context.Context, id, revokedAt string) error
+	// RevokeAllForUser revokes every active session of the user and reports
+	// how many there were.
+	RevokeAllForUser(ctx context.Context, userID int, revokedAt string) (int, error)
+
+	AddRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error)
+	// UseRefreshToken marks an unused refresh token as used. It reports
+	// false, without error, when the token had already been used.
+	UseRefreshToken(ctx context.Context, tokenHash, usedAt string) (bool, error)
+}
+
+// RevocationRepository is the list of access tokens revoked before they
+// expire, keyed by their jti claim.
+type RevocationRepository interface {
+	// Revoke adds a token to the list; revoking it twice is harmless.
+	Revoke(ctx context.Context, jti, expiresAt, revokedAt string) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// PurgeExpired drops entries for tokens that would be rejected as
+	// expired anyway.
+	PurgeExpired(ctx context.Context, now string) error
+}
+
+// LoginFailureRepository counts failed logins per username and per IP.
+type LoginFailureRepository interface {
+	// Record counts a failed login and returns the updated count. Failures
+	// at or before since are forgotten, and so is a lockout from then.
+	Record(ctx context.Context, scope, subject, failedAt, since string) (models.LoginFailure, error)
+	Get(ctx context.Context, scope, subject string) (models.LoginFailure, error)
+	Lock(ctx context.Context, scope, subject, until string) error
+	// Clear forgets the failures, reporting whether there were any.
+	Clear(ctx context.Context, scope, subject string) (bool, error)
+	// ListLocked returns the entries locked out past now.
+	ListLocked(ctx context.Context, now string) ([]models.LoginFailure, error)
+}
+
+// APIKeyRepository keeps the API keys issued to integrations.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key models.APIKey) (int, error)
+	// GetByHash finds a key by its hash, revoked or not.
+	GetByHash(ctx context.Context, keyHash string) (models.APIKey, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+	// Revoke returns ErrNotFound unless the key exists and is not revoked yet.
+	Revoke(ctx context.Context, id int, revokedAt string) error
+	Touch(ctx context.Context, id int, usedAt string) error
+}
+
+// TwoFactorRepository keeps TOTP secrets and recovery codes.
+type TwoFactorRepository interface {
+	GetTOTP(ctx context.Context, userID int) (models.TOTPCredential, error)
+	// SaveTOTP stores a new, unconfirmed secret, replacing any earlier one.
+	SaveTOTP(ctx context.Context, credential models.TOTPCredential) error
+	ConfirmTOTP(ctx context.Context, userID int, confirmedAt string) error
+	// UseTOTPStep records that the code for a time step was accepted. It
+	// reports false, without error, when that step or a later one was
+	// already used.
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	// DeleteTOTP removes the secret and the recovery codes.
+	DeleteTOTP(ctx context.Context, userID int) error
+
+	// ReplaceRecoveryCodes discards the user's recovery codes and stores new ones.
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, createdAt string) error
+	// UseRecoveryCode marks an unused code as used. It reports false,
+	// without error, when the user has no such unused code.
+	UseRecoveryCode(ctx context.Context, userID int, codeHash, usedAt string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
+
+// Repositories gives access to every repository, either directly on a Store
+// or bound to a transaction.
+type Repositories interface {
+	Users() UserRepository
+	Roles() RoleRepository
+	Loans() LoanRepository
+	Approvals() ApprovalRepository
+	Disbursements() DisbursementRepository
+	Documents() DocumentRepository
+	Investments() InvestmentRepository
+	Repayments() RepaymentRepository
+	Payouts() PayoutRepository
+	Tokens() TokenRepository
+	Invites() InviteRepository
+	Sessions() SessionRepository
+	Revocations() RevocationRepository
+	TwoFactor() TwoFactorRepository
+	LoginFailures() LoginFailureRepository
+	APIKeys() APIKeyRepository
+}
+
+// Store is the entry point to the data layer.
+type Store interface {
+	Repositories
+	// Begin starts a transaction. Write transactions are serialised, so a
+	// read-check-write sequence inside one cannot interleave with another.
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// Tx is a set of repositories sharing one transaction. Rollback after a
+// successful Commit is a no-op, so it can always be deferred.
+type Tx interface {
+	Repositories
+	Commit() error
+	Rollback() error
+}
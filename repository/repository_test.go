@@ -0,0 +1,606 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"loan-service-engine/db"
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+	"loan-service-engine/repository"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Both stores must behave the same; every check runs against each of them.
+func stores(t *testing.T) map[string]repository.Store {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := db.MigrateUp(conn); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	stores := map[string]repository.Store{
+		"sqlite": repository.NewSQLStore(conn),
+		"memory": repository.NewMemoryStore(),
+	}
+
+	// Postgres runs only when a throwaway database is provided; its public
+	// schema is wiped first.
+	if dsn := os.Getenv("TEST_POSTGRES_URL"); dsn != "" {
+		pg, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatalf("Failed to open Postgres: %v", err)
+		}
+		t.Cleanup(func() { pg.Close() })
+		if _, err := pg.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+			t.Fatalf("Failed to reset Postgres schema: %v", err)
+		}
+		if _, err := db.MigrateUp(pg); err != nil {
+			t.Fatalf("Failed to migrate Postgres: %v", err)
+		}
+		stores["postgres"] = repository.NewSQLStore(pg)
+	}
+	return stores
+}
+
+func TestStores(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			testLoansAndHistory(t, store)
+			testInvestments(t, store)
+			testRepayments(t, store)
+			testRollback(t, store)
+			testTokensAndInvites(t, store)
+			testSessions(t, store)
+			testTwoFactor(t, store)
+			testRoles(t, store)
+			testLoginFailures(t, store)
+			testAPIKeys(t, store)
+		})
+	}
+}
+
+func testLoansAndHistory(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+
+	adminID, err := store.Users().Create(ctx, models.User{Username: "admin", Email: "admin@email.com", PasswordHash: "x", Role: "admin"})
+	if err != nil {
+		t.Fatalf("Create user failed: %v", err)
+	}
+	if _, err := store.Users().Create(ctx, models.User{Username: "admin", Email: "other@email.com", Role: "admin"}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate for a taken username, got %v", err)
+	}
+	if _, err := store.Users().Create(ctx, models.User{Username: "other", Email: "admin@email.com", Role: "admin"}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate for a taken email, got %v", err)
+	}
+	if _, err := store.Users().GetByUsername(ctx, "nobody"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown user, got %v", err)
+	}
+
+	loanID, err := store.Loans().Create(ctx, models.Loan{
+		BorrowerIDNumber:  "1234567890123456",
+		Amount:            money.FromRupiah(1000000),
+		Rate:              12,
+		ROI:               10,
+		TenureMonths:      6,
+		RepaymentMethod:   "flat",
+		FundingWindowDays: 30,
+		Status:            "proposed",
+		RequesterID:       adminID,
+	})
+	if err != nil {
+		t.Fatalf("Create loan failed: %v", err)
+	}
+
+	loan, err := store.Loans().Get(ctx, loanID)
+	if err != nil || loan.Amount != money.FromRupiah(1000000) || loan.Status != "proposed" {
+		t.Fatalf("Unexpected loan %+v (err %v)", loan, err)
+	}
+	if _, err := store.Loans().Get(ctx, loanID+100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown loan, got %v", err)
+	}
+
+	// Status updates only apply from the expected status
+	if ok, err := store.Loans().UpdateStatus(ctx, loanID, "approved", "invested"); ok || err != nil {
+		t.Errorf("Update from the wrong status should not apply (ok %v, err %v)", ok, err)
+	}
+	if ok, err := store.Loans().UpdateStatus(ctx, loanID, "proposed", "approved"); !ok || err != nil {
+		t.Errorf("Update from the current status should apply (ok %v, err %v)", ok, err)
+	}
+
+	store.Loans().SetFundingDeadline(ctx, loanID, "2025-01-01T00:00:00Z")
+	ids, err := store.Loans().ListPastFundingDeadline(ctx, "approved", "2025-06-01T00:00:00Z")
+	if err != nil || len(ids) != 1 || ids[0] != loanID {
+		t.Errorf("Expected loan to be past its deadline, got %v (err %v)", ids, err)
+	}
+
+	store.Loans().AddStatusChange(ctx, loanID, models.StatusChange{ToStatus: "proposed", ActorID: adminID, ChangedAt: "2025-01-01T00:00:00Z"})
+	store.Loans().AddStatusChange(ctx, loanID, models.StatusChange{FromStatus: "proposed", ToStatus: "approved", ChangedAt: "2025-01-02T00:00:00Z"})
+	history, err := store.Loans().StatusHistory(ctx, loanID)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Expected 2 history rows, got %d (err %v)", len(history), err)
+	}
+	if history[0].Actor != "admin" || history[1].ActorID != 0 || history[1].FromStatus != "proposed" {
+		t.Errorf("Unexpected history %+v", history)
+	}
+
+	latitude, longitude := -6.2075, 106.845
+	approval := models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-01-02", ProofURL: "/p.jpg",
+		ThumbnailURL: "t.jpg", PhotoTakenAt: "2025-01-02T09:00:00+07:00", Latitude: &latitude, Longitude: &longitude}
+	if err := store.Approvals().Create(ctx, loanID, approval); err != nil {
+		t.Fatalf("Create approval failed: %v", err)
+	}
+	if got, err := store.Approvals().Get(ctx, loanID); err != nil || got.ThumbnailURL != "t.jpg" || got.PhotoTakenAt != approval.PhotoTakenAt ||
+		got.Latitude == nil || *got.Latitude != latitude || got.Longitude == nil || *got.Longitude != longitude {
+		t.Errorf("Expected the approval with its visit metadata, got %+v (err %v)", got, err)
+	}
+	if err := store.Approvals().Create(ctx, loanID, models.ApprovalInfo{ValidatorID: "EMP002"}); err == nil {
+		t.Error("Expected a second approval for the same loan to be rejected")
+	}
+	if _, err := store.Disbursements().Get(ctx, loanID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing disbursement, got %v", err)
+	}
+
+	doc := models.Document{SHA256: strings.Repeat("ab", 32), Key: strings.Repeat("ab", 32) + ".pdf", Size: 1234, ContentType: "application/pdf",
+		CreatedAt: "2025-01-02T00:00:00Z", ScanStatus: models.ScanQuarantined}
+	if created, err := store.Documents().Create(ctx, doc); err != nil || !created {
+		t.Fatalf("Create document failed: %v", err)
+	}
+	if created, err := store.Documents().Create(ctx, doc); err != nil || created {
+		t.Errorf("Expected the same content to be recorded once, got %v (err %v)", created, err)
+	}
+	if got, err := store.Documents().GetByKey(ctx, doc.Key); err != nil || got != doc {
+		t.Errorf("Expected %+v, got %+v (err %v)", doc, got, err)
+	}
+	if _, err := store.Documents().GetByKey(ctx, "missing.pdf"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing document, got %v", err)
+	}
+
+	// Quarantined documents are found for a rescan and released or deleted
+	if docs, err := store.Documents().ListByScanStatus(ctx, models.ScanQuarantined); err != nil || len(docs) != 1 || docs[0] != doc {
+		t.Errorf("Expected the quarantined document, got %+v (err %v)", docs, err)
+	}
+	if err := store.Documents().SetScanStatus(ctx, doc.SHA256, models.ScanClean, "2025-01-03T00:00:00Z"); err != nil {
+		t.Errorf("SetScanStatus failed: %v", err)
+	}
+	if got, _ := store.Documents().GetByKey(ctx, doc.Key); got.ScanStatus != models.ScanClean || got.ScannedAt != "2025-01-03T00:00:00Z" {
+		t.Errorf("Expected the document to be clean, got %+v", got)
+	}
+	if err := store.Documents().SetScanStatus(ctx, "missing", models.ScanClean, ""); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing document, got %v", err)
+	}
+	store.Documents().Delete(ctx, doc.SHA256)
+	if _, err := store.Documents().GetByKey(ctx, doc.Key); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the document to be deleted, got %v", err)
+	}
+
+	if ids, err := store.Approvals().ListByProof(ctx, "/p.jpg"); err != nil || len(ids) != 1 || ids[0] != loanID {
+		t.Errorf("Expected the approval using the proof, got %v (err %v)", ids, err)
+	}
+	store.Approvals().Delete(ctx, loanID)
+	if _, err := store.Approvals().Get(ctx, loanID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the approval to be deleted, got %v", err)
+	}
+}
+
+func testInvestments(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+
+	investorID, _ := store.Users().Create(ctx, models.User{Username: "investor1", Email: "investor1@email.com", Role: "investor"})
+	loans, _ := store.Loans().List(ctx)
+	loanID := loans[0].ID
+
+	for _, amount := range []int64{300000, 200000} {
+		_, err := store.Investments().Create(ctx, models.Investment{
+			LoanID: loanID, InvestorID: investorID, Amount: money.FromRupiah(amount), InvestmentDate: "2025-01-03",
+		})
+		if err != nil {
+			t.Fatalf("Create investment failed: %v", err)
+		}
+	}
+
+	total, _ := store.Investments().TotalActive(ctx, loanID)
+	if total != money.FromRupiah(500000) {
+		t.Errorf("Expected 500000 active, got %s", total)
+	}
+
+	if err := store.Investments().RefundActive(ctx, loanID, "2025-01-04T00:00:00Z"); err != nil {
+		t.Fatalf("RefundActive failed: %v", err)
+	}
+	total, _ = store.Investments().TotalActive(ctx, loanID)
+	byInvestor, _ := store.Investments().TotalByInvestor(ctx, loanID, investorID)
+	if total != 0 || byInvestor != money.FromRupiah(500000) {
+		t.Errorf("Refunded investments should leave nothing active but still count per investor, got %s / %s", total, byInvestor)
+	}
+
+	investments, err := store.Investments().ListByLoan(ctx, loanID)
+	if err != nil || len(investments) != 2 {
+		t.Fatalf("Expected 2 investments, got %d (err %v)", len(investments), err)
+	}
+	if investments[0].Status != "refunded" || investments[0].Email != "investor1@email.com" || investments[0].RefundedAt == "" {
+		t.Errorf("Unexpected investment %+v", investments[0])
+	}
+}
+
+func testRepayments(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	loans, _ := store.Loans().List(ctx)
+	loanID := loans[0].ID
+	investor, _ := store.Users().GetByUsername(ctx, "investor1")
+
+	err := store.Repayments().CreateInstallments(ctx, loanID, []models.Installment{
+		{Number: 2, DueDate: "2025-03-01", Principal: money.FromRupiah(500000), TotalDue: money.FromRupiah(500000), Status: "pending"},
+		{Number: 1, DueDate: "2025-02-01", Principal: money.FromRupiah(500000), TotalDue: money.FromRupiah(500000), Status: "pending"},
+	})
+	if err != nil {
+		t.Fatalf("CreateInstallments failed: %v", err)
+	}
+
+	installments, _ := store.Repayments().Installments(ctx, loanID)
+	if len(installments) != 2 || installments[0].Number != 1 {
+		t.Fatalf("Expected installments ordered by number, got %+v", installments)
+	}
+
+	installments[0].PrincipalPaid = installments[0].Principal
+	installments[0].Status = "paid"
+	if err := store.Repayments().UpdateInstallment(ctx, installments[0]); err != nil {
+		t.Fatalf("UpdateInstallment failed: %v", err)
+	}
+	installments, _ = store.Repayments().Installments(ctx, loanID)
+	if installments[0].Status != "paid" || installments[1].Status != "pending" {
+		t.Errorf("Only the first installment should be paid, got %s / %s", installments[0].Status, installments[1].Status)
+	}
+
+	repaymentID, err := store.Repayments().Create(ctx, models.Repayment{LoanID: loanID, Amount: money.FromRupiah(500000), PaidAt: "2025-02-01", RecordedBy: 1})
+	if err != nil {
+		t.Fatalf("Create repayment failed: %v", err)
+	}
+	store.Payouts().Create(ctx, models.Payout{RepaymentID: repaymentID, LoanID: loanID, InvestorID: investor.ID, Principal: money.FromRupiah(500000), Amount: money.FromRupiah(500000), PaidAt: "2025-02-01"})
+	payouts, err := store.Payouts().ListByInvestor(ctx, loanID, investor.ID)
+	if err != nil || len(payouts) != 1 || payouts[0].RepaymentID != repaymentID {
+		t.Errorf("Unexpected payouts %+v (err %v)", payouts, err)
+	}
+}
+
+func testRollback(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	loanID, err := tx.Loans().Create(ctx, models.Loan{BorrowerIDNumber: "9999999999999999", Amount: money.FromRupiah(1000000), Status: "proposed", RequesterID: 1})
+	if err != nil {
+		t.Fatalf("Create loan in transaction failed: %v", err)
+	}
+	if _, err := tx.Loans().GetForUpdate(ctx, loanID); err != nil {
+		t.Errorf("Loan should be visible inside its transaction: %v", err)
+	}
+	tx.Rollback()
+
+	if _, err := store.Loans().Get(ctx, loanID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Rolled back loan should not exist, got %v", err)
+	}
+
+	tx, _ = store.Begin(ctx)
+	loanID, _ = tx.Loans().Create(ctx, models.Loan{BorrowerIDNumber: "9999999999999999", Amount: money.FromRupiah(1000000), Status: "proposed", RequesterID: 1})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("Rollback after commit should be a no-op, got %v", err)
+	}
+	if _, err := store.Loans().Get(ctx, loanID); err != nil {
+		t.Errorf("Committed loan should exist: %v", err)
+	}
+}
+
+func testTokensAndInvites(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user, _ := store.Users().GetByUsername(ctx, "investor1")
+	if user.EmailVerifiedAt != "" {
+		t.Errorf("New users should start unverified, got %q", user.EmailVerifiedAt)
+	}
+
+	store.Tokens().Create(ctx, models.UserToken{UserID: user.ID, Purpose: "email_verification", TokenHash: "live", ExpiresAt: "2025-01-02T00:00:00Z", CreatedAt: "2025-01-01T00:00:00Z"})
+	store.Tokens().Create(ctx, models.UserToken{UserID: user.ID, Purpose: "email_verification", TokenHash: "stale", ExpiresAt: "2025-01-01T00:00:00Z", CreatedAt: "2025-01-01T00:00:00Z"})
+
+	now := "2025-01-01T12:00:00Z"
+	if _, err := store.Tokens().Consume(ctx, "password_reset", "live", now); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Token should not be usable for another purpose, got %v", err)
+	}
+	if _, err := store.Tokens().Consume(ctx, "email_verification", "stale", now); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expired token should not be usable, got %v", err)
+	}
+	userID, err := store.Tokens().Consume(ctx, "email_verification", "live", now)
+	if err != nil || userID != user.ID {
+		t.Fatalf("Expected token for user %d, got %d (err %v)", user.ID, userID, err)
+	}
+	if _, err := store.Tokens().Consume(ctx, "email_verification", "live", now); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Token should only be usable once, got %v", err)
+	}
+
+	store.Users().MarkEmailVerified(ctx, user.ID, now)
+	if user, _ = store.Users().GetByEmail(ctx, "investor1@email.com"); user.EmailVerifiedAt != now {
+		t.Errorf("Expected email verified at %s, got %q", now, user.EmailVerifiedAt)
+	}
+
+	for _, hash := range []string{"reset-1", "reset-2"} {
+		store.Tokens().Create(ctx, models.UserToken{UserID: user.ID, Purpose: "password_reset", TokenHash: hash, ExpiresAt: "2025-01-02T00:00:00Z", CreatedAt: "2025-01-01T00:00:00Z"})
+	}
+	if err := store.Tokens().ConsumeAll(ctx, user.ID, "password_reset", now); err != nil {
+		t.Errorf("ConsumeAll failed: %v", err)
+	}
+	if _, err := store.Tokens().Consume(ctx, "password_reset", "reset-2", now); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected every reset token to be used up, got %v", err)
+	}
+	store.Users().UpdatePassword(ctx, user.ID, "new-hash")
+	if user, _ = store.Users().GetByID(ctx, user.ID); user.PasswordHash != "new-hash" {
+		t.Errorf("Expected the password hash to be updated, got %q", user.PasswordHash)
+	}
+
+	store.Invites().Create(ctx, models.Invite{Email: "new.admin@email.com", TokenHash: "invite", InvitedBy: 1, ExpiresAt: "2025-01-04T00:00:00Z", CreatedAt: "2025-01-01T00:00:00Z"})
+	if err := store.Invites().Consume(ctx, "invite", "someone@email.com", now); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Invite should only work for its email, got %v", err)
+	}
+	if err := store.Invites().Consume(ctx, "invite", "new.admin@email.com", now); err != nil {
+		t.Errorf("Invite should be redeemable: %v", err)
+	}
+	if err := store.Invites().Consume(ctx, "invite", "new.admin@email.com", now); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Invite should only be redeemable once, got %v", err)
+	}
+}
+
+func testSessions(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user, _ := store.Users().GetByUsername(ctx, "investor1")
+
+	for _, id := range []string{"phone", "laptop"} {
+		if err := store.Sessions().Create(ctx, models.Session{ID: id, UserID: user.ID, CreatedAt: "2025-01-01T00:00:00Z"}); err != nil {
+			t.Fatalf("Failed to create session %s: %v", id, err)
+		}
+	}
+	store.Sessions().AddRefreshToken(ctx, models.RefreshToken{SessionID: "phone", TokenHash: "refresh", ExpiresAt: "2025-02-01T00:00:00Z", CreatedAt: "2025-01-01T00:00:00Z"})
+
+	refresh, err := store.Sessions().GetRefreshToken(ctx, "refresh")
+	if err != nil || refresh.SessionID != "phone" {
+		t.Fatalf("Expected refresh token of session phone, got %+v (err %v)", refresh, err)
+	}
+	if _, err := store.Sessions().GetRefreshToken(ctx, "unknown"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown refresh token, got %v", err)
+	}
+	if used, err := store.Sessions().UseRefreshToken(ctx, "refresh", "2025-01-01T12:00:00Z"); !used || err != nil {
+		t.Errorf("Expected refresh token to be usable, got %v (err %v)", used, err)
+	}
+	if used, _ := store.Sessions().UseRefreshToken(ctx, "refresh", "2025-01-01T13:00:00Z"); used {
+		t.Error("Refresh token should only be usable once")
+	}
+
+	store.Sessions().Revoke(ctx, "phone", "2025-01-02T00:00:00Z")
+	if session, _ := store.Sessions().Get(ctx, "phone"); session.RevokedAt != "2025-01-02T00:00:00Z" {
+		t.Errorf("Expected session phone to be revoked, got %+v", session)
+	}
+	// Already revoked sessions are not counted again
+	if n, err := store.Sessions().RevokeAllForUser(ctx, user.ID, "2025-01-03T00:00:00Z"); n != 1 || err != nil {
+		t.Errorf("Expected 1 session revoked, got %d (err %v)", n, err)
+	}
+	if session, _ := store.Sessions().Get(ctx, "laptop"); session.RevokedAt == "" {
+		t.Error("Expected session laptop to be revoked")
+	}
+
+	store.Revocations().Revoke(ctx, "old", "2025-01-01T00:15:00Z", "2025-01-01T00:05:00Z")
+	store.Revocations().Revoke(ctx, "new", "2025-01-03T00:15:00Z", "2025-01-03T00:05:00Z")
+	if err := store.Revocations().Revoke(ctx, "new", "2025-01-03T00:15:00Z", "2025-01-03T00:06:00Z"); err != nil {
+		t.Errorf("Revoking a token twice should be harmless: %v", err)
+	}
+	if err := store.Revocations().PurgeExpired(ctx, "2025-01-02T00:00:00Z"); err != nil {
+		t.Fatalf("PurgeExpired failed: %v", err)
+	}
+	if revoked, _ := store.Revocations().IsRevoked(ctx, "old"); revoked {
+		t.Error("Expired revocation should have been purged")
+	}
+	if revoked, _ := store.Revocations().IsRevoked(ctx, "new"); !revoked {
+		t.Error("Expected token new to be revoked")
+	}
+}
+
+func testTwoFactor(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user, _ := store.Users().GetByUsername(ctx, "investor1")
+
+	if _, err := store.TwoFactor().GetTOTP(ctx, user.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound before enrollment, got %v", err)
+	}
+	store.TwoFactor().SaveTOTP(ctx, models.TOTPCredential{UserID: user.ID, Secret: "FIRST", CreatedAt: "2025-01-01T00:00:00Z"})
+	store.TwoFactor().ConfirmTOTP(ctx, user.ID, "2025-01-01T00:01:00Z")
+	if used, _ := store.TwoFactor().UseTOTPStep(ctx, user.ID, 100); !used {
+		t.Error("Expected step 100 to be accepted")
+	}
+	for _, step := range []int64{99, 100} {
+		if used, _ := store.TwoFactor().UseTOTPStep(ctx, user.ID, step); used {
+			t.Errorf("Step %d should not be accepted after step 100", step)
+		}
+	}
+
+	// Enrolling again starts over with an unconfirmed secret
+	store.TwoFactor().SaveTOTP(ctx, models.TOTPCredential{UserID: user.ID, Secret: "SECOND", CreatedAt: "2025-01-02T00:00:00Z"})
+	credential, err := store.TwoFactor().GetTOTP(ctx, user.ID)
+	if err != nil || credential.Secret != "SECOND" || credential.ConfirmedAt != "" || credential.LastUsedStep != 0 {
+		t.Errorf("Expected a fresh unconfirmed secret, got %+v (err %v)", credential, err)
+	}
+
+	store.TwoFactor().ReplaceRecoveryCodes(ctx, user.ID, []string{"a", "b"}, "2025-01-02T00:00:00Z")
+	store.TwoFactor().ReplaceRecoveryCodes(ctx, user.ID, []string{"c", "d", "e"}, "2025-01-03T00:00:00Z")
+	if used, _ := store.TwoFactor().UseRecoveryCode(ctx, user.ID, "a", "2025-01-03T00:00:00Z"); used {
+		t.Error("Replaced recovery codes should no longer work")
+	}
+	if used, _ := store.TwoFactor().UseRecoveryCode(ctx, user.ID, "c", "2025-01-03T00:00:00Z"); !used {
+		t.Error("Expected recovery code c to work")
+	}
+	if used, _ := store.TwoFactor().UseRecoveryCode(ctx, user.ID, "c", "2025-01-03T00:00:00Z"); used {
+		t.Error("Recovery codes should only work once")
+	}
+	if n, _ := store.TwoFactor().CountRecoveryCodes(ctx, user.ID); n != 2 {
+		t.Errorf("Expected 2 recovery codes left, got %d", n)
+	}
+
+	store.TwoFactor().DeleteTOTP(ctx, user.ID)
+	if _, err := store.TwoFactor().GetTOTP(ctx, user.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected secret to be deleted, got %v", err)
+	}
+	if n, _ := store.TwoFactor().CountRecoveryCodes(ctx, user.ID); n != 0 {
+		t.Errorf("Expected recovery codes to be deleted, got %d", n)
+	}
+
+	session := models.Session{ID: "2fa", UserID: user.ID, CreatedAt: "2025-01-01T00:00:00Z", TwoFactorVerifiedAt: "2025-01-01T00:00:00Z"}
+	store.Sessions().Create(ctx, session)
+	if got, _ := store.Sessions().Get(ctx, "2fa"); got != session {
+		t.Errorf("Expected session %+v, got %+v", session, got)
+	}
+}
+
+func testRoles(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	admin, _ := store.Users().GetByUsername(ctx, "admin")
+	user, _ := store.Users().GetByUsername(ctx, "investor1")
+
+	// Creating a user grants the role they registered with
+	if roles, err := store.Roles().UserRoles(ctx, user.ID); !reflect.DeepEqual(roles, []string{"investor"}) || err != nil {
+		t.Fatalf("Expected roles [investor], got %v (err %v)", roles, err)
+	}
+	if err := store.Roles().Grant(ctx, user.ID, "requester", admin.ID, "2025-01-01T00:00:00Z"); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if err := store.Roles().Grant(ctx, user.ID, "requester", admin.ID, "2025-01-01T00:00:00Z"); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate granting a held role, got %v", err)
+	}
+	permissions, _ := store.Roles().UserPermissions(ctx, user.ID)
+	want := []string{"investment:create", "loan:cancel:own", "loan:create", "loan:read:investable", "loan:read:own", "payout:read:own", "repayment:record:own"}
+	if !reflect.DeepEqual(permissions, want) {
+		t.Errorf("Expected permissions %v, got %v", want, permissions)
+	}
+	if n, _ := store.Roles().CountUsers(ctx, "requester"); n != 1 {
+		t.Errorf("Expected 1 requester, got %d", n)
+	}
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if n, err := tx.Roles().CountUsersForUpdate(ctx, "requester"); err != nil || n != 1 {
+		t.Errorf("Expected 1 locked requester, got %d (err %v)", n, err)
+	}
+	tx.Rollback()
+
+	if err := store.Roles().Revoke(ctx, user.ID, "requester"); err != nil {
+		t.Errorf("Revoke failed: %v", err)
+	}
+	if err := store.Roles().Revoke(ctx, user.ID, "requester"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking a role not held, got %v", err)
+	}
+	if ok, _ := store.Roles().Exists(ctx, "admin"); !ok {
+		t.Error("Expected role admin to exist")
+	}
+	if ok, _ := store.Roles().Exists(ctx, "auditor"); ok {
+		t.Error("Expected role auditor not to exist")
+	}
+}
+
+// The memory store's roles must match the ones the migrations create.
+func TestRolesMatchMigrations(t *testing.T) {
+	all := stores(t)
+	want, err := all["sqlite"].Roles().List(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list roles: %v", err)
+	}
+	for name, store := range all {
+		if got, _ := store.Roles().List(context.Background()); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: roles differ from the migrations:\n got %+v\nwant %+v", name, got, want)
+		}
+	}
+}
+
+func testLoginFailures(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	failures := store.LoginFailures()
+
+	for i, at := range []string{"2025-01-01T00:00:00Z", "2025-01-01T00:01:00Z", "2025-01-01T00:02:00Z"} {
+		f, err := failures.Record(ctx, "username", "investor1", at, "2024-12-31T23:50:00Z")
+		if err != nil || f.Failures != i+1 || f.LastFailedAt != at {
+			t.Fatalf("Expected failure %d at %s, got %+v (err %v)", i+1, at, f, err)
+		}
+	}
+	failures.Record(ctx, "ip", "192.0.2.1", "2025-01-01T00:02:00Z", "2024-12-31T23:50:00Z")
+	failures.Lock(ctx, "username", "investor1", "2025-01-01T00:17:00Z")
+
+	locked, err := failures.ListLocked(ctx, "2025-01-01T00:03:00Z")
+	if err != nil || len(locked) != 1 || locked[0].Subject != "investor1" || locked[0].LockedUntil != "2025-01-01T00:17:00Z" {
+		t.Errorf("Expected investor1 to be locked, got %+v (err %v)", locked, err)
+	}
+	if locked, _ := failures.ListLocked(ctx, "2025-01-01T00:20:00Z"); len(locked) != 0 {
+		t.Errorf("Expected the lockout to have ended, got %+v", locked)
+	}
+
+	// Failures older than the window start over, lockout included
+	f, _ := failures.Record(ctx, "username", "investor1", "2025-01-01T00:30:00Z", "2025-01-01T00:15:00Z")
+	if f.Failures != 1 || f.LockedUntil != "" {
+		t.Errorf("Expected the count to start over, got %+v", f)
+	}
+
+	if cleared, err := failures.Clear(ctx, "username", "investor1"); !cleared || err != nil {
+		t.Errorf("Expected failures to be cleared, got %v (err %v)", cleared, err)
+	}
+	if _, err := failures.Get(ctx, "username", "investor1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after clearing, got %v", err)
+	}
+	if f, _ := failures.Get(ctx, "ip", "192.0.2.1"); f.Failures != 1 {
+		t.Errorf("Expected the IP's failures to be kept, got %+v", f)
+	}
+}
+
+func testAPIKeys(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	admin, _ := store.Users().GetByUsername(ctx, "admin")
+	keys := store.APIKeys()
+
+	id, err := keys.Create(ctx, models.APIKey{
+		Name: "collections", Prefix: "lse_abcdefgh", KeyHash: "hash-1", Scopes: []string{"loan:read", "repayment:record"},
+		CreatedBy: admin.ID, CreatedAt: "2025-01-01T00:00:00Z", ExpiresAt: "2025-04-01T00:00:00Z",
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := keys.Create(ctx, models.APIKey{Name: "copy", Prefix: "lse_abcdefgh", KeyHash: "hash-1", Scopes: []string{"loan:read"},
+		CreatedBy: admin.ID, CreatedAt: "2025-01-01T00:00:00Z"}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate for a reused hash, got %v", err)
+	}
+
+	keys.Touch(ctx, id, "2025-01-02T00:00:00Z")
+	key, err := keys.GetByHash(ctx, "hash-1")
+	if err != nil || key.ID != id || !reflect.DeepEqual(key.Scopes, []string{"loan:read", "repayment:record"}) ||
+		key.ExpiresAt != "2025-04-01T00:00:00Z" || key.LastUsedAt != "2025-01-02T00:00:00Z" {
+		t.Errorf("Unexpected key %+v (err %v)", key, err)
+	}
+	if _, err := keys.GetByHash(ctx, "hash-2"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown hash, got %v", err)
+	}
+
+	if err := keys.Revoke(ctx, id, "2025-01-03T00:00:00Z"); err != nil {
+		t.Errorf("Revoke failed: %v", err)
+	}
+	if err := keys.Revoke(ctx, id, "2025-01-04T00:00:00Z"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking twice, got %v", err)
+	}
+	if listed, _ := keys.List(ctx); len(listed) != 1 || listed[0].RevokedAt != "2025-01-03T00:00:00Z" {
+		t.Errorf("Expected the revoked key to be listed, got %+v", listed)
+	}
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"loan-service-engine/db"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// querier is what the SQL repositories need; both *sql.DB and *sql.Tx have it.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// rebinder rewrites each query for the dialect before running it, so the
+// repositories can be written once with ? placeholders.
+type rebinder struct {
+	q querier
+	d db.Dialect
+}
+
+func (r rebinder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.q.ExecContext(ctx, r.d.Rebind(query), args...)
+}
+
+func (r rebinder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.q.QueryContext(ctx, r.d.Rebind(query), args...)
+}
+
+func (r rebinder) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.q.QueryRowContext(ctx, r.d.Rebind(query), args...)
+}
+
+type sqlRepositories struct {
+	q querier
+	d db.Dialect
+}
+
+func newSQLRepositories(q querier, d db.Dialect) sqlRepositories {
+	return sqlRepositories{q: rebinder{q, d}, d: d}
+}
+
+func (r sqlRepositories) Users() UserRepository                 { return sqlUsers{r.q} }
+func (r sqlRepositories) Loans() LoanRepository                 { return sqlLoans{r.q, r.d} }
+func (r sqlRepositories) Approvals() ApprovalRepository         { return sqlApprovals{r.q} }
+func (r sqlRepositories) Disbursements() DisbursementRepository { return sqlDisbursements{r.q} }
+func (r sqlRepositories) Documents() DocumentRepository         { return sqlDocuments{r.q} }
+func (r sqlRepositories) Investments() InvestmentRepository     { return sqlInvestments{r.q} }
+func (r sqlRepositories) Repayments() RepaymentRepository       { return sqlRepayments{r.q} }
+func (r sqlRepositories) Payouts() PayoutRepository             { return sqlPayouts{r.q} }
+func (r sqlRepositories) Tokens() TokenRepository               { return sqlTokens{r.q} }
+func (r sqlRepositories) Invites() InviteRepository             { return sqlInvites{r.q} }
+func (r sqlRepositories) Sessions() SessionRepository           { return sqlSessions{r.q} }
+func (r sqlRepositories) Revocations() RevocationRepository     { return sqlRevocations{r.q} }
+func (r sqlRepositories) Roles() RoleRepository                 { return sqlRoles{r.q, r.d} }
+func (r sqlRepositories) LoginFailures() LoginFailureRepository { return sqlLoginFailures{r.q} }
+func (r sqlRepositories) APIKeys() APIKeyRepository             { return sqlAPIKeys{r.q} }
+func (r sqlRepositories) TwoFactor() TwoFactorRepository        { return sqlTwoFactor{r.q} }
+
+// SQLStore keeps the data in the SQLite or Postgres database opened by
+// db.Connect; the dialect is taken from the connection.
+type SQLStore struct {
+	sqlRepositories
+	db *sql.DB
+}
+
+func NewSQLStore(conn *sql.DB) *SQLStore {
+	return &SQLStore{sqlRepositories: newSQLRepositories(conn, db.DialectOf(conn)), db: conn}
+}
+
+// Begin opens a transaction. On SQLite db.Connect sets _txlock=immediate, so
+// this takes the write lock up front and concurrent transactions wait their
+// turn. On Postgres transactions run concurrently and lock what they read
+// through GetForUpdate.
+func (s *SQLStore) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{sqlRepositories: newSQLRepositories(tx, s.d), tx: tx}, nil
+}
+
+type sqlTx struct {
+	sqlRepositories
+	tx *sql.Tx
+}
+
+func (t *sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	if err := t.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+	return nil
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// duplicate turns unique constraint failures from either driver into ErrDuplicate.
+func duplicate(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return ErrDuplicate
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// insertID runs an INSERT and returns the id of the new row. Both SQLite and
+// Postgres support RETURNING; lib/pq has no LastInsertId.
+func insertID(ctx context.Context, q querier, query string, args ...any) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, query+` RETURNING id`, args...).Scan(&id)
+	return id, err
+}
+
+// queryIDs runs a query selecting a single integer column.
+func queryIDs(ctx context.Context, q querier, query string, args ...any) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// nullable stores empty strings and zero IDs as NULL.
+func nullable[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"loan-service-engine/models"
+)
+
+type sqlAPIKeys struct{ q querier }
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+func (r sqlAPIKeys) Create(ctx context.Context, key models.APIKey) (int, error) {
+	id, err := insertID(ctx, r.q, `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "), key.CreatedBy, key.CreatedAt, nullable(key.ExpiresAt))
+	return id, duplicate(err)
+}
+
+func (r sqlAPIKeys) GetByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	return scanAPIKey(r.q.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash))
+}
+
+func (r sqlAPIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r sqlAPIKeys) Revoke(ctx context.Context, id int, revokedAt string) error {
+	res, err := r.q.ExecContext(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, revokedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlAPIKeys) Touch(ctx context.Context, id int, usedAt string) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, usedAt, id)
+	return err
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullString
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &k.CreatedBy, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	k.Scopes = strings.Fields(scopes)
+	k.ExpiresAt, k.LastUsedAt, k.RevokedAt = expiresAt.String, lastUsedAt.String, revokedAt.String
+	return k, notFound(err)
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+
+	"loan-service-engine/models"
+	"loan-service-engine/money"
+)
+
+type sqlInvestments struct{ q querier }
+
+func (r sqlInvestments) Create(ctx context.Context, inv models.Investment) (int, error) {
+	status := inv.Status
+	if status == "" {
+		status = "active"
+	}
+	return insertID(ctx, r.q, `
+		INSERT INTO investments (loan_id, investor_id, amount, investment_date, status)
+		VALUES (?, ?, ?, ?, ?)`,
+		inv.LoanID, inv.InvestorID, inv.Amount, inv.InvestmentDate, status)
+}
+
+func (r sqlInvestments) ListByLoan(ctx context.Context, loanID int) ([]models.Investment, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT i.id, i.loan_id, i.investor_id, u.username, u.email, i.amount, i.investment_date,
+			i.status, COALESCE(i.refunded_at, '')
+		FROM investments i
+		JOIN users u ON u.id = i.investor_id
+		WHERE i.loan_id = ?
+		ORDER BY i.id
+	`, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var investments []models.Investment
+	for rows.Next() {
+		var inv models.Investment
+		if err := rows.Scan(&inv.ID, &inv.LoanID, &inv.InvestorID, &inv.Investor, &inv.Email, &inv.Amount,
+			&inv.InvestmentDate, &inv.Status, &inv.RefundedAt); err != nil {
+			return nil, err
+		}
+		investments = append(investments, inv)
+	}
+	return investments, rows.Err()
+}
+
+func (r sqlInvestments) TotalActive(ctx context.Context, loanID int) (money.Amount, error) {
+	var total money.Amount
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM investments WHERE loan_id = ? AND status = 'active'
+	`, loanID).Scan(&total)
+	return total, err
+}
+
+func (r sqlInvestments) TotalByInvestor(ctx context.Context, loanID, investorID int) (money.Amount, error) {
+	var total money.Amount
+	err := r.q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM investments WHERE loan_id = ? AND investor_id = ?
+	`, loanID, investorID).Scan(&total)
+	return total, err
+}
+
+func (r sqlInvestments) RefundActive(ctx context.Context, loanID int, refundedAt string) error {
+	_, err := r.q.ExecContext(ctx, `
+		UPDATE investments SET status = 'refunded', refunded_at = ?
+		WHERE loan_id = ? AND status = 'active'
+	`, refundedAt, loanID)
+	return err
+}
+
+type sqlRepayments struct{ q querier }
+
+func (r sqlRepayments) CreateInstallments(ctx context.Context, loanID int, installments []models.Installment) error {
+	for _, inst := range installments {
+		_, err := r.q.ExecContext(ctx, `
+			INSERT INTO repayment_schedules
+				(loan_id, installment_number, due_date, principal, interest, fee, total_due, outstanding_balance, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, loanID, inst.Number, inst.DueDate, inst.Principal, inst.Interest, inst.Fee, inst.TotalDue, inst.OutstandingBalance, inst.Status)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r sqlRepayments) Installments(ctx context.Context, loanID int) ([]models.Installment, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT id, installment_number, due_date, principal, interest, fee, total_due, outstanding_balance,
+			principal_paid, interest_paid, fee_paid, status
+		FROM repayment_schedules
+		WHERE loan_id = ?
+		ORDER BY installment_number
+	`, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var installments []models.Installment
+	for rows.Next() {
+		var inst models.Installment
+		if err := rows.Scan(
+			&inst.ID,
+			&inst.Number,
+			&inst.DueDate,
+			&inst.Principal,
+			&inst.Interest,
+			&inst.Fee,
+			&inst.TotalDue,
+			&inst.OutstandingBalance,
+			&inst.PrincipalPaid,
+			&inst.InterestPaid,
+			&inst.FeePaid,
+			&inst.Status,
+		); err != nil {
+			return nil, err
+		}
+		installments = append(installments, inst)
+	}
+	return installments, rows.Err()
+}
+
+func (r sqlRepayments) UpdateInstallment(ctx context.Context, inst models.Installment) error {
+	_, err := r.q.ExecContext(ctx, `
+		UPDATE repayment_schedules
+		SET fee = ?, total_due = ?, principal_paid = ?, interest_paid = ?, fee_paid = ?, status = ?
+		WHERE id = ?
+	`, inst.Fee, inst.TotalDue, inst.PrincipalPaid, inst.InterestPaid, inst.FeePaid, inst.Status, inst.ID)
+	return err
+}
+
+func (r sqlRepayments) Create(ctx context.Context, rp models.Repayment) (int, error) {
+	return insertID(ctx, r.q, `
+		INSERT INTO repayments
+			(loan_id, amount, fee_portion, interest_portion, principal_portion, platform_revenue, paid_at, recorded_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rp.LoanID, rp.Amount, rp.FeePortion, rp.InterestPortion, rp.PrincipalPortion, rp.PlatformRevenue, rp.PaidAt, rp.RecordedBy)
+}
+
+type sqlPayouts struct{ q querier }
+
+func (r sqlPayouts) Create(ctx context.Context, p models.Payout) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO payouts (repayment_id, loan_id, investor_id, principal, interest, amount, paid_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, p.RepaymentID, p.LoanID, p.InvestorID, p.Principal, p.Interest, p.Amount, p.PaidAt)
+	return err
+}
+
+func (r sqlPayouts) ListByInvestor(ctx context.Context, loanID, investorID int) ([]models.PayoutInfo, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT repayment_id, principal, interest, amount, paid_at
+		FROM payouts
+		WHERE loan_id = ? AND investor_id = ?
+		ORDER BY id
+	`, loanID, investorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payouts []models.PayoutInfo
+	for rows.Next() {
+		var p models.PayoutInfo
+		if err := rows.Scan(&p.RepaymentID, &p.Principal, &p.Interest, &p.Amount, &p.PaidAt); err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, p)
+	}
+	return payouts, rows.Err()
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"loan-service-engine/db"
+	"loan-service-engine/models"
+)
+
+type sqlLoans struct {
+	q querier
+	d db.Dialect
+}
+
+const loanColumns = `id, borrower_id_number, amount, rate, roi, tenure_months, repayment_method,
+	funding_window_days, COALESCE(funding_deadline, ''), status, requester_id, COALESCE(agreement_letter_url, '')`
+
+func scanLoan(row interface{ Scan(...any) error }) (models.Loan, error) {
+	var l models.Loan
+	err := row.Scan(&l.ID, &l.BorrowerIDNumber, &l.Amount, &l.Rate, &l.ROI, &l.TenureMonths, &l.RepaymentMethod,
+		&l.FundingWindowDays, &l.FundingDeadline, &l.Status, &l.RequesterID, &l.AgreementLetterURL)
+	return l, err
+}
+
+func (r sqlLoans) Create(ctx context.Context, loan models.Loan) (int, error) {
+	return insertID(ctx, r.q, `
+		INSERT INTO loans (borrower_id_number, amount, rate, roi, tenure_months, repayment_method, funding_window_days, status, requester_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		loan.BorrowerIDNumber, loan.Amount, loan.Rate, loan.ROI, loan.TenureMonths, loan.RepaymentMethod,
+		loan.FundingWindowDays, loan.Status, loan.RequesterID)
+}
+
+func (r sqlLoans) Get(ctx context.Context, id int) (models.Loan, error) {
+	l, err := scanLoan(r.q.QueryRowContext(ctx, `SELECT `+loanColumns+` FROM loans WHERE id = ?`, id))
+	return l, notFound(err)
+}
+
+func (r sqlLoans) GetForUpdate(ctx context.Context, id int) (models.Loan, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans WHERE id = ?`
+	if r.d == db.Postgres {
+		query += ` FOR UPDATE`
+	}
+	l, err := scanLoan(r.q.QueryRowContext(ctx, query, id))
+	return l, notFound(err)
+}
+
+func (r sqlLoans) List(ctx context.Context) ([]models.Loan, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT `+loanColumns+` FROM loans ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []models.Loan
+	for rows.Next() {
+		l, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, l)
+	}
+	return loans, rows.Err()
+}
+
+func (r sqlLoans) UpdateStatus(ctx context.Context, id int, from, to string) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `UPDATE loans SET status = ? WHERE id = ? AND status = ?`, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r sqlLoans) SetFundingDeadline(ctx context.Context, id int, deadline string) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE loans SET funding_deadline = ? WHERE id = ?`, deadline, id)
+	return err
+}
+
+func (r sqlLoans) ListPastFundingDeadline(ctx context.Context, status, before string) ([]int, error) {
+	return queryIDs(ctx, r.q, `
+		SELECT id FROM loans
+		WHERE status = ? AND funding_deadline IS NOT NULL AND funding_deadline < ?
+		ORDER BY id
+	`, status, before)
+}
+
+func (r sqlLoans) AddStatusChange(ctx context.Context, loanID int, change models.StatusChange) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO loan_status_history (loan_id, from_status, to_status, actor_id, reason, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, loanID, nullable(change.FromStatus), change.ToStatus, nullable(change.ActorID), nullable(change.Reason), change.ChangedAt)
+	return err
+}
+
+func (r sqlLoans) StatusHistory(ctx context.Context, loanID int) ([]models.StatusChange, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT h.from_status, h.to_status, h.actor_id, u.username, h.reason, h.changed_at
+		FROM loan_status_history h
+		LEFT JOIN users u ON u.id = h.actor_id
+		WHERE h.loan_id = ?
+		ORDER BY h.id
+	`, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.StatusChange
+	for rows.Next() {
+		var (
+			change              models.StatusChange
+			from, actor, reason sql.NullString
+			actorID             sql.NullInt64
+		)
+		if err := rows.Scan(&from, &change.ToStatus, &actorID, &actor, &reason, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		change.FromStatus = from.String
+		change.ActorID = int(actorID.Int64)
+		change.Actor = actor.String
+		change.Reason = reason.String
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+type sqlApprovals struct{ q querier }
+
+func (r sqlApprovals) Create(ctx context.Context, loanID int, a models.ApprovalInfo) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO approvals (loan_id, validator_id, proof_url, approved_at,
+			proof_thumbnail_url, photo_taken_at, latitude, longitude)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, loanID, a.ValidatorID, a.ProofURL, a.ApprovedAt,
+		nullable(a.ThumbnailURL), nullable(a.PhotoTakenAt), a.Latitude, a.Longitude)
+	return err
+}
+
+func (r sqlApprovals) Get(ctx context.Context, loanID int) (models.ApprovalInfo, error) {
+	var a models.ApprovalInfo
+	var latitude, longitude sql.NullFloat64
+	err := r.q.QueryRowContext(ctx, `
+		SELECT validator_id, approved_at, proof_url, COALESCE(proof_thumbnail_url, ''), COALESCE(photo_taken_at, ''),
+			latitude, longitude
+		FROM approvals WHERE loan_id = ?
+	`, loanID).Scan(&a.ValidatorID, &a.ApprovedAt, &a.ProofURL, &a.ThumbnailURL, &a.PhotoTakenAt, &latitude, &longitude)
+	if latitude.Valid && longitude.Valid {
+		a.Latitude, a.Longitude = &latitude.Float64, &longitude.Float64
+	}
+	return a, notFound(err)
+}
+
+func (r sqlApprovals) ListByProof(ctx context.Context, key string) ([]int, error) {
+	return queryIDs(ctx, r.q, `SELECT loan_id FROM approvals WHERE proof_url = ? ORDER BY loan_id`, key)
+}
+
+func (r sqlApprovals) Delete(ctx context.Context, loanID int) error {
+	_, err := r.q.ExecContext(ctx, `DELETE FROM approvals WHERE loan_id = ?`, loanID)
+	return err
+}
+
+type sqlDisbursements struct{ q querier }
+
+func (r sqlDisbursements) Create(ctx context.Context, loanID, adminID int, d models.DisbursementInfo) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO disbursements (loan_id, disbursed_at, field_officer_id, agreement_url, admin_id)
+		VALUES (?, ?, ?, ?, ?)
+	`, loanID, d.DisbursedAt, d.OfficerID, d.SignedAgreementURL, adminID)
+	return err
+}
+
+func (r sqlDisbursements) Get(ctx context.Context, loanID int) (models.DisbursementInfo, error) {
+	var d models.DisbursementInfo
+	err := r.q.QueryRowContext(ctx, `
+		SELECT field_officer_id, disbursed_at, agreement_url FROM disbursements WHERE loan_id = ?
+	`, loanID).Scan(&d.OfficerID, &d.DisbursedAt, &d.SignedAgreementURL)
+	return d, notFound(err)
+}
+
+func (r sqlDisbursements) ListByAgreement(ctx context.Context, key string) ([]int, error) {
+	return queryIDs(ctx, r.q, `SELECT loan_id FROM disbursements WHERE agreement_url = ? ORDER BY loan_id`, key)
+}
+
+func (r sqlDisbursements) Delete(ctx context.Context, loanID int) error {
+	_, err := r.q.ExecContext(ctx, `DELETE FROM disbursements WHERE loan_id = ?`, loanID)
+	return err
+}
+
+type sqlDocuments struct{ q querier }
+
+const documentColumns = `sha256, storage_key, size, content_type, created_at, scan_status, COALESCE(scanned_at, '')`
+
+func scanDocument(row interface{ Scan(...any) error }) (models.Document, error) {
+	var doc models.Document
+	err := row.Scan(&doc.SHA256, &doc.Key, &doc.Size, &doc.ContentType, &doc.CreatedAt, &doc.ScanStatus, &doc.ScannedAt)
+	return doc, notFound(err)
+}
+
+func (r sqlDocuments) Create(ctx context.Context, doc models.Document) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+		INSERT INTO documents (sha256, storage_key, size, content_type, created_at, scan_status, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (sha256) DO NOTHING
+	`, doc.SHA256, doc.Key, doc.Size, doc.ContentType, doc.CreatedAt, doc.ScanStatus, nullable(doc.ScannedAt))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r sqlDocuments) GetByKey(ctx context.Context, key string) (models.Document, error) {
+	return scanDocument(r.q.QueryRowContext(ctx, `SELECT `+documentColumns+` FROM documents WHERE storage_key = ?`, key))
+}
+
+func (r sqlDocuments) ListByScanStatus(ctx context.Context, status string) ([]models.Document, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT `+documentColumns+` FROM documents WHERE scan_status = ? ORDER BY created_at`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []models.Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+func (r sqlDocuments) SetScanStatus(ctx context.Context, sha256, status, scannedAt string) error {
+	res, err := r.q.ExecContext(ctx, `UPDATE documents SET scan_status = ?, scanned_at = ? WHERE sha256 = ?`, status, scannedAt, sha256)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlDocuments) Delete(ctx context.Context, sha256 string) error {
+	_, err := r.q.ExecContext(ctx, `DELETE FROM documents WHERE sha256 = ?`, sha256)
+	return err
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"loan-service-engine/models"
+)
+
+type sqlLoginFailures struct{ q querier }
+
+const loginFailureColumns = `scope, subject, failures, last_failed_at, locked_until`
+
+func (r sqlLoginFailures) Record(ctx context.Context, scope, subject, failedAt, since string) (models.LoginFailure, error) {
+	return scanLoginFailure(r.q.QueryRowContext(ctx, `
+		INSERT INTO login_failures (scope, subject, failures, last_failed_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failed_at <= ? THEN 1 ELSE login_failures.failures + 1 END,
+			locked_until = CASE WHEN login_failures.last_failed_at <= ? THEN NULL ELSE login_failures.locked_until END,
+			last_failed_at = excluded.last_failed_at
+		RETURNING `+loginFailureColumns,
+		scope, subject, failedAt, since, since))
+}
+
+func (r sqlLoginFailures) Get(ctx context.Context, scope, subject string) (models.LoginFailure, error) {
+	return scanLoginFailure(r.q.QueryRowContext(ctx, `SELECT `+loginFailureColumns+` FROM login_failures WHERE scope = ? AND subject = ?`,
+		scope, subject))
+}
+
+func (r sqlLoginFailures) Lock(ctx context.Context, scope, subject, until string) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE login_failures SET locked_until = ? WHERE scope = ? AND subject = ?`,
+		until, scope, subject)
+	return err
+}
+
+func (r sqlLoginFailures) Clear(ctx context.Context, scope, subject string) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `DELETE FROM login_failures WHERE scope = ? AND subject = ?`, scope, subject)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r sqlLoginFailures) ListLocked(ctx context.Context, now string) ([]models.LoginFailure, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT `+loginFailureColumns+` FROM login_failures WHERE locked_until > ? ORDER BY locked_until
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locked := []models.LoginFailure{}
+	for rows.Next() {
+		f, err := scanLoginFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		locked = append(locked, f)
+	}
+	return locked, rows.Err()
+}
+
+func scanLoginFailure(row interface{ Scan(...any) error }) (models.LoginFailure, error) {
+	var f models.LoginFailure
+	var lockedUntil sql.NullString
+	err := row.Scan(&f.Scope, &f.Subject, &f.Failures, &f.LastFailedAt, &lockedUntil)
+	f.LockedUntil = lockedUntil.String
+	return f, notFound(err)
+}
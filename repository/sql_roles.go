@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+
+	"loan-service-engine/db"
+	"loan-service-engine/models"
+)
+
+type sqlRoles struct {
+	q querier
+	d db.Dialect
+}
+
+func (r sqlRoles) List(ctx context.Context) ([]models.Role, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT r.name, r.description, rp.permission
+		FROM roles r LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var name, description string
+		var permission *string
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, models.Role{Name: name, Description: description, Permissions: []string{}})
+		}
+		if permission != nil {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, *permission)
+		}
+	}
+	return roles, rows.Err()
+}
+
+func (r sqlRoles) Exists(ctx context.Context, role string) (bool, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM roles WHERE name = ?`, role).Scan(&n)
+	return n > 0, err
+}
+
+func (r sqlRoles) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	return r.names(ctx, `SELECT role FROM user_roles WHERE user_id = ? ORDER BY role`, userID)
+}
+
+func (r sqlRoles) UserPermissions(ctx context.Context, userID int) ([]string, error) {
+	return r.names(ctx, `
+		SELECT DISTINCT rp.permission
+		FROM user_roles ur JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id = ?
+		ORDER BY rp.permission
+	`, userID)
+}
+
+func (r sqlRoles) names(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (r sqlRoles) Grant(ctx context.Context, userID int, role string, grantedBy int, grantedAt string) error {
+	_, err := r.q.ExecContext(ctx, `INSERT INTO user_roles (user_id, role, granted_by, granted_at) VALUES (?, ?, ?, ?)`,
+		userID, role, nullable(grantedBy), grantedAt)
+	return duplicate(err)
+}
+
+func (r sqlRoles) Revoke(ctx context.Context, userID int, role string) error {
+	res, err := r.q.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = ? AND role = ?`, userID, role)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlRoles) CountUsers(ctx context.Context, role string) (int, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_roles WHERE role = ?`, role).Scan(&n)
+	return n, err
+}
+
+// CountUsersForUpdate locks the rows it counts; Postgres refuses FOR UPDATE
+// on an aggregate, so the rows are read and counted here.
+func (r sqlRoles) CountUsersForUpdate(ctx context.Context, role string) (int, error) {
+	query := `SELECT user_id FROM user_roles WHERE role = ?`
+	if r.d == db.Postgres {
+		query += ` FOR UPDATE`
+	}
+	rows, err := r.q.QueryContext(ctx, query, role)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"loan-service-engine/models"
+)
+
+type sqlSessions struct{ q querier }
+
+func (r sqlSessions) Create(ctx context.Context, s models.Session) error {
+	_, err := r.q.ExecContext(ctx, `INSERT INTO sessions (id, user_id, created_at, two_factor_verified_at) VALUES (?, ?, ?, ?)`,
+		s.ID, s.UserID, s.CreatedAt, nullable(s.TwoFactorVerifiedAt))
+	return err
+}
+
+func (r sqlSessions) Get(ctx context.Context, id string) (models.Session, error) {
+	var s models.Session
+	var revokedAt, twoFactorAt sql.NullString
+	err := r.q.QueryRowContext(ctx, `SELECT id, user_id, created_at, revoked_at, two_factor_verified_at FROM sessions WHERE id = ?`, id).
+		Scan(&s.ID, &s.UserID, &s.CreatedAt, &revokedAt, &twoFactorAt)
+	s.RevokedAt, s.TwoFactorVerifiedAt = revokedAt.String, twoFactorAt.String
+	return s, notFound(err)
+}
+
+func (r sqlSessions) Revoke(ctx context.Context, id, revokedAt string) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, revokedAt, id)
+	return err
+}
+
+func (r sqlSessions) RevokeAllForUser(ctx context.Context, userID int, revokedAt string) (int, error) {
+	res, err := r.q.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, revokedAt, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r sqlSessions) AddRefreshToken(ctx context.Context, t models.RefreshToken) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (session_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+	`, t.SessionID, t.TokenHash, t.ExpiresAt, t.CreatedAt)
+	return err
+}
+
+func (r sqlSessions) GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	var t models.RefreshToken
+	var usedAt sql.NullString
+	err := r.q.QueryRowContext(ctx, `
+		SELECT session_id, token_hash, expires_at, created_at, used_at FROM refresh_tokens WHERE token_hash = ?
+	`, tokenHash).Scan(&t.SessionID, &t.TokenHash, &t.ExpiresAt, &t.CreatedAt, &usedAt)
+	t.UsedAt = usedAt.String
+	return t, notFound(err)
+}
+
+func (r sqlSessions) UseRefreshToken(ctx context.Context, tokenHash, usedAt string) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL`, usedAt, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+type sqlRevocations struct{ q querier }
+
+func (r sqlRevocations) Revoke(ctx context.Context, jti, expiresAt, revokedAt string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO revoked_tokens (jti, expires_at, revoked_at) VALUES (?, ?, ?)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt, revokedAt)
+	return err
+}
+
+func (r sqlRevocations) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&n)
+	return n > 0, err
+}
+
+func (r sqlRevocations) PurgeExpired(ctx context.Context, now string) error {
+	_, err := r.q.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < ?`, now)
+	return err
+}
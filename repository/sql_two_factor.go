@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"loan-service-engine/models"
+)
+
+type sqlTwoFactor struct{ q querier }
+
+func (r sqlTwoFactor) GetTOTP(ctx context.Context, userID int) (models.TOTPCredential, error) {
+	var c models.TOTPCredential
+	var confirmedAt sql.NullString
+	err := r.q.QueryRowContext(ctx, `
+		SELECT user_id, secret, created_at, confirmed_at, last_used_step FROM totp_credentials WHERE user_id = ?
+	`, userID).Scan(&c.UserID, &c.Secret, &c.CreatedAt, &confirmedAt, &c.LastUsedStep)
+	c.ConfirmedAt = confirmedAt.String
+	return c, notFound(err)
+}
+
+func (r sqlTwoFactor) SaveTOTP(ctx context.Context, c models.TOTPCredential) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO totp_credentials (user_id, secret, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = excluded.secret, created_at = excluded.created_at, confirmed_at = NULL, last_used_step = 0
+	`, c.UserID, c.Secret, c.CreatedAt)
+	return err
+}
+
+func (r sqlTwoFactor) ConfirmTOTP(ctx context.Context, userID int, confirmedAt string) error {
+	res, err := r.q.ExecContext(ctx, `UPDATE totp_credentials SET confirmed_at = ? WHERE user_id = ?`, confirmedAt, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlTwoFactor) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+		UPDATE totp_credentials SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r sqlTwoFactor) DeleteTOTP(ctx context.Context, userID int) error {
+	if _, err := r.q.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	_, err := r.q.ExecContext(ctx, `DELETE FROM totp_credentials WHERE user_id = ?`, userID)
+	return err
+}
+
+func (r sqlTwoFactor) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, createdAt string) error {
+	if _, err := r.q.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := r.q.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`,
+			userID, hash, createdAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r sqlTwoFactor) UseRecoveryCode(ctx context.Context, userID int, codeHash, usedAt string) (bool, error) {
+	res, err := r.q.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, usedAt, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r sqlTwoFactor) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"loan-service-engine/models"
+)
+
+type sqlUsers struct{ q querier }
+
+const userColumns = `id, username, email, password, role, email_verified_at`
+
+func (r sqlUsers) Create(ctx context.Context, user models.User) (int, error) {
+	id, err := insertID(ctx, r.q, `
+		INSERT INTO users (username, email, password, role, email_verified_at) VALUES (?, ?, ?, ?, ?)`,
+		user.Username, user.Email, user.PasswordHash, user.Role, nullable(user.EmailVerifiedAt))
+	if err != nil {
+		return 0, duplicate(err)
+	}
+	_, err = r.q.ExecContext(ctx, `INSERT INTO user_roles (user_id, role, granted_at) VALUES (?, ?, ?)`,
+		id, user.Role, time.Now().UTC().Format(time.RFC3339))
+	return id, err
+}
+
+func (r sqlUsers) GetByID(ctx context.Context, id int) (models.User, error) {
+	return r.get(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+}
+
+func (r sqlUsers) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	return r.get(ctx, `SELECT `+userColumns+` FROM users WHERE username = ?`, username)
+}
+
+func (r sqlUsers) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	return r.get(ctx, `SELECT `+userColumns+` FROM users WHERE email = ?`, email)
+}
+
+func (r sqlUsers) get(ctx context.Context, query string, arg any) (models.User, error) {
+	var u models.User
+	var verifiedAt sql.NullString
+	err := r.q.QueryRowContext(ctx, query, arg).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Role, &verifiedAt)
+	u.EmailVerifiedAt = verifiedAt.String
+	return u, notFound(err)
+}
+
+func (r sqlUsers) MarkEmailVerified(ctx context.Context, id int, verifiedAt string) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE users SET email_verified_at = ? WHERE id = ?`, verifiedAt, id)
+	return err
+}
+
+func (r sqlUsers) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE users SET password = ? WHERE id = ?`, passwordHash, id)
+	return err
+}
+
+type sqlTokens struct{ q querier }
+
+func (r sqlTokens) Create(ctx context.Context, t models.UserToken) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, t.UserID, t.Purpose, t.TokenHash, t.ExpiresAt, t.CreatedAt)
+	return err
+}
+
+func (r sqlTokens) Consume(ctx context.Context, purpose, tokenHash, now string) (int, error) {
+	var userID int
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE user_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`, now, tokenHash, purpose, now).Scan(&userID)
+	return userID, notFound(err)
+}
+
+func (r sqlTokens) ConsumeAll(ctx context.Context, userID int, purpose, now string) error {
+	_, err := r.q.ExecContext(ctx, `
+		UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, now, userID, purpose)
+	return err
+}
+
+type sqlInvites struct{ q querier }
+
+func (r sqlInvites) Create(ctx context.Context, inv models.Invite) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO admin_invites (email, token_hash, invited_by, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, inv.Email, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt, inv.CreatedAt)
+	return err
+}
+
+func (r sqlInvites) Consume(ctx context.Context, tokenHash, email, now string) error {
+	var id int
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE admin_invites SET used_at = ?
+		WHERE token_hash = ? AND email = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING id
+	`, now, tokenHash, email, now).Scan(&id)
+	return notFound(err)
+}
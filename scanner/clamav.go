@@ -0,0 +1,101 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is how much of a file is sent to clamd in one INSTREAM chunk.
+const chunkSize = 64 << 10
+
+// ClamAV scans files with a ClamAV daemon, streaming them over its socket
+// with the INSTREAM command. clamd refuses streams over its StreamMaxLength
+// setting, which must allow the largest upload.
+type ClamAV struct {
+	network, address string
+	timeout          time.Duration
+}
+
+// NewClamAV connects to clamd at address, either host:port or
+// unix:/path/to/clamd.sock. A scan that takes longer than timeout fails.
+func NewClamAV(address string, timeout time.Duration) (*ClamAV, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+	} else if _, _, err := net.SplitHostPort(address); err != nil || strings.Contains(address, "/") {
+		return nil, fmt.Errorf("clamd address must be host:port or unix:/path, got %q", address)
+	}
+	if address == "" {
+		return nil, errors.New("clamd address is empty")
+	}
+	return &ClamAV{network: network, address: address, timeout: timeout}, nil
+}
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// The z prefix makes clamd read and answer NUL-terminated lines. The
+	// stream is sent as length-prefixed chunks ended by a zero length.
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd closes the connection when the stream is too long;
+				// its reply says so
+				break
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return Result{}, err
+		}
+	}
+	// The terminator cannot be sent when clamd has already hung up; its
+	// reply, if it left one, says why
+	_, writeErr := conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if reply == "" {
+		if writeErr != nil {
+			err = writeErr
+		}
+		return Result{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply reads clamd's answer to INSTREAM: "stream: OK",
+// "stream: <signature> FOUND" or a message ending in "ERROR".
+func parseReply(reply string) (Result, error) {
+	verdict, ok := strings.CutPrefix(reply, "stream: ")
+	switch {
+	case ok && verdict == "OK":
+		return Result{}, nil
+	case ok && strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
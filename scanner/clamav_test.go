@@ -0,0 +1,153 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the standard antivirus test file.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers INSTREAM like clamd: it finds the EICAR test file and
+// refuses streams over maxLength.
+func fakeClamd(t *testing.T, network, address string, maxLength int) string {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, maxLength)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveClamd(conn net.Conn, maxLength int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+	var stream bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if stream.Len()+int(size) > maxLength {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+		if _, err := io.CopyN(&stream, r, int64(size)); err != nil {
+			return
+		}
+	}
+	if bytes.Contains(stream.Bytes(), []byte(eicar)) {
+		io.WriteString(conn, "stream: Win.Test.EICAR_HDB-1 FOUND\x00")
+	} else {
+		io.WriteString(conn, "stream: OK\x00")
+	}
+}
+
+func scan(t *testing.T, c *ClamAV, content string) (Result, error) {
+	t.Helper()
+	return c.Scan(context.Background(), strings.NewReader(content))
+}
+
+func TestClamAV(t *testing.T) {
+	c, err := NewClamAV(fakeClamd(t, "tcp", "127.0.0.1:0", 1<<20), time.Second)
+	if err != nil {
+		t.Fatalf("NewClamAV failed: %v", err)
+	}
+	if result, err := scan(t, c, "%PDF-1.4 a signed agreement"); err != nil || result.Infected {
+		t.Errorf("Expected a clean file to pass, got %+v (err %v)", result, err)
+	}
+	// Spread over several chunks
+	infected := strings.Repeat("a", 100_000) + eicar + strings.Repeat("b", 100_000)
+	if result, err := scan(t, c, infected); err != nil || !result.Infected || result.Signature != "Win.Test.EICAR_HDB-1" {
+		t.Errorf("Expected the EICAR file to be found, got %+v (err %v)", result, err)
+	}
+	if _, err := scan(t, c, strings.Repeat("a", 2<<20)); err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Errorf("Expected clamd's error for a stream over its limit, got %v", err)
+	}
+}
+
+func TestClamAVUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	fakeClamd(t, "unix", socket, 1<<20)
+	c, err := NewClamAV("unix:"+socket, time.Second)
+	if err != nil {
+		t.Fatalf("NewClamAV failed: %v", err)
+	}
+	if result, err := scan(t, c, eicar); err != nil || !result.Infected {
+		t.Errorf("Expected the EICAR file to be found, got %+v (err %v)", result, err)
+	}
+}
+
+func TestClamAVUnavailable(t *testing.T) {
+	// Nothing listens on a closed listener's port
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	ln.Close()
+	c, _ := NewClamAV(ln.Addr().String(), time.Second)
+	if _, err := scan(t, c, "hello"); err == nil {
+		t.Error("Expected an error when clamd is down")
+	}
+
+	// A daemon that accepts but never answers
+	hung, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer hung.Close()
+	go func() {
+		conn, err := hung.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	c, _ = NewClamAV(hung.Addr().String(), 100*time.Millisecond)
+	if _, err := scan(t, c, "hello"); err == nil {
+		t.Error("Expected a scan to time out")
+	}
+
+	// A daemon that hangs up without a verdict
+	closing, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer closing.Close()
+	go func() {
+		for {
+			conn, err := closing.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	c, _ = NewClamAV(closing.Addr().String(), time.Second)
+	if _, err := scan(t, c, strings.Repeat("a", 200_000)); err == nil {
+		t.Error("Expected an error when clamd hangs up without a verdict")
+	}
+}
+
+func TestNewClamAV(t *testing.T) {
+	for _, address := range []string{"", "localhost", "unix:", "tcp://localhost"} {
+		if _, err := NewClamAV(address, time.Second); err == nil {
+			t.Errorf("Expected %q to be refused", address)
+		}
+	}
+}
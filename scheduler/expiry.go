@@ -0,0 +1,86 @@
+// Package scheduler runs the service's periodic background jobs.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"loan-service-engine/loanstate"
+	"loan-service-engine/repository"
+	"loan-service-engine/utils"
+)
+
+// StartFundingExpiry checks for approved loans past their funding deadline
+// every interval until ctx is cancelled. Investors are notified through mail,
+// the handlers' mail hook.
+func StartFundingExpiry(ctx context.Context, store repository.Store, mail func(utils.EmailPreview), interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := ExpireOverdueLoans(ctx, store, mail, time.Now()); err != nil {
+			log.Println("Funding expiry run failed:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireOverdueLoans moves every approved loan whose funding deadline is
+// before now to 'expired', releasing its investments and mailing the
+// investors. It returns the IDs of the loans that were expired.
+func ExpireOverdueLoans(ctx context.Context, store repository.Store, mail func(utils.EmailPreview), now time.Time) ([]int, error) {
+	candidates, err := store.Loans().ListPastFundingDeadline(ctx, string(loanstate.Approved), now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	// The loans are expired by the same clock they were picked by
+	ctx = loanstate.WithNow(ctx, now)
+	var expired []int
+	for _, loanID := range candidates {
+		if err := expireLoan(ctx, store, loanID); err != nil {
+			log.Printf("Failed to expire loan #%d: %v", loanID, err)
+			continue
+		}
+		log.Printf("Loan #%d expired: funding deadline passed", loanID)
+		notifyInvestorsOfExpiry(ctx, store, mail, loanID)
+		expired = append(expired, loanID)
+	}
+	return expired, nil
+}
+
+func expireLoan(ctx context.Context, store repository.Store, loanID int) error {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := loanstate.Default.Transition(ctx, tx, loanID, loanstate.Expired, 0, "funding deadline passed"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func notifyInvestorsOfExpiry(ctx context.Context, store repository.Store, mail func(utils.EmailPreview), loanID int) {
+	loan, err := store.Loans().Get(ctx, loanID)
+	if err != nil {
+		log.Println("Error fetching loan for expiry notification:", err)
+		return
+	}
+	investments, err := store.Investments().ListByLoan(ctx, loanID)
+	if err != nil {
+		log.Println("Error fetching investors for expiry notification:", err)
+		return
+	}
+
+	for _, r := range loanstate.Refunds(investments) {
+		mail(utils.ComposeExpiryEmail(r.Email, loanID, r.Amount, loan.FundingDeadline))
+	}
+}
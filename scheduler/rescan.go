@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"loan-service-engine/loanstate"
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/scanner"
+	"loan-service-engine/storage"
+)
+
+// StartRescans scans quarantined uploads again every interval until ctx is
+// cancelled.
+func StartRescans(ctx context.Context, store repository.Store, files storage.Storage, scan scanner.Scanner, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := RescanQuarantined(ctx, store, files, scan); err != nil {
+			log.Println("Quarantine rescan failed:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RescanQuarantined scans every quarantined upload again. A clean one is
+// released and the approvals and disbursements that were waiting for it go
+// through. An infected one is deleted along with the approvals and
+// disbursements waiting for it, so the loan can be approved or disbursed
+// again with another file. It returns the IDs of the loans that moved on.
+func RescanQuarantined(ctx context.Context, store repository.Store, files storage.Storage, scan scanner.Scanner) ([]int, error) {
+	docs, err := store.Documents().ListByScanStatus(ctx, models.ScanQuarantined)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []int
+	discarded := map[string]bool{}
+	for _, doc := range docs {
+		if discarded[doc.SHA256] {
+			// Thumbnail of an infected proof, already gone
+			continue
+		}
+		result, err := scanStored(ctx, files, scan, doc.Key)
+		if err != nil {
+			log.Printf("Rescanning %s failed, it stays quarantined: %v", doc.Key, err)
+			continue
+		}
+		if result.Infected {
+			gone, err := discardInfected(ctx, store, files, doc, result.Signature)
+			if err != nil {
+				log.Printf("Failed to discard infected upload %s: %v", doc.Key, err)
+			}
+			for _, d := range gone {
+				discarded[d.SHA256] = true
+			}
+			continue
+		}
+		loanIDs, err := release(ctx, store, doc)
+		if err != nil {
+			log.Printf("Failed to release %s from quarantine: %v", doc.Key, err)
+			continue
+		}
+		moved = append(moved, loanIDs...)
+	}
+	return moved, nil
+}
+
+func scanStored(ctx context.Context, files storage.Storage, scan scanner.Scanner, key string) (scanner.Result, error) {
+	f, err := files.Get(ctx, key)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	defer f.Close()
+	return scan.Scan(ctx, f)
+}
+
+// release marks doc clean and makes the transitions that were waiting for it.
+func release(ctx context.Context, store repository.Store, doc models.Document) ([]int, error) {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Documents().SetScanStatus(ctx, doc.SHA256, models.ScanClean, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	approvals, err := tx.Approvals().ListByProof(ctx, doc.Key)
+	if err != nil {
+		return nil, err
+	}
+	disbursements, err := tx.Disbursements().ListByAgreement(ctx, doc.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []int
+	for _, waiting := range []struct {
+		loanIDs []int
+		to      loanstate.State
+		reason  string
+	}{
+		{approvals, loanstate.Approved, "visit proof passed the virus scan"},
+		{disbursements, loanstate.Disbursed, "signed agreement passed the virus scan"},
+	} {
+		for _, loanID := range waiting.loanIDs {
+			_, err := loanstate.Default.Transition(ctx, tx, loanID, waiting.to, 0, waiting.reason)
+			var invalid *loanstate.InvalidTransitionError
+			var blocked *loanstate.GuardError
+			if errors.As(err, &invalid) || errors.As(err, &blocked) {
+				// Moved on already, or still waiting for something else
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			log.Printf("Loan #%d %s: %s", loanID, waiting.to, waiting.reason)
+			moved = append(moved, loanID)
+		}
+	}
+	return moved, tx.Commit()
+}
+
+// discardInfected deletes an infected upload and the approvals and
+// disbursements still waiting for it, with their proof thumbnails. It
+// returns the documents it deleted.
+func discardInfected(ctx context.Context, store repository.Store, files storage.Storage, doc models.Document, signature string) ([]models.Document, error) {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	discard := []models.Document{doc}
+	approvals, err := tx.Approvals().ListByProof(ctx, doc.Key)
+	if err != nil {
+		return nil, err
+	}
+	for _, loanID := range approvals {
+		loan, err := tx.Loans().Get(ctx, loanID)
+		if err != nil {
+			return nil, err
+		}
+		if loanstate.State(loan.Status) != loanstate.Proposed {
+			continue
+		}
+		approval, err := tx.Approvals().Get(ctx, loanID)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Approvals().Delete(ctx, loanID); err != nil {
+			return nil, err
+		}
+		if thumbnail, err := tx.Documents().GetByKey(ctx, approval.ThumbnailURL); err == nil {
+			discard = append(discard, thumbnail)
+		}
+		log.Printf("Approval of loan #%d removed: visit proof %s is infected with %s", loanID, doc.Key, signature)
+	}
+	disbursements, err := tx.Disbursements().ListByAgreement(ctx, doc.Key)
+	if err != nil {
+		return nil, err
+	}
+	for _, loanID := range disbursements {
+		loan, err := tx.Loans().Get(ctx, loanID)
+		if err != nil {
+			return nil, err
+		}
+		if loanstate.State(loan.Status) != loanstate.Invested {
+			continue
+		}
+		if err := tx.Disbursements().Delete(ctx, loanID); err != nil {
+			return nil, err
+		}
+		log.Printf("Disbursement of loan #%d removed: signed agreement %s is infected with %s", loanID, doc.Key, signature)
+	}
+
+	for _, d := range discard {
+		if err := tx.Documents().Delete(ctx, d.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, d := range discard {
+		if err := files.Delete(ctx, d.Key); err != nil {
+			log.Printf("Failed to remove infected upload %s: %v", d.Key, err)
+		}
+	}
+	return discard, nil
+}
@@ -0,0 +1,178 @@
+package scheduler_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"loan-service-engine/models"
+	"loan-service-engine/repository"
+	"loan-service-engine/scanner"
+	"loan-service-engine/scheduler"
+	"loan-service-engine/storage"
+)
+
+// verdict answers every scan with the same result, or fails with err.
+type verdict struct {
+	result scanner.Result
+	err    error
+}
+
+func (v verdict) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	io.Copy(io.Discard, r)
+	return v.result, v.err
+}
+
+var infected = verdict{result: scanner.Result{Infected: true, Signature: "Eicar-Test-Signature"}}
+
+// rescanEnv holds a memory store with loan 1 proposed and waiting for its
+// visit proof, loan 2 invested and waiting for its signed agreement, and
+// loan 3 approved with a proof that was clean when uploaded.
+type rescanEnv struct {
+	store                       repository.Store
+	files                       storage.Storage
+	proof, thumbnail, agreement models.Document
+}
+
+func newRescanEnv(t *testing.T) rescanEnv {
+	ctx := context.Background()
+	env := rescanEnv{store: repository.NewMemoryStore(), files: storage.NewLocal(t.TempDir(), "/files/", nil)}
+	adminID, _ := env.store.Users().Create(ctx, models.User{Username: "admin", Email: "admin@email.com", Role: "admin"})
+	for _, status := range []string{"proposed", "invested", "approved"} {
+		env.store.Loans().Create(ctx, models.Loan{BorrowerIDNumber: "1111111111111111", Amount: 1000000, Rate: 12, TenureMonths: 6,
+			RepaymentMethod: "flat", Status: status, RequesterID: adminID})
+	}
+
+	store := func(content, ext, status string) models.Document {
+		sum := sha256.Sum256([]byte(content))
+		doc := models.Document{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content)), CreatedAt: "2025-06-25T00:00:00Z", ScanStatus: status}
+		doc.Key = doc.SHA256 + ext
+		if err := env.files.Put(ctx, doc.Key, strings.NewReader(content), "application/octet-stream"); err != nil {
+			t.Fatalf("Failed to store %s: %v", doc.Key, err)
+		}
+		env.store.Documents().Create(ctx, doc)
+		return doc
+	}
+	env.proof = store("visit proof", ".jpg", models.ScanQuarantined)
+	env.thumbnail = store("thumbnail", ".jpg", models.ScanQuarantined)
+	env.agreement = store("%PDF-1.4 signed agreement", ".pdf", models.ScanQuarantined)
+	earlier := store("earlier visit proof", ".jpg", models.ScanClean)
+
+	env.store.Approvals().Create(ctx, 1, models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-06-25", ProofURL: env.proof.Key, ThumbnailURL: env.thumbnail.Key})
+	env.store.Disbursements().Create(ctx, 2, adminID, models.DisbursementInfo{OfficerID: "EMP999", DisbursedAt: "2025-06-26", SignedAgreementURL: env.agreement.Key})
+	env.store.Approvals().Create(ctx, 3, models.ApprovalInfo{ValidatorID: "EMP001", ApprovedAt: "2025-06-20", ProofURL: earlier.Key})
+	return env
+}
+
+func (env rescanEnv) status(t *testing.T, loanID int) string {
+	loan, err := env.store.Loans().Get(context.Background(), loanID)
+	if err != nil {
+		t.Fatalf("Failed to read loan %d: %v", loanID, err)
+	}
+	return loan.Status
+}
+
+func TestRescanUnavailableScanner(t *testing.T) {
+	env := newRescanEnv(t)
+	ctx := context.Background()
+
+	moved, err := scheduler.RescanQuarantined(ctx, env.store, env.files, verdict{err: errors.New("clamd unavailable")})
+	if err != nil || len(moved) != 0 {
+		t.Fatalf("Expected nothing to move, got %v (err %v)", moved, err)
+	}
+	if env.status(t, 1) != "proposed" || env.status(t, 2) != "invested" {
+		t.Errorf("Expected the loans to keep waiting, got %s / %s", env.status(t, 1), env.status(t, 2))
+	}
+	if docs, _ := env.store.Documents().ListByScanStatus(ctx, models.ScanQuarantined); len(docs) != 3 {
+		t.Errorf("Expected all 3 documents to stay quarantined, got %d", len(docs))
+	}
+	if _, err := env.store.Approvals().Get(ctx, 1); err != nil {
+		t.Errorf("Expected the approval to be kept, got %v", err)
+	}
+}
+
+func TestRescanClean(t *testing.T) {
+	env := newRescanEnv(t)
+	ctx := context.Background()
+
+	moved, err := scheduler.RescanQuarantined(ctx, env.store, env.files, verdict{})
+	if err != nil || len(moved) != 2 {
+		t.Fatalf("Expected loans 1 and 2 to move, got %v (err %v)", moved, err)
+	}
+	if env.status(t, 1) != "approved" || env.status(t, 2) != "disbursed" || env.status(t, 3) != "approved" {
+		t.Errorf("Unexpected statuses %s / %s / %s", env.status(t, 1), env.status(t, 2), env.status(t, 3))
+	}
+	for _, doc := range []models.Document{env.proof, env.thumbnail, env.agreement} {
+		got, err := env.store.Documents().GetByKey(ctx, doc.Key)
+		if err != nil || got.ScanStatus != models.ScanClean || got.ScannedAt == "" {
+			t.Errorf("Expected %s to be released, got %+v (err %v)", doc.Key, got, err)
+		}
+	}
+
+	if installments, err := env.store.Repayments().Installments(ctx, 2); err != nil || len(installments) != 6 {
+		t.Errorf("Expected the disbursed loan to get its 6 month schedule, got %d (err %v)", len(installments), err)
+	}
+
+	// The system made the move, for the reason the loans were waiting
+	history, _ := env.store.Loans().StatusHistory(ctx, 1)
+	last := history[len(history)-1]
+	if last.ToStatus != "approved" || last.ActorID != 0 || last.Reason != "visit proof passed the virus scan" {
+		t.Errorf("Unexpected history entry %+v", last)
+	}
+
+	// Nothing is left to do on the next run
+	if moved, err := scheduler.RescanQuarantined(ctx, env.store, env.files, verdict{}); err != nil || len(moved) != 0 {
+		t.Errorf("Expected nothing left to release, got %v (err %v)", moved, err)
+	}
+}
+
+func TestRescanInfected(t *testing.T) {
+	env := newRescanEnv(t)
+	ctx := context.Background()
+
+	moved, err := scheduler.RescanQuarantined(ctx, env.store, env.files, infected)
+	if err != nil || len(moved) != 0 {
+		t.Fatalf("Expected nothing to move, got %v (err %v)", moved, err)
+	}
+	if env.status(t, 1) != "proposed" || env.status(t, 2) != "invested" {
+		t.Errorf("Expected the loans to stay where they were, got %s / %s", env.status(t, 1), env.status(t, 2))
+	}
+	if _, err := env.store.Approvals().Get(ctx, 1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the approval waiting for the proof to be removed, got %v", err)
+	}
+	if _, err := env.store.Disbursements().Get(ctx, 2); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the disbursement waiting for the agreement to be removed, got %v", err)
+	}
+	if _, err := env.store.Approvals().Get(ctx, 3); err != nil {
+		t.Errorf("Expected an approval with a clean proof to be kept, got %v", err)
+	}
+	for _, doc := range []models.Document{env.proof, env.thumbnail, env.agreement} {
+		if _, err := env.store.Documents().GetByKey(ctx, doc.Key); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Expected the record of %s to be deleted, got %v", doc.Key, err)
+		}
+		if _, err := env.files.Get(ctx, doc.Key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Expected %s to be deleted from storage, got %v", doc.Key, err)
+		}
+	}
+}
+
+// An infected file keeps the approval of a loan that has already moved on.
+func TestRescanInfectedKeepsSettledLoans(t *testing.T) {
+	env := newRescanEnv(t)
+	ctx := context.Background()
+	env.store.Loans().UpdateStatus(ctx, 1, "proposed", "rejected")
+
+	if _, err := scheduler.RescanQuarantined(ctx, env.store, env.files, infected); err != nil {
+		t.Fatalf("RescanQuarantined failed: %v", err)
+	}
+	if _, err := env.store.Approvals().Get(ctx, 1); err != nil {
+		t.Errorf("Expected the approval of a rejected loan to be kept, got %v", err)
+	}
+	if _, err := env.store.Documents().GetByKey(ctx, env.proof.Key); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the infected proof to be deleted anyway, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocal(t.TempDir(), "/files/", []byte("secret"))
+
+	if err := local.Put(ctx, "loans/1/proof.jpg", strings.NewReader("photo"), "image/jpeg"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	r, err := local.Get(ctx, "loans/1/proof.jpg")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "photo" {
+		t.Errorf("Expected the stored file, got %q", got)
+	}
+	r.Close()
+
+	for _, key := range []string{"", "../outside", "/etc/passwd", "a/../../b", `a\b`} {
+		if err := local.Put(ctx, key, strings.NewReader("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Expected key %q to be refused, got %v", key, err)
+		}
+	}
+
+	// Signed URLs are served until they expire, and only for their key
+	get := func(url string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		local.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+		return resp
+	}
+	signed, _ := local.SignedURL(ctx, "loans/1/proof.jpg", time.Minute)
+	resp := get(signed)
+	if resp.Code != http.StatusOK || resp.Body.String() != "photo" || !strings.HasPrefix(resp.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("Expected the file as an attachment, got %d %q", resp.Code, resp.Body.String())
+	}
+	if resp := get(strings.Replace(signed, "proof.jpg", "other.jpg", 1)); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected the signature to be bound to its key, got %d", resp.Code)
+	}
+	expired, _ := local.SignedURL(ctx, "loans/1/proof.jpg", -time.Minute)
+	if resp := get(expired); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected an expired link to be refused, got %d", resp.Code)
+	}
+
+	if err := local.Delete(ctx, "loans/1/proof.jpg"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := local.Get(ctx, "loans/1/proof.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if resp := get(signed); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted file to be 404, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,39 @@
+// Package storage keeps uploaded and generated documents, such as approval
+// proofs and agreement PDFs, on local disk or in an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Storage stores objects under slash-separated keys such as
+// "agreements/loan1/borrower.pdf".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get returns ErrNotFound for a missing key. The caller closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete succeeds for a missing key.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL that downloads key as an attachment, without
+	// further authentication, until ttl has passed.
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// checkKey refuses keys that could leave the storage root.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) ||
+		path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"log"
+
+	"loan-service-engine/money"
 )
 
 type EmailPreview struct {
@@ -11,6 +13,13 @@ type EmailPreview struct {
 	Body    string `json:"body"`
 }
 
+// logComposed logs that an email was composed. Only the recipient and
+// subject are written: bodies carry live links and investors' amounts, and
+// reach the recipient through the configured mail sender alone.
+func logComposed(to, subject string) {
+	log.Printf("[Email composed]\nTo: %s\nSubject: %s\n", to, subject)
+}
+
 // Simulates sending an agreement email to one investor
 func ComposeAgreementEmail(to string, loanID int, agreementURL string) EmailPreview {
 	subject := fmt.Sprintf("Loan Agreement for Loan #%d", loanID)
@@ -24,7 +33,132 @@ https://localhost:8000%s
 Sincerely,
 Loan Service Team`, loanID, agreementURL)
 
-	log.Printf("[Email composed]\nTo: %s\nSubject: %s\n\n%s\n", to, subject, body)
+	logComposed(to, subject)
+
+	return EmailPreview{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+}
+
+// Simulates notifying an investor that a loan they funded was cancelled
+// and their investment has been released.
+func ComposeCancellationEmail(to string, loanID int, amount money.Amount, reason string) EmailPreview {
+	subject := fmt.Sprintf("Loan #%d Cancelled", loanID)
+	if reason == "" {
+		reason = "No reason given"
+	}
+	body := fmt.Sprintf(`Dear Investor,
+
+Loan #%d has been cancelled before it was fully funded.
+Reason: %s
+
+Your investment of Rp %s has been released and will be refunded to you.
+
+Sincerely,
+Loan Service Team`, loanID, reason, amount)
+
+	logComposed(to, subject)
+
+	return EmailPreview{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+}
+
+// Simulates notifying an investor that a loan expired before reaching its
+// funding goal and their investment has been released.
+func ComposeExpiryEmail(to string, loanID int, amount money.Amount, deadline string) EmailPreview {
+	subject := fmt.Sprintf("Loan #%d Expired", loanID)
+	body := fmt.Sprintf(`Dear Investor,
+
+Loan #%d did not reach its funding goal before the deadline (%s) and has expired.
+
+Your investment of Rp %s has been released and will be refunded to you.
+
+Sincerely,
+Loan Service Team`, loanID, deadline, amount)
+
+	logComposed(to, subject)
+
+	return EmailPreview{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+}
+
+// Simulates sending the email verification link to a newly registered user,
+// with the next steps for their role.
+func ComposeVerificationEmail(to, username, role, token string) EmailPreview {
+	subject := "Verify your Loan Service account"
+	nextSteps := "Once verified you can sign in."
+	switch role {
+	case "requester":
+		nextSteps = "Once verified you can sign in and propose your first loan. Have the borrower's 16-digit NIK ready."
+	case "investor":
+		nextSteps = "Once verified you can sign in and invest in approved loans that are still open for funding."
+	}
+	body := fmt.Sprintf(`Dear %s,
+
+Welcome to Loan Service. Please confirm your email address by opening the link below:
+
+https://localhost:8000/verify-email?token=%s
+
+%s
+
+Sincerely,
+Loan Service Team`, username, token, nextSteps)
+
+	logComposed(to, subject)
+
+	return EmailPreview{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+}
+
+// Simulates inviting someone to register an admin account.
+func ComposeAdminInviteEmail(to, invitedBy, token string) EmailPreview {
+	subject := "You have been invited to administer Loan Service"
+	body := fmt.Sprintf(`Hello,
+
+%s has invited you to create an admin account on Loan Service.
+Register with this email address and the invite token below:
+
+invite_token=%s
+
+Sincerely,
+Loan Service Team`, invitedBy, token)
+
+	logComposed(to, subject)
+
+	return EmailPreview{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+}
+
+// Simulates sending a password reset link to a user who forgot their password.
+func ComposePasswordResetEmail(to, username, token, expiresAt string) EmailPreview {
+	subject := "Reset your Loan Service password"
+	body := fmt.Sprintf(`Dear %s,
+
+We received a request to reset your password. Choose a new one by opening the link below:
+
+https://localhost:8000/reset-password?token=%s
+
+The link works once and expires at %s. Resetting your password signs you out on every device.
+If you did not ask for this, you can ignore this email; your password stays the same.
+
+Sincerely,
+Loan Service Team`, username, token, expiresAt)
+
+	logComposed(to, subject)
 
 	return EmailPreview{
 		To:      to,